@@ -2,9 +2,12 @@ package main
 
 import (
 	"Backend/config"
+	"Backend/policy"
 	"Backend/responses"
 	"Backend/utils"
+	"Backend/vault"
 	"database/sql"
+	"flag"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/valkey-io/valkey-go"
 	"golang.org/x/oauth2"
@@ -24,12 +27,19 @@ type application struct {
 	oauth *oauth2.Config
 
 	multiLLM *config.MultiLLM
+	keyring  *vault.Keyring
+	policy   *policy.Registry
 
 	util      *utils.Utils
 	responses *responses.ErrorResponses
+
+	workers []worker
 }
 
 func main() {
+	rotateKeys := flag.Bool("rotate-keys", false, "move every stored secret onto the active encryption key and exit")
+	flag.Parse()
+
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
 	db, err := config.NewDB()
@@ -57,6 +67,20 @@ func main() {
 		logger.Error(err.Error())
 	}
 
+	// Nothing that stores keys or content can work without a keyring.
+	keyring, err := config.NewKeyring()
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// A policy that didn't load would let everything through.
+	policyRegistry, err := config.NewPolicy()
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
 	util := utils.NewUtils(logger)
 	app := &application{
 		logger:    logger,
@@ -66,6 +90,16 @@ func main() {
 		util:      util,
 		responses: responses.NewErrorResponses(logger, util),
 		multiLLM:  multiLLM,
+		keyring:   keyring,
+		policy:    policyRegistry,
+	}
+
+	if *rotateKeys {
+		if err := app.rotateKeys(); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		return
 	}
 
 	if err := app.serve(); err != nil {
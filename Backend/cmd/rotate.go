@@ -0,0 +1,36 @@
+package main
+
+import (
+	"Backend/internal/apikey"
+	"Backend/internal/encryption"
+	"Backend/internal/session"
+	"Backend/internal/user"
+	"context"
+	"fmt"
+)
+
+// rotateKeys seals the refresh tokens still stored as plaintext and rewraps every
+// secret onto the active key. Run it with -rotate-keys after putting a new key at
+// the front of ENCRYPTION_KEYS, then the old key can be removed.
+func (app *application) rotateKeys() error {
+	ctx := context.Background()
+
+	sessionService := session.NewService(session.NewRepo(app.vkDB))
+	userService := user.NewService(user.NewRepo(app.db, app.vkDB), sessionService, app.oauth, app.keyring)
+	if err := userService.RotateRefreshTokens(ctx); err != nil {
+		return fmt.Errorf("rotate refresh tokens: %w", err)
+	}
+
+	apiKeyService := apikey.NewService(apikey.NewRepo(app.db), app.keyring)
+	if err := apiKeyService.RewrapKeys(ctx); err != nil {
+		return fmt.Errorf("rewrap api keys: %w", err)
+	}
+
+	encryptionService := encryption.NewService(encryption.NewRepo(app.db), app.keyring)
+	if err := encryptionService.RewrapDataKeys(ctx); err != nil {
+		return fmt.Errorf("rewrap data keys: %w", err)
+	}
+
+	app.logger.Info("rotated secrets", "key", app.keyring.ActiveKeyID())
+	return nil
+}
@@ -1,11 +1,22 @@
 package main
 
 import (
+	"Backend/internal/apikey"
 	"Backend/internal/chat"
+	"Backend/internal/encryption"
+	"Backend/internal/export"
+	"Backend/internal/generation"
+	"Backend/internal/importer"
+	"Backend/internal/job"
+	"Backend/internal/organize"
+	"Backend/internal/schedule"
 	"Backend/internal/session"
+	"Backend/internal/share"
+	"Backend/internal/template"
 	"Backend/internal/user"
 	"Backend/middleware"
 	"net/http"
+	"time"
 )
 
 func (app *application) route() http.Handler {
@@ -21,15 +32,75 @@ func (app *application) route() http.Handler {
 
 	middle := middleware.NewMiddleware(app.responses, app.util, sessionService)
 
-	userRepo := user.NewRepo(app.db, app.vkDB)
-	userService := user.NewService(userRepo, app.oauth)
-	userHandler := user.NewHandler(userService, sessionService, app.responses, app.util)
-	userHandler.RegisterRoutes(mux, middle)
+	apiKeyRepo := apikey.NewRepo(app.db)
+	apiKeyService := apikey.NewService(apiKeyRepo, app.keyring)
+	apiKeyHandler := apikey.NewHandler(apiKeyService, app.responses, app.util)
+	apiKeyHandler.RegisterRoutes(mux, middle)
+	app.addWorker("rewrap api keys", time.Hour, apiKeyService.RewrapKeys)
+
+	encryptionRepo := encryption.NewRepo(app.db)
+	encryptionService := encryption.NewService(encryptionRepo, app.keyring)
+	encryptionHandler := encryption.NewHandler(encryptionService, app.responses, app.util)
+	encryptionHandler.RegisterRoutes(mux, middle)
+	app.addWorker("rewrap data keys", time.Hour, encryptionService.RewrapDataKeys)
+
+	generationRepo := generation.NewRepo(app.vkDB)
+	generationService := generation.NewService(generationRepo)
+	generationHandler := generation.NewHandler(generationService, app.responses, app.util)
+	generationHandler.RegisterRoutes(mux, middle)
+
+	templateRepo := template.NewRepo(app.db)
+	templateService := template.NewService(templateRepo)
+	templateHandler := template.NewHandler(templateService, app.responses, app.util)
+	templateHandler.RegisterRoutes(mux, middle)
 
 	chatRepo := chat.NewRepo(app.db, app.vkDB)
-	chatService := chat.NewService(chatRepo, app.multiLLM)
-	chatHandler := chat.NewHandler(chatService, app.responses, app.util)
+	chatService := chat.NewService(chatRepo, apiKeyService, encryptionService, templateService, app.multiLLM, app.policy)
+	app.addWorker("purge chat trash", time.Hour, chatService.PurgeTrash)
+
+	jobRepo := job.NewRepo(app.db)
+	jobService := job.NewService(jobRepo, chatService, encryptionService, generationService, app.keyring, app.util, app.logger)
+	jobHandler := job.NewHandler(jobService, app.responses, app.util)
+	jobHandler.RegisterRoutes(mux, middle)
+	app.addWorker("run generation jobs", 5*time.Second, jobService.RunPending)
+	app.addWorker("purge finished jobs", time.Hour, jobService.PurgeFinishedJobs)
+
+	chatHandler := chat.NewHandler(chatService, jobService, generationService, app.responses, app.util)
 	chatHandler.RegisterRoutes(mux, middle)
 
+	scheduleRepo := schedule.NewRepo(app.db, app.vkDB)
+	scheduleService := schedule.NewService(scheduleRepo, chatService, templateService)
+	scheduleHandler := schedule.NewHandler(scheduleService, app.responses, app.util)
+	scheduleHandler.RegisterRoutes(mux, middle)
+	app.addWorker("run scheduled prompts", 30*time.Second, scheduleService.RunDue)
+
+	userRepo := user.NewRepo(app.db, app.vkDB)
+	userService := user.NewService(userRepo, sessionService, app.oauth, app.keyring)
+	userHandler := user.NewHandler(userService, sessionService, chatService, app.responses, app.util)
+	userHandler.RegisterRoutes(mux, middle)
+	app.addWorker("purge deleted accounts", 10*time.Minute, userService.PurgeDeletedAccounts)
+	app.addWorker("rotate refresh tokens", time.Hour, userService.RotateRefreshTokens)
+
+	organizeRepo := organize.NewRepo(app.db)
+	organizeService := organize.NewService(organizeRepo)
+	organizeHandler := organize.NewHandler(organizeService, app.responses, app.util)
+	organizeHandler.RegisterRoutes(mux, middle)
+
+	shareRepo := share.NewRepo(app.db)
+	shareService := share.NewService(shareRepo, encryptionService)
+	shareHandler := share.NewHandler(shareService, app.responses, app.util)
+	shareHandler.RegisterRoutes(mux, middle)
+
+	exportRepo := export.NewRepo(app.db)
+	exportService := export.NewService(exportRepo, encryptionService)
+	exportHandler := export.NewHandler(exportService, app.responses, app.util)
+	exportHandler.RegisterRoutes(mux, middle)
+	app.addWorker("purge expired data exports", time.Hour, exportService.PurgeExpiredDataExports)
+
+	importRepo := importer.NewRepo(app.db)
+	importService := importer.NewService(importRepo, encryptionService)
+	importHandler := importer.NewHandler(importService, app.responses, app.util)
+	importHandler.RegisterRoutes(mux, middle)
+
 	return middle.RecoverPanic(middle.EnableCORS(middle.RateLimit(middle.Authenticate(mux))))
 }
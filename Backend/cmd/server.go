@@ -21,6 +21,10 @@ func (app *application) serve() error {
 		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
 	}
 
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	app.startWorkers(workerCtx)
+
 	shutdownError := make(chan error)
 
 	go func() {
@@ -35,7 +39,9 @@ func (app *application) serve() error {
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 		defer cancel()
 
+		stopWorkers()
 		app.wg.Wait()
+		app.util.Wait()
 		shutdownError <- server.Shutdown(ctx)
 	}()
 
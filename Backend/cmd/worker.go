@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// worker is a maintenance task that runs every interval for the lifetime of the
+// server, such as purging accounts whose deletion grace period is over.
+type worker struct {
+	name     string
+	interval time.Duration
+	run      func(context.Context) error
+}
+
+func (app *application) addWorker(name string, interval time.Duration, run func(context.Context) error) {
+	app.workers = append(app.workers, worker{name: name, interval: interval, run: run})
+}
+
+// startWorkers runs every registered worker once straight away and then on its
+// interval until ctx is cancelled. The server waits for them on shutdown.
+func (app *application) startWorkers(ctx context.Context) {
+	for _, w := range app.workers {
+		app.wg.Add(1)
+
+		go func() {
+			defer app.wg.Done()
+
+			ticker := time.NewTicker(w.interval)
+			defer ticker.Stop()
+
+			for {
+				app.runWorker(ctx, w)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+}
+
+func (app *application) runWorker(ctx context.Context, w worker) {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.Error(fmt.Errorf("%s", err).Error(), "worker", w.name)
+		}
+	}()
+
+	if err := w.run(ctx); err != nil {
+		app.logger.Error(err.Error(), "worker", w.name)
+	}
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"os"
+	"time"
+)
+
+// AccountDeletionGracePeriod is how long a scheduled account deletion can still
+// be cancelled, read from ACCOUNT_DELETION_GRACE_PERIOD (e.g. "720h").
+func AccountDeletionGracePeriod() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD")); err == nil && d >= 0 {
+		return d
+	}
+	return 30 * 24 * time.Hour
+}
+
+// ChatTrashRetention is how long a deleted chat stays in the trash before it is
+// purged, read from CHAT_TRASH_RETENTION (e.g. "720h").
+func ChatTrashRetention() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("CHAT_TRASH_RETENTION")); err == nil && d >= 0 {
+		return d
+	}
+	return 30 * 24 * time.Hour
+}
+
+// GuestChatTTL is how long an anonymous chat is kept after its last message,
+// read from GUEST_CHAT_TTL (e.g. "24h").
+func GuestChatTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("GUEST_CHAT_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return 24 * time.Hour
+}
@@ -1,40 +1,159 @@
 package config
 
 import (
+	"Backend/provider"
 	"context"
 	"github.com/tmc/langchaingo/llms/anthropic"
 	"github.com/tmc/langchaingo/llms/googleai"
 	"github.com/tmc/langchaingo/llms/openai"
+	"google.golang.org/api/option"
 	"os"
+	"strings"
 )
 
-var LLMLists = map[string][]string{
+// catalogEntry is a model users can chat with. Reasoning is how it takes a reasoning
+// level, empty for models that don't reason before they answer.
+type catalogEntry struct {
+	Name      string
+	Reasoning string
+}
+
+const (
+	// reasoningEffort is a reasoning effort, as OpenAI's o-series take it.
+	reasoningEffort = "effort"
+	// reasoningBudget is a thinking budget in tokens, as Claude takes it.
+	reasoningBudget = "budget"
+)
+
+// catalog is the models of each model type, the first being the default.
+// Gemini's models have no reasoning, as its SDK can neither set how hard they
+// think nor return their thoughts.
+var catalog = map[string][]catalogEntry{
 	"OpenAI": {
-		"gpt-4.1-nano",
-		"gpt-4.1-mini",
-		"gpt-4.1",
-		"gpt-4o",
-		"gpt-4o-mini",
-		"o4-mini",
-		"o3",
-		"o3-mini",
-		"o3-pro",
-		"gpt-4.5-preview",
+		{Name: "gpt-4.1-nano"},
+		{Name: "gpt-4.1-mini"},
+		{Name: "gpt-4.1"},
+		{Name: "gpt-4o"},
+		{Name: "gpt-4o-mini"},
+		{Name: "o4-mini", Reasoning: reasoningEffort},
+		{Name: "o3", Reasoning: reasoningEffort},
+		{Name: "o3-mini", Reasoning: reasoningEffort},
+		{Name: "o3-pro", Reasoning: reasoningEffort},
+		{Name: "gpt-4.5-preview"},
 	},
 	"Google": {
-		"gemini-2.5-flash-preview-05-20",
-		"gemini-2.5-pro-preview-06-05",
-		"gemini-2.0-flash",
-		"gemini-2.0-flash-lite",
+		{Name: "gemini-2.5-flash-preview-05-20"},
+		{Name: "gemini-2.5-pro-preview-06-05"},
+		{Name: "gemini-2.0-flash"},
+		{Name: "gemini-2.0-flash-lite"},
 	},
 	"Anthropic": {
-		"claude-sonnet-4-0",
-		"claude-opus-4-0",
-		"claude-3-7-sonnet-latest",
-		"claude-3-5-sonnet-latest",
+		{Name: "claude-sonnet-4-0", Reasoning: reasoningBudget},
+		{Name: "claude-opus-4-0", Reasoning: reasoningBudget},
+		{Name: "claude-3-7-sonnet-latest", Reasoning: reasoningBudget},
+		{Name: "claude-3-5-sonnet-latest"},
 	},
 }
 
+// LLMLists are the names of the models of each model type, the first being the
+// default.
+var LLMLists = func() map[string][]string {
+	lists := make(map[string][]string, len(catalog))
+	for modelType, llms := range catalog {
+		for _, llm := range llms {
+			lists[modelType] = append(lists[modelType], llm.Name)
+		}
+	}
+	return lists
+}()
+
+// ReasoningLevels are how hard a prompt can ask a reasoning model to think.
+var ReasoningLevels = []string{"low", "medium", "high"}
+
+func findLLM(model string) (catalogEntry, bool) {
+	for _, llms := range catalog {
+		for _, llm := range llms {
+			if llm.Name == model {
+				return llm, true
+			}
+		}
+	}
+	return catalogEntry{}, false
+}
+
+// thinkingBudgets are the thinking budgets of the reasoning levels, in tokens.
+var thinkingBudgets = map[string]int{
+	"low":    2048,
+	"medium": 8192,
+	"high":   24576,
+}
+
+func SupportsReasoning(model string) bool {
+	llm, _ := findLLM(model)
+	return llm.Reasoning != ""
+}
+
+// ReasoningParams turns a reasoning level into the parameters of a reasoning
+// model. An empty level leaves the provider's default.
+func ReasoningParams(model string, level string) provider.Reasoning {
+	if llm, _ := findLLM(model); llm.Reasoning == reasoningBudget {
+		return provider.Reasoning{BudgetTokens: thinkingBudgets[level]}
+	}
+	return provider.Reasoning{Effort: level}
+}
+
+// titleModels are the cheap models used to name new chats, keyed by model type.
+// Each one can be overridden with TITLE_MODEL_<MODEL TYPE>, e.g. TITLE_MODEL_OPENAI.
+var titleModels = map[string]string{
+	"OpenAI":    "gpt-4.1-nano",
+	"Google":    "gemini-2.0-flash-lite",
+	"Anthropic": "claude-3-5-haiku-latest",
+}
+
+func TitleModel(modelType string) string {
+	if model := os.Getenv("TITLE_MODEL_" + strings.ToUpper(modelType)); model != "" {
+		return model
+	}
+	return titleModels[modelType]
+}
+
+// providerBaseURLs are the API roots of each provider, keyed by model type. Each
+// one can be pointed somewhere else, such as a local stub, with OPENAI_BASE_URL,
+// GEMINI_BASE_URL or ANTHROPIC_BASE_URL.
+var providerBaseURLs = map[string]string{
+	"OpenAI":    "https://api.openai.com/v1",
+	"Google":    "https://generativelanguage.googleapis.com/v1beta",
+	"Anthropic": "https://api.anthropic.com/v1",
+}
+
+var providerEnvPrefixes = map[string]string{
+	"OpenAI":    "OPENAI",
+	"Google":    "GEMINI",
+	"Anthropic": "ANTHROPIC",
+}
+
+// ProviderBaseURL is used for key checks and by the model clients, so a key is
+// checked against the API it will be used with.
+func ProviderBaseURL(modelType string) string {
+	if baseURL := os.Getenv(providerEnvPrefixes[modelType] + "_BASE_URL"); baseURL != "" {
+		return strings.TrimSuffix(baseURL, "/")
+	}
+	return providerBaseURLs[modelType]
+}
+
+// GoogleEndpoint points the Google client at GEMINI_BASE_URL when it's set. The
+// client speaks gRPC to Google, so it's made to speak REST to anything else.
+func GoogleEndpoint() []googleai.Option {
+	if os.Getenv("GEMINI_BASE_URL") == "" {
+		return nil
+	}
+	// The client adds the API version to the endpoint itself.
+	endpoint := strings.TrimSuffix(ProviderBaseURL("Google"), "/v1beta")
+	return []googleai.Option{googleai.WithRest(), func(o *googleai.Options) {
+		o.ClientOptions = append(o.ClientOptions, option.WithEndpoint(endpoint))
+	}}
+}
+
 type MultiLLM struct {
 	OpenAI   *openai.LLM
 	GoogleAI *googleai.GoogleAI
@@ -42,17 +161,17 @@ type MultiLLM struct {
 }
 
 func NewAI() (*MultiLLM, error) {
-	openAI, err := openai.New(openai.WithToken(os.Getenv("OPENAI_API_KEY")), openai.WithModel(LLMLists["OpenAI"][0]))
+	openAI, err := openai.New(openai.WithToken(os.Getenv("OPENAI_API_KEY")), openai.WithModel(LLMLists["OpenAI"][0]), openai.WithBaseURL(ProviderBaseURL("OpenAI")), openai.WithHTTPClient(provider.NewClient()))
 	if err != nil {
 		return nil, err
 	}
 
-	googleAI, err := googleai.New(context.Background(), googleai.WithAPIKey(os.Getenv("GEMINI_API_KEY")), googleai.WithDefaultModel(LLMLists["Google"][0]))
+	googleAI, err := googleai.New(context.Background(), append(GoogleEndpoint(), googleai.WithAPIKey(os.Getenv("GEMINI_API_KEY")), googleai.WithDefaultModel(LLMLists["Google"][0]))...)
 	if err != nil {
 		return nil, err
 	}
 
-	antAI, err := anthropic.New(anthropic.WithToken(os.Getenv("ANTHROPIC_API_KEY")), anthropic.WithModel(LLMLists["Anthropic"][0]))
+	antAI, err := anthropic.New(anthropic.WithToken(os.Getenv("ANTHROPIC_API_KEY")), anthropic.WithModel(LLMLists["Anthropic"][0]), anthropic.WithBaseURL(ProviderBaseURL("Anthropic")), anthropic.WithHTTPClient(provider.NewClient()))
 	if err != nil {
 		return nil, err
 	}
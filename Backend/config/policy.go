@@ -0,0 +1,80 @@
+package config
+
+import (
+	"Backend/policy"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// NewPolicy registers the built-in policy hooks that are turned on:
+//   - POLICY_DENYLIST, a regular expression prompts and replies may not match,
+//     and POLICY_DENYLIST_ACTION, what is done when they do: block (the
+//     default), mask or flag.
+//   - POLICY_MAX_PROMPT_LENGTH and POLICY_MAX_REPLY_LENGTH, in characters.
+//   - POLICY_MODERATION=openai, to have OpenAI's moderation API check prompts
+//     and replies.
+func NewPolicy() (*policy.Registry, error) {
+	registry := policy.NewRegistry()
+
+	if pattern := os.Getenv("POLICY_DENYLIST"); pattern != "" {
+		action := policy.Action(os.Getenv("POLICY_DENYLIST_ACTION"))
+		if action == "" {
+			action = policy.ActionBlock
+		}
+		denylist, err := policy.NewDenylist(pattern, action)
+		if err != nil {
+			return nil, fmt.Errorf("POLICY_DENYLIST: %w", err)
+		}
+		registry.Register(denylist, policy.StagePrompt, policy.StageReply)
+	}
+
+	maxPrompt, err := lengthLimit("POLICY_MAX_PROMPT_LENGTH")
+	if err != nil {
+		return nil, err
+	}
+	maxReply, err := lengthLimit("POLICY_MAX_REPLY_LENGTH")
+	if err != nil {
+		return nil, err
+	}
+	if maxPrompt > 0 || maxReply > 0 {
+		registry.Register(policy.NewMaxLength(maxPrompt, maxReply), policy.StagePrompt, policy.StageReply)
+	}
+
+	switch moderation := os.Getenv("POLICY_MODERATION"); moderation {
+	case "":
+	case "openai":
+		registry.Register(policy.NewModeration(ProviderBaseURL("OpenAI"), os.Getenv("OPENAI_API_KEY")), policy.StagePrompt, policy.StageReply)
+	default:
+		return nil, fmt.Errorf("POLICY_MODERATION: unknown moderation provider %q", moderation)
+	}
+
+	return registry, nil
+}
+
+func lengthLimit(key string) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("%s: must be a positive number of characters", key)
+	}
+	return limit, nil
+}
+
+// RedactPrompts is whether secrets and personal data are replaced by
+// placeholders in what is sent to models, read from REDACT_PROMPTS. It's on
+// unless turned off.
+func RedactPrompts() bool {
+	redact, err := strconv.ParseBool(os.Getenv("REDACT_PROMPTS"))
+	return err != nil || redact
+}
+
+// RestoreRedactions is whether the values behind placeholders are put back in
+// replies, read from REDACT_RESTORE. It's on unless turned off.
+func RestoreRedactions() bool {
+	restore, err := strconv.ParseBool(os.Getenv("REDACT_RESTORE"))
+	return err != nil || restore
+}
@@ -0,0 +1,13 @@
+package config
+
+import (
+	"Backend/vault"
+	"os"
+)
+
+// NewKeyring loads the key encryption keys from ENCRYPTION_KEYS, a comma
+// separated list of "id:base64key" pairs with the active key first. To rotate,
+// put a new key at the front and keep the old ones until everything is rewrapped.
+func NewKeyring() (*vault.Keyring, error) {
+	return vault.NewKeyring(os.Getenv("ENCRYPTION_KEYS"))
+}
@@ -0,0 +1,172 @@
+package apikey
+
+import (
+	"Backend/middleware"
+	"Backend/responses"
+	"Backend/userContext"
+	"Backend/utils"
+	"Backend/validator"
+	"errors"
+	"net/http"
+)
+
+var checkFailure = map[string]string{
+	StatusInvalid:           "was rejected by the provider",
+	StatusQuotaExhausted:    "has no quota left with the provider",
+	StatusModelNotPermitted: "is not permitted to use the model",
+}
+
+type Handler struct {
+	service IService
+	er      *responses.ErrorResponses
+	utils   *utils.Utils
+}
+
+func NewHandler(service IService, er *responses.ErrorResponses, utils *utils.Utils) *Handler {
+	return &Handler{
+		service: service,
+		er:      er,
+		utils:   utils,
+	}
+}
+
+func (h *Handler) RegisterRoutes(mux *http.ServeMux, middle *middleware.Middleware) {
+	mux.HandleFunc("GET /v1/account/keys", middle.RequireAuthenticatedUser(h.getKeysHandler))
+	mux.HandleFunc("PUT /v1/account/keys/{model_type}", middle.RequireAuthenticatedUser(h.saveKeyHandler))
+	mux.HandleFunc("DELETE /v1/account/keys/{model_type}", middle.RequireAuthenticatedUser(h.deleteKeyHandler))
+	mux.HandleFunc("POST /v1/account/keys/{model_type}/check", middle.RequireAuthenticatedUser(h.checkKeyHandler))
+}
+
+func (h *Handler) getKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := userContext.ContextGetUser(r)
+
+	keys, err := h.service.getKeys(user.ID)
+	if err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"keys": keys}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) saveKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Key   string `json:"key"`
+		Model string `json:"model"`
+	}
+	if err := h.utils.ReadJSON(w, r, &input); err != nil {
+		h.er.BadRequestResponse(w, r, err)
+		return
+	}
+
+	modelType := r.PathValue("model_type")
+
+	v := validator.New()
+	h.service.checkModelType(v, modelType)
+	h.service.checkModel(v, modelType, input.Model)
+	if h.service.checkKey(v, input.Key); !v.Valid() {
+		h.er.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	key, check, err := h.service.saveKey(user.ID, modelType, input.Model, input.Key)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrProviderUnavailable):
+			h.er.LogError(r, err)
+			h.er.ProviderUnavailableResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !check.Valid() {
+		v.AddError("key", checkFailure[check.Status])
+		h.er.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"key": key, "check": check}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+// checkKeyHandler checks the key in the body, or the stored key when the body
+// has none, without saving anything.
+func (h *Handler) checkKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Key   string `json:"key"`
+		Model string `json:"model"`
+	}
+	if err := h.utils.ReadJSON(w, r, &input); err != nil {
+		h.er.BadRequestResponse(w, r, err)
+		return
+	}
+
+	modelType := r.PathValue("model_type")
+
+	v := validator.New()
+	h.service.checkModelType(v, modelType)
+	h.service.checkModel(v, modelType, input.Model)
+	if input.Key != "" {
+		h.service.checkKey(v, input.Key)
+	}
+	if !v.Valid() {
+		h.er.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+
+	var check *Check
+	var err error
+	if input.Key != "" {
+		check, err = h.service.checkKeyWithProvider(modelType, input.Model, input.Key)
+	} else {
+		check, err = h.service.checkStoredKey(user.ID, modelType, input.Model)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		case errors.Is(err, ErrProviderUnavailable):
+			h.er.LogError(r, err)
+			h.er.ProviderUnavailableResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"check": check}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) deleteKeyHandler(w http.ResponseWriter, r *http.Request) {
+	modelType := r.PathValue("model_type")
+
+	v := validator.New()
+	if h.service.checkModelType(v, modelType); !v.Valid() {
+		h.er.NotFoundResponse(w, r)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	if err := h.service.deleteKey(user.ID, modelType); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "Key Deletion Successful!"}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,29 @@
+package apikey
+
+import "errors"
+
+// ErrProviderUnavailable means the provider couldn't be asked about a key, so
+// nothing is known about whether the key works.
+var ErrProviderUnavailable = errors.New("model provider unavailable")
+
+const (
+	StatusValid             = "valid"
+	StatusInvalid           = "invalid"
+	StatusQuotaExhausted    = "quota_exhausted"
+	StatusModelNotPermitted = "model_not_permitted"
+)
+
+// Check is what a provider said about a key. Model is the model it was checked
+// for. Models lists the models the key can list and is only filled in for keys
+// the provider accepted.
+type Check struct {
+	ModelType string   `json:"model_type"`
+	Model     string   `json:"model"`
+	Status    string   `json:"status"`
+	Message   string   `json:"message,omitempty"`
+	Models    []string `json:"models"`
+}
+
+func (c *Check) Valid() bool {
+	return c.Status == StatusValid
+}
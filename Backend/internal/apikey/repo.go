@@ -0,0 +1,171 @@
+package apikey
+
+import (
+	"Backend/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Key is a stored provider key as shown to its owner. The secret itself is never
+// sent back, only a masked hint of its last characters.
+type Key struct {
+	ModelType string    `json:"model_type"`
+	Masked    string    `json:"masked"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type sealedKey struct {
+	userID    string
+	modelType string
+	secret    string
+}
+
+type repo interface {
+	getKeys(string) ([]Key, error)
+	getSecret(string, string) (string, error)
+	upsertKey(string, string, string, string) (*Key, error)
+	deleteKey(string, string) error
+	getStaleKeys(string, int) ([]sealedKey, error)
+	updateSecret(sealedKey, string) error
+}
+
+type Model struct {
+	db *sql.DB
+}
+
+func NewRepo(db *sql.DB) *Model {
+	return &Model{db: db}
+}
+
+func (m *Model) getKeys(userID string) ([]Key, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, "SELECT model_type, hint, created_at, updated_at FROM api_key WHERE user_id = $1 ORDER BY model_type", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	keys := []Key{}
+	for rows.Next() {
+		var key Key
+		var hint string
+		if err := rows.Scan(&key.ModelType, &hint, &key.CreatedAt, &key.UpdatedAt); err != nil {
+			return nil, err
+		}
+		key.Masked = mask(hint)
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (m *Model) getSecret(userID string, modelType string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var secret string
+	err := m.db.QueryRowContext(ctx, "SELECT secret FROM api_key WHERE user_id = $1 AND model_type = $2", userID, modelType).Scan(&secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", utils.ErrRecordNotFound
+		}
+		return "", err
+	}
+
+	return secret, nil
+}
+
+func (m *Model) upsertKey(userID string, modelType string, secret string, hint string) (*Key, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO api_key (user_id, model_type, secret, hint)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, model_type) DO UPDATE
+		SET secret = EXCLUDED.secret, hint = EXCLUDED.hint, updated_at = NOW()
+		RETURNING created_at, updated_at`
+
+	key := &Key{ModelType: modelType, Masked: mask(hint)}
+	if err := m.db.QueryRowContext(ctx, query, userID, modelType, secret, hint).Scan(&key.CreatedAt, &key.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (m *Model) deleteKey(userID string, modelType string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, "DELETE FROM api_key WHERE user_id = $1 AND model_type = $2", userID, modelType)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return utils.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// getStaleKeys returns keys that aren't wrapped with the active key yet.
+func (m *Model) getStaleKeys(activeKeyID string, limit int) ([]sealedKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, "SELECT user_id, model_type, secret FROM api_key WHERE split_part(secret, '.', 1) <> $1 LIMIT $2", activeKeyID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	var keys []sealedKey
+	for rows.Next() {
+		var key sealedKey
+		if err := rows.Scan(&key.userID, &key.modelType, &key.secret); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// updateSecret swaps in a rewrapped secret, unless the key was replaced since
+// it was read.
+func (m *Model) updateSecret(key sealedKey, secret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, "UPDATE api_key SET secret = $1 WHERE user_id = $2 AND model_type = $3 AND secret = $4", secret, key.userID, key.modelType, key.secret)
+	return err
+}
+
+func mask(hint string) string {
+	return "****" + hint
+}
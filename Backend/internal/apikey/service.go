@@ -0,0 +1,344 @@
+package apikey
+
+import (
+	"Backend/config"
+	"Backend/utils"
+	"Backend/validator"
+	"Backend/vault"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+type IService interface {
+	getKeys(string) ([]Key, error)
+	saveKey(string, string, string, string) (*Key, *Check, error)
+	deleteKey(string, string) error
+	checkKeyWithProvider(string, string, string) (*Check, error)
+	checkStoredKey(string, string, string) (*Check, error)
+	checkModelType(*validator.Validator, string)
+	checkModel(*validator.Validator, string, string)
+	checkKey(*validator.Validator, string)
+	GetKey(string, string) (string, error)
+	RewrapKeys(context.Context) error
+}
+
+// rewrapBatchSize is how many keys RewrapKeys moves to the active key per query.
+const rewrapBatchSize = 100
+
+type service struct {
+	repo    repo
+	keyring *vault.Keyring
+	client  *http.Client
+}
+
+func NewService(repo repo, keyring *vault.Keyring) IService {
+	return &service{
+		repo:    repo,
+		keyring: keyring,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *service) getKeys(userID string) ([]Key, error) {
+	return s.repo.getKeys(userID)
+}
+
+// saveKey stores a key once the provider has accepted it for model. When it
+// hasn't, the key isn't stored and the check says why.
+func (s *service) saveKey(userID string, modelType string, model string, key string) (*Key, *Check, error) {
+	check, err := s.checkKeyWithProvider(modelType, model, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !check.Valid() {
+		return nil, check, nil
+	}
+
+	secret, err := s.keyring.SealWith([]byte(key), associatedData(userID, modelType))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stored, err := s.repo.upsertKey(userID, modelType, secret, key[len(key)-4:])
+	if err != nil {
+		return nil, nil, err
+	}
+	return stored, check, nil
+}
+
+func (s *service) deleteKey(userID string, modelType string) error {
+	return s.repo.deleteKey(userID, modelType)
+}
+
+func (s *service) checkModelType(v *validator.Validator, modelType string) {
+	_, ok := config.LLMLists[modelType]
+	v.Check(ok, "model_type", "must be one of OpenAI, Google or Anthropic")
+}
+
+// checkModel checks the model a key is checked for, "" being the model type's
+// default.
+func (s *service) checkModel(v *validator.Validator, modelType string, model string) {
+	v.Check(validator.In(model, append(config.LLMLists[modelType], "")...), "model", "must be a model of the model type")
+}
+
+func (s *service) checkKey(v *validator.Validator, key string) {
+	v.Check(key != "", "key", "must be provided")
+	v.Check(len(key) >= 8, "key", "must be at least 8 bytes long")
+	v.Check(len(key) <= 500, "key", "must not be more than 500 bytes long")
+	v.Check(strings.TrimSpace(key) == key, "key", "must not start or end with whitespace")
+}
+
+// checkStoredKey runs checkKeyWithProvider on the key the user has stored.
+func (s *service) checkStoredKey(userID string, modelType string, model string) (*Check, error) {
+	key, err := s.GetKey(userID, modelType)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return nil, utils.ErrRecordNotFound
+	}
+	return s.checkKeyWithProvider(modelType, model, key)
+}
+
+// checkKeyWithProvider asks the provider which models the key can list, the
+// cheapest authenticated call each of them has, and then for the model it's
+// checked for, as a key can list models it may not use. An empty model is the
+// model type's default.
+func (s *service) checkKeyWithProvider(modelType string, model string, key string) (*Check, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if model == "" {
+		model = config.LLMLists[modelType][0]
+	}
+	check := &Check{ModelType: modelType, Model: model, Models: []string{}}
+
+	body, err := s.askProvider(ctx, check, key, "/models")
+	if err != nil {
+		return nil, err
+	}
+	if check.Status != "" {
+		return check, nil
+	}
+	if check.Models, err = parseModels(modelType, body); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	if len(check.Models) == 0 {
+		check.Status = StatusModelNotPermitted
+		return check, nil
+	}
+
+	// Anthropic only knows its model aliases, such as claude-sonnet-4-0, when
+	// they're asked for one by one.
+	if _, err := s.askProvider(ctx, check, key, "/models/"+url.PathEscape(model)); err != nil {
+		return nil, err
+	}
+	if check.Status == "" {
+		check.Status = StatusValid
+	}
+	return check, nil
+}
+
+// askProvider makes an authenticated GET request to the provider and returns the
+// body of a successful response. When the provider refuses the key, the check's
+// status says why and there is no body.
+func (s *service) askProvider(ctx context.Context, check *Check, key string, path string) ([]byte, error) {
+	req, err := newProviderRequest(ctx, check.ModelType, key, path)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	defer func(body io.ReadCloser) {
+		if err := body.Close(); err != nil {
+			return
+		}
+	}(res.Body)
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 4<<20))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	if res.StatusCode == http.StatusOK {
+		return body, nil
+	}
+
+	var providerErr struct {
+		Error struct {
+			Message string `json:"message"`
+			Code    any    `json:"code"`
+		} `json:"error"`
+	}
+	_ = json.Unmarshal(body, &providerErr)
+	check.Message = providerErr.Error.Message
+
+	switch {
+	case res.StatusCode == http.StatusUnauthorized:
+		check.Status = StatusInvalid
+	case res.StatusCode == http.StatusForbidden:
+		check.Status = StatusModelNotPermitted
+	case res.StatusCode == http.StatusNotFound && path != "/models":
+		// A model the key has no access to is one it can't find.
+		check.Status = StatusModelNotPermitted
+	case res.StatusCode == http.StatusPaymentRequired:
+		check.Status = StatusQuotaExhausted
+	case res.StatusCode == http.StatusTooManyRequests:
+		// OpenAI also sends 429 for plain rate limits, which says nothing
+		// about the key.
+		if check.ModelType == "OpenAI" && providerErr.Error.Code != "insufficient_quota" {
+			return nil, fmt.Errorf("%w: %s is rate limiting", ErrProviderUnavailable, check.ModelType)
+		}
+		check.Status = StatusQuotaExhausted
+	case res.StatusCode == http.StatusBadRequest && check.ModelType == "Anthropic" && strings.Contains(check.Message, "credit balance"):
+		check.Status = StatusQuotaExhausted
+	case res.StatusCode == http.StatusBadRequest && check.ModelType == "Google":
+		// Google answers a bad key with 400 API_KEY_INVALID.
+		check.Status = StatusInvalid
+	default:
+		return nil, fmt.Errorf("%w: %s returned %d", ErrProviderUnavailable, check.ModelType, res.StatusCode)
+	}
+	return nil, nil
+}
+
+func newProviderRequest(ctx context.Context, modelType string, key string, path string) (*http.Request, error) {
+	baseURL := config.ProviderBaseURL(modelType)
+
+	var req *http.Request
+	var err error
+	switch modelType {
+	case "OpenAI":
+		if req, err = http.NewRequestWithContext(ctx, http.MethodGet, baseURL+path, nil); err == nil {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+	case "Google":
+		if path == "/models" {
+			path += "?pageSize=1000"
+		}
+		if req, err = http.NewRequestWithContext(ctx, http.MethodGet, baseURL+path, nil); err == nil {
+			req.Header.Set("x-goog-api-key", key)
+		}
+	case "Anthropic":
+		if path == "/models" {
+			path += "?limit=1000"
+		}
+		if req, err = http.NewRequestWithContext(ctx, http.MethodGet, baseURL+path, nil); err == nil {
+			req.Header.Set("x-api-key", key)
+			req.Header.Set("anthropic-version", "2023-06-01")
+		}
+	default:
+		return nil, fmt.Errorf("invalid model type: %s", modelType)
+	}
+
+	return req, err
+}
+
+// parseModels reads the model IDs from a list models response. Google lists
+// embedding models too, so only the ones that can generate content are kept.
+func parseModels(modelType string, body []byte) ([]string, error) {
+	models := []string{}
+
+	if modelType == "Google" {
+		var list struct {
+			Models []struct {
+				Name                       string   `json:"name"`
+				SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+			} `json:"models"`
+		}
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, err
+		}
+		for _, model := range list.Models {
+			if slices.Contains(model.SupportedGenerationMethods, "generateContent") {
+				models = append(models, strings.TrimPrefix(model.Name, "models/"))
+			}
+		}
+	} else {
+		var list struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, err
+		}
+		for _, model := range list.Data {
+			models = append(models, model.ID)
+		}
+	}
+
+	slices.Sort(models)
+	return models, nil
+}
+
+// GetKey returns the user's own key for a provider, or "" if they haven't
+// stored one.
+func (s *service) GetKey(userID string, modelType string) (string, error) {
+	secret, err := s.repo.getSecret(userID, modelType)
+	if err != nil {
+		if errors.Is(err, utils.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	key, err := s.keyring.OpenWith(secret, associatedData(userID, modelType))
+	if err != nil {
+		return "", fmt.Errorf("open %s key: %w", modelType, err)
+	}
+	return string(key), nil
+}
+
+// associatedData binds a sealed key to its user and model type, so a secret
+// copied into another user's row, or another provider's, won't open.
+func associatedData(userID string, modelType string) string {
+	return "api_key:" + userID + ":" + modelType
+}
+
+// RewrapKeys moves every stored key onto the active key encryption key, so an
+// old key can be dropped from ENCRYPTION_KEYS once this has run.
+func (s *service) RewrapKeys(ctx context.Context) error {
+	activeKeyID := s.keyring.ActiveKeyID()
+	if activeKeyID == "" {
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		keys, err := s.repo.getStaleKeys(activeKeyID, rewrapBatchSize)
+		if err != nil {
+			return err
+		}
+
+		rewrapped := 0
+		for _, key := range keys {
+			secret, _, err := s.keyring.Rewrap(key.secret)
+			if err != nil {
+				// Keys sealed with a key that was already removed can't be
+				// recovered, skip them instead of failing the whole run.
+				continue
+			}
+			if err := s.repo.updateSecret(key, secret); err != nil {
+				return err
+			}
+			rewrapped++
+		}
+
+		if len(keys) < rewrapBatchSize || rewrapped == 0 {
+			return nil
+		}
+	}
+}
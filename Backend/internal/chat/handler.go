@@ -1,44 +1,117 @@
 package chat
 
 import (
+	"Backend/internal/generation"
+	"Backend/internal/job"
 	"Backend/middleware"
+	"Backend/policy"
 	"Backend/responses"
 	"Backend/userContext"
 	"Backend/utils"
+	"Backend/validator"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"regexp"
+	"time"
 )
 
 type Handler struct {
-	chatService IService
-	er          *responses.ErrorResponses
-	utils       *utils.Utils
+	chatService       IService
+	jobService        job.IService
+	generationService generation.IService
+	er                *responses.ErrorResponses
+	utils             *utils.Utils
 }
 
-func NewHandler(chatService IService, er *responses.ErrorResponses, utils *utils.Utils) *Handler {
+func NewHandler(chatService IService, jobService job.IService, generationService generation.IService, er *responses.ErrorResponses, utils *utils.Utils) *Handler {
 	return &Handler{
-		chatService: chatService,
-		er:          er,
-		utils:       utils,
+		chatService:       chatService,
+		jobService:        jobService,
+		generationService: generationService,
+		er:                er,
+		utils:             utils,
 	}
 }
 
 func (h *Handler) RegisterRoutes(mux *http.ServeMux, middle *middleware.Middleware) {
 	mux.HandleFunc("GET /v1/chat", middle.RequireAuthenticatedUser(h.getTitlesHandler))
+	mux.HandleFunc("GET /v1/chat/search", middle.RequireAuthenticatedUser(h.searchHandler))
 	mux.HandleFunc("GET /v1/chat/{id}", middle.RequireAuthenticatedUser(h.getCurrentChatHistoryHandler))
+	mux.HandleFunc("PATCH /v1/chat/{id}", middle.RequireAuthenticatedUser(h.updateChatHandler))
 	mux.HandleFunc("POST /v1/chat", h.sendMessageHandler)
+	mux.HandleFunc("GET /v1/chat/{id}/stream", h.resumeStreamHandler)
 	mux.HandleFunc("DELETE /v1/chat", middle.RequireAuthenticatedUser(h.deleteChatHandler))
+	mux.HandleFunc("POST /v1/chat/{id}/restore", middle.RequireAuthenticatedUser(h.restoreChatHandler))
 }
 
 func (h *Handler) getTitlesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	var filters Filters
+	filters.Trashed = h.utils.ReadBool(qs, "trashed", false, v)
+	filters.Archived = h.utils.ReadBool(qs, "archived", false, v)
+	filters.PinnedOnly = h.utils.ReadBool(qs, "pinned", false, v)
+	filters.FolderID = int64(h.utils.ReadInt(qs, "folder", 0, v))
+	filters.Tags = h.utils.ReadCSV(qs, "tags", nil)
+	v.Check(filters.FolderID >= 0, "folder", "must not be negative")
+
+	cursor, limit := h.readPage(qs, v)
+	if !v.Valid() {
+		h.er.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	user := userContext.ContextGetUser(r)
+	titles, metadata, err := h.chatService.getTitles(user.ID, filters, cursor, limit)
+	if err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"titles": titles, "metadata": metadata}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+// readPage reads the limit and cursor query parameters shared by the paginated
+// endpoints.
+func (h *Handler) readPage(qs url.Values, v *validator.Validator) (Cursor, int) {
+	limit := h.utils.ReadInt(qs, "limit", 50, v)
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 100, "limit", "must be a maximum of 100")
+
+	cursor, err := decodeCursor(h.utils.ReadString(qs, "cursor", ""))
+	if err != nil {
+		v.AddError("cursor", "must be a next_cursor returned by a previous page")
+	}
+	return cursor, limit
+}
+
+func (h *Handler) searchHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	query := h.utils.ReadString(qs, "q", "")
+	limit := h.utils.ReadInt(qs, "limit", 20, v)
+
+	if h.chatService.checkSearch(v, query, limit); !v.Valid() {
+		h.er.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-	titles, err := h.chatService.getTitles(user.ID)
+	user := userContext.ContextGetUser(r)
+	results, err := h.chatService.search(user.ID, query, limit)
 	if err != nil {
 		h.er.ServerErrorResponse(w, r, err)
 		return
 	}
 
-	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"titles": titles}, nil); err != nil {
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"results": results}, nil); err != nil {
 		h.er.ServerErrorResponse(w, r, err)
 	}
 }
@@ -50,13 +123,56 @@ func (h *Handler) getCurrentChatHistoryHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	chatHistory, err := h.chatService.getChatHistory(int32(chatID))
+	v := validator.New()
+	cursor, limit := h.readPage(r.URL.Query(), v)
+	if !v.Valid() {
+		h.er.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	chatHistory, metadata, err := h.chatService.getChatHistory(user.ID, int32(chatID), cursor, limit)
 	if err != nil {
 		h.er.ServerErrorResponse(w, r, err)
 		return
 	}
 
-	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"chatHistory": chatHistory}, nil); err != nil {
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"chatHistory": chatHistory, "metadata": metadata}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) updateChatHandler(w http.ResponseWriter, r *http.Request) {
+	chatID, err := h.utils.ReadIDParam(r)
+	if err != nil {
+		h.er.NotFoundResponse(w, r)
+		return
+	}
+
+	var input ChatUpdate
+	if err := h.utils.ReadJSON(w, r, &input); err != nil {
+		h.er.BadRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if h.chatService.checkChatUpdate(v, input); !v.Valid() {
+		h.er.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	if err := h.chatService.updateChat(user.ID, int32(chatID), input); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "Chat Update Successful!"}, nil); err != nil {
 		h.er.ServerErrorResponse(w, r, err)
 	}
 }
@@ -64,10 +180,24 @@ func (h *Handler) getCurrentChatHistoryHandler(w http.ResponseWriter, r *http.Re
 func (h *Handler) sendMessageHandler(w http.ResponseWriter, r *http.Request) {
 	apiKey := r.Header.Get("Api-Key")
 	var input struct {
-		ID        int32  `json:"id"` //0 for anon, use -1 once for title generation for anon user
+		ID        int32  `json:"id"` //0 or -1 to start a new chat
 		ModelType string `json:"model_type"`
 		Model     string `json:"model"` //optional
 		Prompt    string `json:"prompt"`
+		// TemplateID sends a prompt template instead of Prompt, with its
+		// placeholders filled from Variables.
+		TemplateID int64             `json:"template_id"`
+		Variables  map[string]string `json:"variables"`
+		// Mode "job" answers the prompt in the background, for models that take
+		// longer than the server's write timeout. Mode "stream" sends the reply
+		// as server-sent events while it's generated.
+		Mode string `json:"mode"` //optional, sync, job or stream
+		// ReasoningLevel is low, medium or high for reasoning models. It's kept
+		// as the chat's level for the prompts after it.
+		ReasoningLevel string `json:"reasoning_level"` //optional
+		// ResponseSchema is a JSON schema the reply must match. The reply is
+		// then also returned parsed, as the data of the message.
+		ResponseSchema json.RawMessage `json:"response_schema"` //optional
 	}
 
 	if err := h.utils.ReadJSON(w, r, &input); err != nil {
@@ -75,52 +205,379 @@ func (h *Handler) sendMessageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user := userContext.ContextGetUser(r)
+	if input.TemplateID != 0 {
+		v := validator.New()
+		v.Check(!user.IsAnonymous(), "template_id", "requires signing in")
+		if v.Check(input.Prompt == "", "prompt", "must be empty when a template is used"); !v.Valid() {
+			h.er.FailedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		text, err := h.chatService.renderTemplate(v, user.ID, input.TemplateID, input.Variables)
+		if err != nil {
+			switch {
+			case errors.Is(err, utils.ErrRecordNotFound):
+				v.AddError("template_id", "does not exist")
+				h.er.FailedValidationResponse(w, r, v.Errors)
+			default:
+				h.er.ServerErrorResponse(w, r, err)
+			}
+			return
+		}
+		if !v.Valid() {
+			h.er.FailedValidationResponse(w, r, v.Errors)
+			return
+		}
+		input.Prompt = text
+	}
+
 	if validInput, err := h.chatService.checkInput(input.ModelType, input.Model, input.Prompt); !validInput {
 		h.er.FailedValidationResponse(w, r, err)
 		return
 	}
 
-	user := userContext.ContextGetUser(r)
+	v := validator.New()
+	v.Check(validator.In(input.Mode, "", modeSync, modeJob, modeStream), "mode", "must be sync, job or stream")
+	h.chatService.checkReasoningLevel(v, input.ModelType, input.Model, input.ReasoningLevel)
+	schema := h.chatService.checkResponseSchema(v, input.ResponseSchema)
+	v.Check(input.Mode != modeJob || schema == nil, "response_schema", "is not supported in job mode")
+	if v.Check(input.Mode != modeJob || !user.IsAnonymous(), "mode", "job mode requires signing in"); !v.Valid() {
+		h.er.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	prompt := &Prompt{
+		UserID:         user.ID,
+		ChatID:         input.ID,
+		ModelType:      input.ModelType,
+		Model:          input.Model,
+		APIKey:         apiKey,
+		Text:           input.Prompt,
+		ReasoningLevel: input.ReasoningLevel,
+		Schema:         schema,
+	}
+
+	// The prompt is screened before a title is generated from it or a job is
+	// queued with it.
+	if err := h.chatService.screenPrompt(r.Context(), prompt); err != nil {
+		switch {
+		case errors.Is(err, policy.ErrBlocked):
+			h.er.PolicyBlockedResponse(w, r, err)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Anonymous chats are kept for a while under a guest token, which is handed
+	// out with the first answer and sent back in the Guest-Token header.
+	env := utils.Envelope{}
+	if user.IsAnonymous() {
+		prompt.GuestToken = r.Header.Get("Guest-Token")
+		if prompt.GuestToken == "" {
+			guestToken, err := generateGuestToken()
+			if err != nil {
+				h.er.ServerErrorResponse(w, r, err)
+				return
+			}
+			prompt.GuestToken = guestToken
+			prompt.ChatID = 0
+		}
+
+		v := validator.New()
+		if ValidateGuestToken(v, prompt.GuestToken); !v.Valid() {
+			h.er.FailedValidationResponse(w, r, v.Errors)
+			return
+		}
+		env["guest_token"] = prompt.GuestToken
+	}
+
+	// A sync reply is stopped by the client going away. Streamed replies carry on
+	// without a client, so they get a generation ID to be stopped with instead;
+	// jobs get theirs when they're submitted.
+	ctx := r.Context()
+	stopGeneration := func() {}
+	switch input.Mode {
+	case modeStream:
+		generationID, err := h.generationService.Start(generation.Owner(user.ID, prompt.GuestToken))
+		if err != nil {
+			h.er.ServerErrorResponse(w, r, err)
+			return
+		}
+		ctx, stopGeneration = h.generationService.Watch(context.Background(), generationID)
+		prompt.GenerationID = generationID
+		env["generation_id"] = generationID
+	case modeJob:
+		ctx = context.Background()
+	}
+
 	var chat Chat
-	chat.ID = input.ID
-	if input.ID == -1 || (input.ID < 1 && !user.IsAnonymous()) {
-		chatID, title, err := h.chatService.generateTitle(user.ID, input.ModelType, input.Model, apiKey, input.Prompt)
+
+	// The title is generated alongside the reply instead of before it, so the
+	// first message of a chat doesn't pay for an extra round-trip.
+	var titleCh chan string
+	if prompt.ChatID < 1 {
+		if err := h.chatService.createChat(prompt); err != nil {
+			stopGeneration()
+			h.er.ServerErrorResponse(w, r, err)
+			return
+		}
+		chat.Title = defaultTitle
+
+		titleCh = make(chan string, 1)
+		titlePrompt := *prompt
+		h.utils.Background(func() {
+			defer close(titleCh)
+			title, err := h.chatService.generateTitle(ctx, &titlePrompt)
+			if err != nil {
+				h.er.LogError(r, err)
+				return
+			}
+			titleCh <- title
+		})
+	}
+	chat.ID = prompt.ChatID
+
+	if err := h.chatService.rememberReasoningLevel(prompt); err != nil {
+		stopGeneration()
+		h.discardChat(r, prompt, titleCh)
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+	chat.ReasoningLevel = prompt.ReasoningLevel
+
+	// In stream mode the reply is generated in the background and relayed from
+	// its stream, so it's still stored when the client goes away and the client
+	// can reconnect to GET /v1/chat/{id}/stream.
+	if input.Mode == modeStream {
+		env["chat"] = chat
+		key, err := h.chatService.openStream(prompt, env)
 		if err != nil {
+			stopGeneration()
+			h.discardChat(r, prompt, titleCh)
 			h.er.ServerErrorResponse(w, r, err)
 			return
 		}
-		chat.ID = chatID
-		chat.Title = title
+
+		h.utils.Background(func() {
+			defer stopGeneration()
+			if err := h.chatService.streamOutput(ctx, prompt, key, chat, titleCh); err != nil {
+				h.er.LogError(r, err)
+			}
+		})
+		h.writeStream(w, r, key, "0")
+		return
 	}
 
-	text, err := h.chatService.processOutput(user.ID, chat.ID, input.ModelType, input.Model, apiKey, input.Prompt)
-	if err != nil {
+	// In job mode the reply is left to a job worker and the client polls
+	// GET /v1/jobs/{id} for it. The title is generated all the same.
+	if input.Mode == modeJob {
+		submitted, err := h.jobService.Submit(prompt.UserID, prompt.ChatID, prompt.ModelType, prompt.Model, prompt.APIKey, prompt.Text)
+		if err != nil {
+			h.discardChat(r, prompt, titleCh)
+			switch {
+			case errors.Is(err, utils.ErrRecordNotFound):
+				h.er.NotFoundResponse(w, r)
+			case errors.Is(err, job.ErrJobLimit):
+				v.AddError("mode", "too many jobs are still running, wait for one to finish")
+				h.er.FailedValidationResponse(w, r, v.Errors)
+			default:
+				h.er.ServerErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		env["chat"] = chat
+		env["job"] = submitted
+		if err := h.utils.WriteJSON(w, http.StatusAccepted, env, nil); err != nil {
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	reply, err := h.chatService.processOutput(ctx, prompt)
+	if err != nil && !errors.Is(err, generation.ErrStopped) {
+		h.discardChat(r, prompt, titleCh)
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		case errors.Is(err, policy.ErrBlocked):
+			h.er.PolicyBlockedResponse(w, r, err)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+	if titleCh != nil {
+		if title, ok := <-titleCh; ok {
+			chat.Title = title
+		}
+	}
+	chat.Message = []Message{*reply}
+
+	env["chat"] = chat
+	if err := h.utils.WriteJSON(w, http.StatusOK, env, nil); err != nil {
 		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+// discardChat deletes the chat created for the prompt in the background when its
+// first reply failed. titleCh is nil when the prompt went to an existing chat.
+func (h *Handler) discardChat(r *http.Request, prompt *Prompt, titleCh <-chan string) {
+	if titleCh == nil {
+		return
+	}
+	h.utils.Background(func() {
+		if err := h.chatService.discardChat(prompt, titleCh); err != nil {
+			h.er.LogError(r, err)
+		}
+	})
+}
+
+// resumeStreamHandler reconnects a client to the latest generation of a chat,
+// replaying the events after the Last-Event-ID header, or all of them without
+// it. Guests identify their chat with the Guest-Token header.
+func (h *Handler) resumeStreamHandler(w http.ResponseWriter, r *http.Request) {
+	chatID, err := h.utils.ReadIDParam(r)
+	if err != nil {
+		h.er.NotFoundResponse(w, r)
 		return
 	}
-	chat.Message = []Message{
-		{
-			Text: text,
-		},
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = "0"
+	}
+
+	v := validator.New()
+	v.Check(streamID.MatchString(lastID), "Last-Event-ID", "must be the id of an event of the stream")
+
+	user := userContext.ContextGetUser(r)
+	var guestToken string
+	if user.IsAnonymous() {
+		guestToken = r.Header.Get("Guest-Token")
+		ValidateGuestToken(v, guestToken)
+	}
+	if !v.Valid() {
+		h.er.FailedValidationResponse(w, r, v.Errors)
+		return
 	}
 
-	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"chat": chat}, nil); err != nil {
+	key, err := h.chatService.findStream(user.ID, guestToken, int32(chatID))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	h.writeStream(w, r, key, lastID)
+}
+
+// streamID matches the ID of a Valkey stream entry.
+var streamID = regexp.MustCompile(`^\d+(-\d+)?$`)
+
+// writeStream relays the events of a stream after lastID as server-sent events,
+// until the generation is done or the client goes away.
+func (h *Handler) writeStream(w http.ResponseWriter, r *http.Request, key string, lastID string) {
+	// A generation may well outlast the server's write timeout.
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
 		h.er.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		events, err := h.chatService.readStream(r.Context(), key, lastID)
+		if err != nil {
+			switch {
+			case r.Context().Err() != nil:
+			case errors.Is(err, utils.ErrRecordNotFound):
+				fmt.Fprint(w, "event: error\ndata: {\"error\":\"the stream has expired\"}\n\n")
+				_ = rc.Flush()
+			default:
+				h.er.LogError(r, err)
+			}
+			return
+		}
+
+		// A comment keeps idle connections from being dropped by proxies.
+		if len(events) == 0 {
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		for _, event := range events {
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+			lastID = event.ID
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		if n := len(events); n > 0 && (events[n-1].Type == eventDone || events[n-1].Type == eventError) {
+			return
+		}
 	}
 }
 
+// deleteChatHandler moves a chat to the trash, from where it can be restored
+// until the trash sweeper purges it.
 func (h *Handler) deleteChatHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		ID int32 `json:"id"`
 	}
+	if err := h.utils.ReadJSON(w, r, &input); err != nil {
+		h.er.BadRequestResponse(w, r, err)
+		return
+	}
 
 	user := userContext.ContextGetUser(r)
 	if err := h.chatService.deleteChat(user.ID, input.ID); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "Chat Moved To Trash!"}, nil); err != nil {
 		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) restoreChatHandler(w http.ResponseWriter, r *http.Request) {
+	chatID, err := h.utils.ReadIDParam(r)
+	if err != nil {
+		h.er.NotFoundResponse(w, r)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	if err := h.chatService.restoreChat(user.ID, int32(chatID)); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "Chat Deletion Successful!"}, nil); err != nil {
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "Chat Restored!"}, nil); err != nil {
 		h.er.ServerErrorResponse(w, r, err)
 	}
 }
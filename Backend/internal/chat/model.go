@@ -0,0 +1,158 @@
+package chat
+
+import (
+	"Backend/jsonschema"
+	"Backend/policy"
+	"Backend/redact"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The modes a prompt can be sent in: answered within the request, by a job, or
+// streamed as server-sent events.
+const (
+	modeSync   = "sync"
+	modeJob    = "job"
+	modeStream = "stream"
+)
+
+const (
+	// maxSchemaSize bounds the JSON schema a prompt may be sent with.
+	maxSchemaSize = 32 << 10
+	// maxRepairs is how many times a reply that doesn't match its schema is sent
+	// back to the model to be repaired.
+	maxRepairs = 2
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is the keyset position of the last row of a page. Titles are ordered by
+// (Pinned, Time, ID), messages by ID alone.
+type Cursor struct {
+	Pinned bool
+	Time   time.Time
+	ID     int64
+}
+
+// Filters narrow down the chat list. Archived chats are only listed when
+// Archived is set, and then exclusively. Trashed lists the trash instead,
+// archived or not.
+type Filters struct {
+	Trashed    bool
+	Archived   bool
+	PinnedOnly bool
+	FolderID   int64
+	Tags       []string
+}
+
+// ChatUpdate holds the fields of a chat to change; nil fields are left
+// untouched. A FolderID of 0 takes the chat out of its folder, and an empty
+// ReasoningLevel leaves reasoning to the provider's default.
+type ChatUpdate struct {
+	Pinned         *bool    `json:"pinned"`
+	Archived       *bool    `json:"archived"`
+	FolderID       *int64   `json:"folder_id"`
+	Tags           []string `json:"tags"`
+	ReasoningLevel *string  `json:"reasoning_level"`
+}
+
+type Metadata struct {
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+func (c Cursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, "%t:%d:%d", c.Pinned, c.Time.UnixMicro(), c.ID))
+}
+
+func decodeCursor(s string) (Cursor, error) {
+	if s == "" {
+		return Cursor{}, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, errInvalidCursor
+	}
+
+	var pinned bool
+	var micro, id int64
+	if _, err := fmt.Sscanf(string(b), "%t:%d:%d", &pinned, &micro, &id); err != nil || id < 1 {
+		return Cursor{}, errInvalidCursor
+	}
+	return Cursor{Pinned: pinned, Time: time.UnixMicro(micro), ID: id}, nil
+}
+
+// Prompt is one message sent to a chat, along with who sent it and how it should
+// be answered. Anonymous users have no UserID and keep their chats under
+// GuestToken instead.
+type Prompt struct {
+	UserID     string
+	GuestToken string
+	ChatID     int32
+	ModelType  string
+	Model      string
+	APIKey     string
+	Text       string
+	// GenerationID is the generation that can stop the reply, stored with it.
+	GenerationID string
+	// ReasoningLevel overrides the chat's reasoning level, and becomes the
+	// chat's level for the prompts after it.
+	ReasoningLevel string
+	// Schema, when set, is the JSON schema the reply must match.
+	Schema *jsonschema.Schema
+	// Annotations are the notes policy hooks and redaction left on the prompt
+	// once screened is set. redactor holds the placeholders the prompt was
+	// screened with, for the rest of its generation to use the same.
+	Annotations []policy.Annotation
+	screened    bool
+	redactor    *redact.Redactor
+	// Stream, when set, is handed every chunk of the reply as it's generated.
+	Stream func(chunk string)
+}
+
+func (p *Prompt) isGuest() bool {
+	return p.UserID == ""
+}
+
+// guestChat is an anonymous chat as it is kept in Valkey. Its messages have no
+// IDs.
+type guestChat struct {
+	ID             int32
+	Title          string
+	ReasoningLevel string
+	Messages       []Message
+}
+
+// A generation in stream mode is buffered in a Valkey stream, so a client that
+// lost its connection can pick up where it left off. The stream starts with the
+// chat, followed by the chunks of the reply and ends with done or error.
+const (
+	eventStart = "start"
+	eventChunk = "chunk"
+	eventDone  = "done"
+	eventError = "error"
+)
+
+const (
+	// streamTTL bounds how long a generation may run, it's pushed back with
+	// every event.
+	streamTTL = 30 * time.Minute
+	// streamDoneTTL is how long a finished stream can still be replayed.
+	streamDoneTTL = 5 * time.Minute
+	// currentStreamTTL is how long a chat remembers its latest stream, which
+	// expires well before unless it's still being written to.
+	currentStreamTTL = 24 * time.Hour
+	// streamBlock is how long a read waits for new events before the stream is
+	// checked for having expired.
+	streamBlock = 15 * time.Second
+)
+
+// streamEvent is one entry of a generation's stream. Data is JSON.
+type streamEvent struct {
+	ID   string
+	Type string
+	Data string
+}
@@ -0,0 +1,69 @@
+package chat
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor Cursor
+	}{
+		{"pinned", Cursor{Pinned: true, Time: time.UnixMicro(1760870400123456), ID: 42}},
+		{"not pinned", Cursor{Pinned: false, Time: time.UnixMicro(1760870400000000), ID: 1}},
+		{"before the epoch", Cursor{Time: time.UnixMicro(-1000), ID: 7}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCursor(tt.cursor.encode())
+			if err != nil {
+				t.Fatalf("decodeCursor() error = %v", err)
+			}
+			if got.Pinned != tt.cursor.Pinned || !got.Time.Equal(tt.cursor.Time) || got.ID != tt.cursor.ID {
+				t.Errorf("decodeCursor() = %+v, want %+v", got, tt.cursor)
+			}
+		})
+	}
+}
+
+func TestDecodeCursor(t *testing.T) {
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+		valid  bool
+	}{
+		{"empty", "", true},
+		{"valid", encode("true:1760870400000000:3"), true},
+		{"bad base64", "not base64!", false},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte("true:1:3")), false},
+		{"zero id", encode("false:1760870400000000:0"), false},
+		{"negative id", encode("false:1760870400000000:-5"), false},
+		{"missing id", encode("false:1760870400000000"), false},
+		{"not a bool", encode("yes:1760870400000000:3"), false},
+		{"not a time", encode("true:soon:3"), false},
+		{"garbage", encode("garbage"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCursor(tt.cursor)
+			if (err == nil) != tt.valid {
+				t.Fatalf("decodeCursor(%q) error = %v, want valid %t", tt.cursor, err, tt.valid)
+			}
+			if err != nil && !errors.Is(err, errInvalidCursor) {
+				t.Errorf("decodeCursor(%q) error = %v, want %v", tt.cursor, err, errInvalidCursor)
+			}
+			if tt.cursor == "" && got != (Cursor{}) {
+				t.Errorf("decodeCursor(\"\") = %+v, want the zero cursor", got)
+			}
+		})
+	}
+}
@@ -1,29 +1,110 @@
 package chat
 
 import (
+	"Backend/internal/encryption"
+	"Backend/policy"
+	"Backend/utils"
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
 	"github.com/tmc/langchaingo/llms"
 	"github.com/valkey-io/valkey-go"
+	"slices"
 	"time"
 )
 
 type Chat struct {
-	ID      int32     `json:"id"`
-	Title   string    `json:"title,omitempty"`
-	Message []Message `json:"message,omitempty"`
+	ID       int32    `json:"id"`
+	Title    string   `json:"title,omitempty"`
+	Pinned   bool     `json:"pinned,omitempty"`
+	Archived bool     `json:"archived,omitempty"`
+	FolderID *int64   `json:"folder_id,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	// ReasoningLevel is used for the chat's prompts that don't set their own.
+	ReasoningLevel string    `json:"reasoning_level,omitempty"`
+	Message        []Message `json:"message,omitempty"`
+
+	lastActivity time.Time
 }
 
+// Message is a message of a chat as the API returns it. Replies also record
+// what generated them, how long it took and how many tokens it cost; a stopped
+// reply is the part generated before it was stopped. The reasoning of a reply
+// is kept out of its text.
 type Message struct {
-	Text string `json:"text"`
+	ID               int64     `json:"id,omitempty"`
+	Role             string    `json:"role"`
+	Text             string    `json:"text"`
+	Reasoning        string    `json:"reasoning,omitempty"`
+	Provider         string    `json:"provider,omitempty"`
+	Model            string    `json:"model,omitempty"`
+	FinishReason     string    `json:"finish_reason,omitempty"`
+	LatencyMS        int64     `json:"latency_ms,omitempty"`
+	PromptTokens     int       `json:"prompt_tokens,omitempty"`
+	CompletionTokens int       `json:"completion_tokens,omitempty"`
+	ReasoningTokens  int       `json:"reasoning_tokens,omitempty"`
+	Stopped          bool      `json:"stopped,omitempty"`
+	Timestamp        time.Time `json:"timestamp"`
+	// GenerationID is the generation a reply came from, when it could be
+	// stopped.
+	GenerationID string `json:"generation_id,omitempty"`
+	// Annotations are the notes policy hooks left on the message.
+	Annotations []policy.Annotation `json:"annotations,omitempty"`
+	// Data is the reply parsed, when it was asked to match a JSON schema and
+	// does. DataErrors lists how it doesn't once repairs ran out. Neither is
+	// stored, the text is.
+	Data       json.RawMessage `json:"data,omitempty"`
+	DataErrors []string        `json:"data_errors,omitempty"`
+}
+
+type SearchResult struct {
+	ID        int32   `json:"id"`
+	Title     string  `json:"title"`
+	MessageID int64   `json:"message_id,omitempty"`
+	Position  int64   `json:"position,omitempty"`
+	Snippet   string  `json:"snippet,omitempty"`
+	Rank      float64 `json:"rank"`
 }
 
 type repo interface {
-	getMessageHistory(int32) ([]llms.MessageContent, error)
-	insertLatestMessage(int32, string, string) error
-	insertTitle(string, string) (int32, string, error)
-	getTitles(string) ([]Chat, error)
+	getMessageHistory(int32, *encryption.UserCipher) ([]llms.MessageContent, error)
+	getMessagePage(string, int32, Cursor, int, *encryption.UserCipher) ([]Message, Cursor, error)
+	getGenerationReply(string, int32, string, *encryption.UserCipher) (*Message, error)
+	insertLatestMessage(int32, *Message, *Message, *encryption.UserCipher) error
+	insertTitle(string, string, *encryption.UserCipher) (int32, string, error)
+	updateTitle(string, int32, string, *encryption.UserCipher) error
+	getTitles(string, Filters, Cursor, int, *encryption.UserCipher) ([]Chat, Cursor, error)
+	updateChat(string, int32, ChatUpdate) error
+	checkChat(string, int32) error
+	getReasoningLevel(int32) (string, error)
 	deleteChat(string, int32) error
+	restoreChat(string, int32) error
+	deleteEmptyChat(string, int32) error
+	purgeTrash(context.Context, time.Duration) error
+	search(string, string, int, *encryption.UserCipher) ([]SearchResult, error)
+
+	createGuestChat(string, string, time.Duration) (int32, error)
+	checkGuestChat(string, int32) error
+	getGuestHistory(string, int32) ([]llms.MessageContent, error)
+	insertGuestMessages(string, int32, []Message, time.Duration) error
+	updateGuestTitle(string, int32, string) error
+	getGuestReasoningLevel(string, int32) (string, error)
+	setGuestReasoningLevel(string, int32, string) error
+	getGuestChats(string) ([]guestChat, error)
+	deleteGuestChats(string, []int32) error
+	deleteGuestChat(string, int32) error
+	insertGuestChats(string, []guestChat, *encryption.UserCipher) error
+
+	setCurrentStream(string, string, time.Duration) error
+	getCurrentStream(string) (string, error)
+	appendStreamEvent(string, string, string, time.Duration) error
+	readStream(context.Context, string, string, time.Duration) ([]streamEvent, error)
+	streamExists(string) (bool, error)
 }
 
 type Model struct {
@@ -38,11 +119,11 @@ func NewRepo(db *sql.DB, vk valkey.Client) *Model {
 	}
 }
 
-func (m *Model) getMessageHistory(chatID int32) ([]llms.MessageContent, error) {
+func (m *Model) getMessageHistory(chatID int32, c *encryption.UserCipher) ([]llms.MessageContent, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	rows, err := m.db.QueryContext(ctx, "SELECT text, type FROM message WHERE title_id = $1 ORDER BY timestamp", chatID)
+	rows, err := m.db.QueryContext(ctx, "SELECT text, encrypted, type FROM message WHERE title_id = $1 ORDER BY timestamp", chatID)
 	if err != nil {
 		return nil, err
 	}
@@ -55,8 +136,12 @@ func (m *Model) getMessageHistory(chatID int32) ([]llms.MessageContent, error) {
 	var results []llms.MessageContent
 	for rows.Next() {
 		var text string
+		var encrypted bool
 		var messageType string
-		if err := rows.Scan(&text, &messageType); err != nil {
+		if err := rows.Scan(&text, &encrypted, &messageType); err != nil {
+			return nil, err
+		}
+		if text, err = c.Decrypt(text, encrypted); err != nil {
 			return nil, err
 		}
 		results = append(results, llms.TextParts(llms.ChatMessageType(messageType), text))
@@ -68,34 +153,251 @@ func (m *Model) getMessageHistory(chatID int32) ([]llms.MessageContent, error) {
 	return results, nil
 }
 
-func (m *Model) insertLatestMessage(chatID int32, prompt string, text string) error {
+// getMessagePage returns up to limit messages of a chat owned by userID, oldest
+// first, starting after the cursor. The returned cursor is zero on the last page.
+func (m *Model) getMessagePage(userID string, chatID int32, cursor Cursor, limit int, c *encryption.UserCipher) ([]Message, Cursor, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if _, err := m.db.ExecContext(ctx, "INSERT INTO message (title_id, text, type) VALUES ($1, $2, $3), ($1, $4, $5)", chatID, prompt, llms.ChatMessageTypeHuman, text, llms.ChatMessageTypeAI); err != nil {
+	query := `
+		SELECT message.id, type, text, reasoning, message.encrypted, provider, model, finish_reason, latency_ms, prompt_tokens, completion_tokens, reasoning_tokens, stopped, annotations, generation_id, timestamp::TIMESTAMPTZ
+		FROM message JOIN title ON title.id = title_id
+		WHERE title_id = $1 AND user_id = $2 AND deleted_at IS NULL AND message.id > $3
+		ORDER BY message.id LIMIT $4`
+
+	rows, err := m.db.QueryContext(ctx, query, chatID, userID, cursor.ID, limit+1)
+	if err != nil {
+		return nil, Cursor{}, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	results := []Message{}
+	var last, next Cursor
+	for rows.Next() {
+		if len(results) == limit {
+			next = last
+			break
+		}
+
+		var message Message
+		var encrypted bool
+		var annotations []byte
+		if err := rows.Scan(&message.ID, &message.Role, &message.Text, &message.Reasoning, &encrypted, &message.Provider, &message.Model, &message.FinishReason,
+			&message.LatencyMS, &message.PromptTokens, &message.CompletionTokens, &message.ReasoningTokens, &message.Stopped, &annotations, &message.GenerationID, &message.Timestamp); err != nil {
+			return nil, Cursor{}, err
+		}
+		if err := json.Unmarshal(annotations, &message.Annotations); err != nil {
+			return nil, Cursor{}, err
+		}
+		if message.Text, err = c.Decrypt(message.Text, encrypted); err != nil {
+			return nil, Cursor{}, err
+		}
+		if message.Reasoning, err = decryptReasoning(message.Reasoning, encrypted, c); err != nil {
+			return nil, Cursor{}, err
+		}
+		last.ID = message.ID
+		results = append(results, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, Cursor{}, err
+	}
+
+	return results, next, nil
+}
+
+// getGenerationReply returns the reply of a generation in a chat of the user.
+func (m *Model) getGenerationReply(userID string, chatID int32, generationID string, c *encryption.UserCipher) (*Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT message.id, text, message.encrypted, stopped
+		FROM message JOIN title ON title.id = title_id
+		WHERE title_id = $1 AND user_id = $2 AND generation_id = $3 AND type = $4`
+
+	reply := &Message{Role: string(llms.ChatMessageTypeAI), GenerationID: generationID}
+	var encrypted bool
+	err := m.db.QueryRowContext(ctx, query, chatID, userID, generationID, reply.Role).Scan(&reply.ID, &reply.Text, &encrypted, &reply.Stopped)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.ErrRecordNotFound
+		}
+		return nil, err
+	}
+	if reply.Text, err = c.Decrypt(reply.Text, encrypted); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// insertLatestMessage stores a prompt and its reply, filling in their IDs and
+// timestamps.
+func (m *Model) insertLatestMessage(chatID int32, prompt *Message, reply *Message, c *encryption.UserCipher) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	promptText, promptEncrypted, err := c.Encrypt(prompt.Text)
+	if err != nil {
 		return err
 	}
-	return nil
+	replyText, replyEncrypted, err := c.Encrypt(reply.Text)
+	if err != nil {
+		return err
+	}
+	replyReasoning, err := encryptReasoning(reply.Reasoning, c)
+	if err != nil {
+		return err
+	}
+	promptAnnotations, err := encodeAnnotations(prompt.Annotations)
+	if err != nil {
+		return err
+	}
+	replyAnnotations, err := encodeAnnotations(reply.Annotations)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	if _, err := tx.ExecContext(ctx, "UPDATE title SET last_activity = NOW() WHERE id = $1", chatID); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO message (title_id, type, text, reasoning, encrypted, provider, model, finish_reason, latency_ms, prompt_tokens, completion_tokens, reasoning_tokens, stopped, annotations, generation_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		RETURNING id, timestamp::TIMESTAMPTZ`
+
+	if err := tx.QueryRowContext(ctx, query, chatID, prompt.Role, promptText, "", promptEncrypted, prompt.Provider, prompt.Model, prompt.FinishReason,
+		prompt.LatencyMS, prompt.PromptTokens, prompt.CompletionTokens, prompt.ReasoningTokens, prompt.Stopped, promptAnnotations, prompt.GenerationID).Scan(&prompt.ID, &prompt.Timestamp); err != nil {
+		return err
+	}
+	if err := tx.QueryRowContext(ctx, query, chatID, reply.Role, replyText, replyReasoning, replyEncrypted, reply.Provider, reply.Model, reply.FinishReason,
+		reply.LatencyMS, reply.PromptTokens, reply.CompletionTokens, reply.ReasoningTokens, reply.Stopped, replyAnnotations, reply.GenerationID).Scan(&reply.ID, &reply.Timestamp); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// encryptReasoning encrypts reasoning along with the text of its message, which
+// it shares the encrypted flag with. Most replies have none, which is stored as
+// is.
+func encryptReasoning(reasoning string, c *encryption.UserCipher) (string, error) {
+	if reasoning == "" {
+		return "", nil
+	}
+	reasoning, _, err := c.Encrypt(reasoning)
+	return reasoning, err
+}
+
+// encodeAnnotations encodes the annotations of a message for its JSONB column.
+func encodeAnnotations(annotations []policy.Annotation) (string, error) {
+	if len(annotations) == 0 {
+		return "[]", nil
+	}
+	b, err := json.Marshal(annotations)
+	return string(b), err
+}
+
+func decryptReasoning(reasoning string, encrypted bool, c *encryption.UserCipher) (string, error) {
+	if reasoning == "" {
+		return "", nil
+	}
+	return c.Decrypt(reasoning, encrypted)
 }
 
-func (m *Model) insertTitle(userID string, title string) (int32, string, error) {
+func (m *Model) insertTitle(userID string, title string, c *encryption.UserCipher) (int32, string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	stored, encrypted, err := c.Encrypt(title)
+	if err != nil {
+		return 0, "", err
+	}
+
 	var chatID int32
-	if err := m.db.QueryRowContext(ctx, "INSERT INTO title (user_id, title) VALUES ($1, $2) RETURNING id", userID, title).Scan(&chatID); err != nil {
+	if err := m.db.QueryRowContext(ctx, "INSERT INTO title (user_id, title, encrypted) VALUES ($1, $2, $3) RETURNING id", userID, stored, encrypted).Scan(&chatID); err != nil {
 		return 0, "", err
 	}
 
 	return chatID, title, nil
 }
 
-func (m *Model) getTitles(userID string) ([]Chat, error) {
+func (m *Model) updateTitle(userID string, chatID int32, title string, c *encryption.UserCipher) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	rows, err := m.db.QueryContext(ctx, "SELECT title.id, title FROM title LEFT JOIN message ON title.id = title_id WHERE user_id = $1 GROUP BY title.id ORDER BY MAX(timestamp) DESC", userID)
+
+	title, encrypted, err := c.Encrypt(title)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	result, err := m.db.ExecContext(ctx, "UPDATE title SET title = $1, encrypted = $2 WHERE id = $3 AND user_id = $4", title, encrypted, chatID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return utils.ErrRecordNotFound
+	}
+	return nil
+}
+
+// getTitles returns up to limit chats of userID matching the filters, pinned
+// first and then most recently active, starting after the cursor. The returned
+// cursor is zero on the last page.
+func (m *Model) getTitles(userID string, filters Filters, cursor Cursor, limit int, c *encryption.UserCipher) ([]Chat, Cursor, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `SELECT id, title, encrypted, pinned, archived, folder_id, reasoning_level, last_activity,
+		ARRAY(SELECT tag.name FROM title_tag JOIN tag ON tag.id = tag_id WHERE title_id = title.id ORDER BY tag.name)
+		FROM title WHERE user_id = $1`
+	args := []any{userID}
+
+	if filters.Trashed {
+		query += " AND deleted_at IS NOT NULL"
+	} else {
+		args = append(args, filters.Archived)
+		query += fmt.Sprintf(" AND deleted_at IS NULL AND archived = $%d", len(args))
+	}
+
+	if filters.PinnedOnly {
+		query += " AND pinned"
+	}
+	if filters.FolderID != 0 {
+		args = append(args, filters.FolderID)
+		query += fmt.Sprintf(" AND folder_id = $%d", len(args))
+	}
+	if len(filters.Tags) > 0 {
+		args = append(args, pq.Array(filters.Tags))
+		query += fmt.Sprintf(" AND id IN (SELECT title_id FROM title_tag JOIN tag ON tag.id = tag_id WHERE tag.name = ANY($%d))", len(args))
+	}
+	if cursor.ID != 0 {
+		args = append(args, cursor.Pinned, cursor.Time, cursor.ID)
+		query += fmt.Sprintf(" AND (pinned, last_activity, id) < ($%d, $%d, $%d)", len(args)-2, len(args)-1, len(args))
+	}
+	args = append(args, limit+1)
+	query += fmt.Sprintf(" ORDER BY pinned DESC, last_activity DESC, id DESC LIMIT $%d", len(args))
+
+	rows, err := m.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Cursor{}, err
 	}
 	defer func(rows *sql.Rows) {
 		if err := rows.Close(); err != nil {
@@ -103,28 +405,587 @@ func (m *Model) getTitles(userID string) ([]Chat, error) {
 		}
 	}(rows)
 
-	var chats []Chat
+	chats := []Chat{}
+	var next Cursor
 	for rows.Next() {
+		if len(chats) == limit {
+			last := chats[len(chats)-1]
+			next = Cursor{Pinned: last.Pinned, Time: last.lastActivity, ID: int64(last.ID)}
+			break
+		}
+
 		var chat Chat
-		if err := rows.Scan(&chat.ID, &chat.Title); err != nil {
-			return nil, err
+		var encrypted bool
+		var folderID sql.NullInt64
+		if err := rows.Scan(&chat.ID, &chat.Title, &encrypted, &chat.Pinned, &chat.Archived, &folderID, &chat.ReasoningLevel, &chat.lastActivity, pq.Array(&chat.Tags)); err != nil {
+			return nil, Cursor{}, err
+		}
+		if chat.Title, err = c.Decrypt(chat.Title, encrypted); err != nil {
+			return nil, Cursor{}, err
+		}
+		if folderID.Valid {
+			chat.FolderID = &folderID.Int64
 		}
 		chats = append(chats, chat)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, Cursor{}, err
 	}
 
-	return chats, nil
+	return chats, next, nil
+}
+
+func (m *Model) updateChat(userID string, chatID int32, update ChatUpdate) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	result, err := tx.ExecContext(ctx,
+		"UPDATE title SET pinned = COALESCE($3, pinned), archived = COALESCE($4, archived), reasoning_level = COALESCE($5, reasoning_level) WHERE id = $1 AND user_id = $2",
+		chatID, userID, update.Pinned, update.Archived, update.ReasoningLevel)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return utils.ErrRecordNotFound
+	}
+
+	if update.FolderID != nil {
+		var folderID sql.NullInt64
+		if *update.FolderID != 0 {
+			if err := tx.QueryRowContext(ctx, "SELECT id FROM folder WHERE id = $1 AND user_id = $2", *update.FolderID, userID).Scan(&folderID); err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return utils.ErrRecordNotFound
+				}
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE title SET folder_id = $1 WHERE id = $2", folderID, chatID); err != nil {
+			return err
+		}
+	}
+
+	if update.Tags != nil {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO tag (user_id, name) SELECT $1, unnest($2::TEXT[]) ON CONFLICT (user_id, name) DO NOTHING", userID, pq.Array(update.Tags)); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM title_tag WHERE title_id = $1", chatID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO title_tag (title_id, tag_id) SELECT $1, id FROM tag WHERE user_id = $2 AND name = ANY($3)", chatID, userID, pq.Array(update.Tags)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// checkChat reports utils.ErrRecordNotFound unless the chat belongs to userID and
+// is not in the trash.
+func (m *Model) checkChat(userID string, chatID int32) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var exists bool
+	if err := m.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM title WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL)", chatID, userID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return utils.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m *Model) getReasoningLevel(chatID int32) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var level string
+	if err := m.db.QueryRowContext(ctx, "SELECT reasoning_level FROM title WHERE id = $1", chatID).Scan(&level); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", utils.ErrRecordNotFound
+		}
+		return "", err
+	}
+	return level, nil
 }
 
+// deleteChat moves the chat to the trash. Its messages are only removed once the
+// trash is purged.
 func (m *Model) deleteChat(userID string, chatID int32) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if _, err := m.db.ExecContext(ctx, "DELETE FROM title WHERE id = $1 AND user_id = $2", chatID, userID); err != nil {
+	result, err := m.db.ExecContext(ctx, "UPDATE title SET deleted_at = NOW() WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL", chatID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return utils.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m *Model) restoreChat(userID string, chatID int32) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, "UPDATE title SET deleted_at = NULL WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL", chatID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return utils.ErrRecordNotFound
+	}
+	return nil
+}
+
+// deleteEmptyChat permanently deletes a chat that has no messages.
+func (m *Model) deleteEmptyChat(userID string, chatID int32) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, "DELETE FROM title WHERE id = $1 AND user_id = $2 AND NOT EXISTS (SELECT 1 FROM message WHERE title_id = $1)", chatID, userID)
+	return err
+}
+
+// purgeTrash permanently deletes chats that have been in the trash for longer
+// than retention, cascading to their messages.
+func (m *Model) purgeTrash(ctx context.Context, retention time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, "DELETE FROM title WHERE deleted_at < $1", time.Now().Add(-retention))
+	return err
+}
+
+// search ranks the user's chats by how well their title or best matching message
+// fits the query, decayed by how many weeks ago the chat was last active. Position
+// is the 1-based index of the matching message within its chat, 0 for title hits.
+// Encrypted titles and messages aren't indexed, so they never match; an encrypted
+// title of a chat found through a plaintext message is decrypted as is.
+func (m *Model) search(userID string, query string, limit int, c *encryption.UserCipher) ([]SearchResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stmt := `
+		WITH q AS (SELECT websearch_to_tsquery('english', $2) AS query),
+		hits AS (
+			SELECT DISTINCT ON (t.id) t.id, t.title, t.encrypted, t.last_activity, m.id AS message_id, m.text, m.timestamp,
+				ts_rank(t.search, q.query) * 2 + COALESCE(ts_rank(m.search, q.query), 0) AS rank
+			FROM title t
+			CROSS JOIN q
+			LEFT JOIN message m ON m.title_id = t.id AND m.search @@ q.query
+			WHERE t.user_id = $1 AND t.deleted_at IS NULL AND (t.search @@ q.query OR m.id IS NOT NULL)
+			ORDER BY t.id, rank DESC
+		)
+		SELECT h.id,
+			CASE WHEN h.encrypted THEN h.title
+				ELSE ts_headline('english', h.title, q.query, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true') END,
+			h.encrypted,
+			COALESCE(h.message_id, 0),
+			CASE WHEN h.message_id IS NULL THEN 0 ELSE (
+				SELECT COUNT(*) FROM message
+				WHERE title_id = h.id AND (timestamp, id) <= (h.timestamp, h.message_id)
+			) END,
+			COALESCE(ts_headline('english', h.text, q.query, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2'), ''),
+			h.rank / (1 + EXTRACT(EPOCH FROM NOW() - h.last_activity) / 604800) AS score
+		FROM hits h
+		CROSS JOIN q
+		ORDER BY score DESC
+		LIMIT $3`
+
+	rows, err := m.db.QueryContext(ctx, stmt, userID, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var result SearchResult
+		var encrypted bool
+		if err := rows.Scan(&result.ID, &result.Title, &encrypted, &result.MessageID, &result.Position, &result.Snippet, &result.Rank); err != nil {
+			return nil, err
+		}
+		if result.Title, err = c.Decrypt(result.Title, encrypted); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Anonymous chats live in Valkey under the hash of the guest token: a hash at
+// guestKey holding the ID sequence and the chat titles, and one list of JSON
+// messages per chat at guestChatKey. Every write pushes back the expiry.
+func guestKey(guestToken string) string {
+	hash := sha256.Sum256([]byte(guestToken))
+	return "guest:" + hex.EncodeToString(hash[:])
+}
+
+func guestChatKey(guestToken string, chatID int32) string {
+	return fmt.Sprintf("%s:%d", guestKey(guestToken), chatID)
+}
+
+func guestTitleField(chatID int32) string {
+	return fmt.Sprintf("title:%d", chatID)
+}
+
+func guestReasoningField(chatID int32) string {
+	return fmt.Sprintf("reasoning:%d", chatID)
+}
+
+func (m *Model) createGuestChat(guestToken string, title string, ttl time.Duration) (int32, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	key := guestKey(guestToken)
+	chatID, err := m.vk.Do(ctx, m.vk.B().Hincrby().Key(key).Field("seq").Increment(1).Build()).AsInt64()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, resp := range m.vk.DoMulti(ctx,
+		m.vk.B().Hset().Key(key).FieldValue().FieldValue(guestTitleField(int32(chatID)), title).Build(),
+		m.vk.B().Expire().Key(key).Seconds(int64(ttl.Seconds())).Build(),
+	) {
+		if err := resp.Error(); err != nil {
+			return 0, err
+		}
+	}
+	return int32(chatID), nil
+}
+
+func (m *Model) checkGuestChat(guestToken string, chatID int32) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	exists, err := m.vk.Do(ctx, m.vk.B().Hexists().Key(guestKey(guestToken)).Field(guestTitleField(chatID)).Build()).AsBool()
+	if err != nil {
 		return err
 	}
+	if !exists {
+		return utils.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m *Model) getGuestMessages(ctx context.Context, guestToken string, chatID int32) ([]Message, error) {
+	elements, err := m.vk.Do(ctx, m.vk.B().Lrange().Key(guestChatKey(guestToken, chatID)).Start(0).Stop(-1).Build()).AsStrSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, 0, len(elements))
+	for _, element := range elements {
+		var message Message
+		if err := json.Unmarshal([]byte(element), &message); err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+func (m *Model) getGuestHistory(guestToken string, chatID int32) ([]llms.MessageContent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	messages, err := m.getGuestMessages(ctx, guestToken, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]llms.MessageContent, 0, len(messages))
+	for _, message := range messages {
+		results = append(results, llms.TextParts(llms.ChatMessageType(message.Role), message.Text))
+	}
+	return results, nil
+}
 
+func (m *Model) insertGuestMessages(guestToken string, chatID int32, messages []Message, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	elements := make([]string, 0, len(messages))
+	for _, message := range messages {
+		element, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		elements = append(elements, string(element))
+	}
+
+	chatKey := guestChatKey(guestToken, chatID)
+	for _, resp := range m.vk.DoMulti(ctx,
+		m.vk.B().Rpush().Key(chatKey).Element(elements...).Build(),
+		m.vk.B().Expire().Key(chatKey).Seconds(int64(ttl.Seconds())).Build(),
+		m.vk.B().Expire().Key(guestKey(guestToken)).Seconds(int64(ttl.Seconds())).Build(),
+	) {
+		if err := resp.Error(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+func (m *Model) updateGuestTitle(guestToken string, chatID int32, title string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.checkGuestChat(guestToken, chatID); err != nil {
+		return err
+	}
+	return m.vk.Do(ctx, m.vk.B().Hset().Key(guestKey(guestToken)).FieldValue().FieldValue(guestTitleField(chatID), title).Build()).Error()
+}
+
+func (m *Model) getGuestReasoningLevel(guestToken string, chatID int32) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	level, err := m.vk.Do(ctx, m.vk.B().Hget().Key(guestKey(guestToken)).Field(guestReasoningField(chatID)).Build()).ToString()
+	if err != nil {
+		if valkey.IsValkeyNil(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return level, nil
+}
+
+func (m *Model) setGuestReasoningLevel(guestToken string, chatID int32, level string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.checkGuestChat(guestToken, chatID); err != nil {
+		return err
+	}
+	return m.vk.Do(ctx, m.vk.B().Hset().Key(guestKey(guestToken)).FieldValue().FieldValue(guestReasoningField(chatID), level).Build()).Error()
+}
+
+// getGuestChats returns every anonymous chat still stored for the guest token,
+// oldest first.
+func (m *Model) getGuestChats(guestToken string) ([]guestChat, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fields, err := m.vk.Do(ctx, m.vk.B().Hgetall().Key(guestKey(guestToken)).Build()).AsStrMap()
+	if err != nil {
+		return nil, err
+	}
+
+	var chats []guestChat
+	for field, title := range fields {
+		var chatID int32
+		if _, err := fmt.Sscanf(field, "title:%d", &chatID); err != nil {
+			continue
+		}
+
+		messages, err := m.getGuestMessages(ctx, guestToken, chatID)
+		if err != nil {
+			return nil, err
+		}
+		chats = append(chats, guestChat{ID: chatID, Title: title, ReasoningLevel: fields[guestReasoningField(chatID)], Messages: messages})
+	}
+
+	slices.SortFunc(chats, func(a, b guestChat) int {
+		return int(a.ID - b.ID)
+	})
+	return chats, nil
+}
+
+func (m *Model) deleteGuestChats(guestToken string, chatIDs []int32) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	keys := []string{guestKey(guestToken)}
+	for _, chatID := range chatIDs {
+		keys = append(keys, guestChatKey(guestToken, chatID))
+	}
+	return m.vk.Do(ctx, m.vk.B().Del().Key(keys...).Build()).Error()
+}
+
+func (m *Model) deleteGuestChat(guestToken string, chatID int32) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	for _, resp := range m.vk.DoMulti(ctx,
+		m.vk.B().Hdel().Key(guestKey(guestToken)).Field(guestTitleField(chatID), guestReasoningField(chatID)).Build(),
+		m.vk.B().Del().Key(guestChatKey(guestToken, chatID)).Build(),
+	) {
+		if err := resp.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertGuestChats stores anonymous chats under userID with their original
+// timestamps, all or nothing.
+func (m *Model) insertGuestChats(userID string, chats []guestChat, c *encryption.UserCipher) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	for _, chat := range chats {
+		if len(chat.Messages) == 0 {
+			continue
+		}
+
+		title, encrypted, err := c.Encrypt(chat.Title)
+		if err != nil {
+			return err
+		}
+
+		var chatID int32
+		lastActivity := chat.Messages[len(chat.Messages)-1].Timestamp
+		if err := tx.QueryRowContext(ctx,
+			"INSERT INTO title (user_id, title, encrypted, reasoning_level, last_activity) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+			userID, title, encrypted, chat.ReasoningLevel, lastActivity).Scan(&chatID); err != nil {
+			return err
+		}
+
+		for _, message := range chat.Messages {
+			text, encrypted, err := c.Encrypt(message.Text)
+			if err != nil {
+				return err
+			}
+			reasoning, err := encryptReasoning(message.Reasoning, c)
+			if err != nil {
+				return err
+			}
+			annotations, err := encodeAnnotations(message.Annotations)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO message (title_id, type, text, reasoning, encrypted, provider, model, finish_reason, latency_ms, prompt_tokens, completion_tokens, reasoning_tokens, stopped, annotations, generation_id, timestamp)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16::TIMESTAMPTZ)`,
+				chatID, message.Role, text, reasoning, encrypted, message.Provider, message.Model, message.FinishReason,
+				message.LatencyMS, message.PromptTokens, message.CompletionTokens, message.ReasoningTokens, message.Stopped, annotations, message.GenerationID, message.Timestamp); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// currentStreamKey holds the generation ID of the latest stream of a chat. Chat
+// IDs of guests are only unique per guest token.
+func currentStreamKey(guestToken string, chatID int32) string {
+	if guestToken != "" {
+		return guestChatKey(guestToken, chatID) + ":stream"
+	}
+	return fmt.Sprintf("stream:%d", chatID)
+}
+
+// streamKey is the Valkey stream a generation of a chat is buffered in. Each
+// generation has its own, so starting one doesn't cut off a client still reading
+// the previous one.
+func streamKey(guestToken string, chatID int32, generationID string) string {
+	return currentStreamKey(guestToken, chatID) + ":" + generationID
+}
+
+// setCurrentStream makes the generation's stream the one clients reconnecting to
+// the chat are sent to.
+func (m *Model) setCurrentStream(key string, generationID string, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.vk.Do(ctx, m.vk.B().Set().Key(key).Value(generationID).Ex(ttl).Build()).Error()
+}
+
+// getCurrentStream returns the generation ID of the chat's latest stream, or ""
+// when it has none.
+func (m *Model) getCurrentStream(key string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	generationID, err := m.vk.Do(ctx, m.vk.B().Get().Key(key).Build()).ToString()
+	if err != nil {
+		if valkey.IsValkeyNil(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return generationID, nil
+}
+
+func (m *Model) appendStreamEvent(key string, eventType string, data string, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	for _, resp := range m.vk.DoMulti(ctx,
+		m.vk.B().Xadd().Key(key).Id("*").FieldValue().FieldValue("type", eventType).FieldValue("data", data).Build(),
+		m.vk.B().Expire().Key(key).Seconds(int64(ttl.Seconds())).Build(),
+	) {
+		if err := resp.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readStream returns the events after lastID, waiting up to block for new ones
+// to arrive. No events and no error means nothing arrived in time.
+func (m *Model) readStream(ctx context.Context, key string, lastID string, block time.Duration) ([]streamEvent, error) {
+	streams, err := m.vk.Do(ctx, m.vk.B().Xread().Count(100).Block(block.Milliseconds()).Streams().Key(key).Id(lastID).Build()).AsXRead()
+	if err != nil {
+		if valkey.IsValkeyNil(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var events []streamEvent
+	for _, entry := range streams[key] {
+		events = append(events, streamEvent{ID: entry.ID, Type: entry.FieldValues["type"], Data: entry.FieldValues["data"]})
+	}
+	return events, nil
+}
+
+func (m *Model) streamExists(key string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	count, err := m.vk.Do(ctx, m.vk.B().Exists().Key(key).Build()).AsInt64()
+	return count == 1, err
+}
@@ -2,52 +2,195 @@ package chat
 
 import (
 	"Backend/config"
+	"Backend/internal/apikey"
+	"Backend/internal/encryption"
+	"Backend/internal/generation"
+	"Backend/internal/template"
+	"Backend/jsonschema"
+	"Backend/policy"
+	"Backend/provider"
+	"Backend/redact"
+	"Backend/utils"
 	"Backend/validator"
 	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/llms/anthropic"
 	"github.com/tmc/langchaingo/llms/googleai"
 	"github.com/tmc/langchaingo/llms/openai"
+	"slices"
+	"strings"
+	"time"
 )
 
+const defaultTitle = "New Chat"
+
 type IService interface {
-	getTitles(string) ([]Chat, error)
-	getChatHistory(int32) ([]llms.MessageContent, error)
-	processOutput(string, int32, string, string, string, string) (string, error)
-	generateTitle(string, string, string, string, string) (int32, string, error)
+	getTitles(string, Filters, Cursor, int) ([]Chat, Metadata, error)
+	updateChat(string, int32, ChatUpdate) error
+	checkChatUpdate(*validator.Validator, ChatUpdate)
+	checkReasoningLevel(*validator.Validator, string, string, string)
+	checkResponseSchema(*validator.Validator, json.RawMessage) *jsonschema.Schema
+	screenPrompt(context.Context, *Prompt) error
+	rememberReasoningLevel(*Prompt) error
+	getChatHistory(string, int32, Cursor, int) ([]Message, Metadata, error)
+	processOutput(context.Context, *Prompt) (*Message, error)
+	AppendPrompt(context.Context, string, int32, string, string, string, string, string) (string, error)
+	FindReply(string, int32, string) (string, bool, error)
+	openStream(*Prompt, any) (string, error)
+	streamOutput(context.Context, *Prompt, string, Chat, <-chan string) error
+	findStream(string, string, int32) (string, error)
+	readStream(context.Context, string, string) ([]streamEvent, error)
+	createChat(*Prompt) error
+	discardChat(*Prompt, <-chan string) error
+	generateTitle(context.Context, *Prompt) (string, error)
+	MigrateGuestChats(string, string) error
 	deleteChat(string, int32) error
+	restoreChat(string, int32) error
+	PurgeTrash(context.Context) error
 	checkInput(string, string, string) (bool, map[string]string)
+	renderTemplate(*validator.Validator, string, int64, map[string]string) (string, error)
+	search(string, string, int) ([]SearchResult, error)
+	checkSearch(*validator.Validator, string, int)
 }
 
 type service struct {
-	chatRepo repo
-	multiLLM *config.MultiLLM
+	chatRepo          repo
+	apiKeyService     apikey.IService
+	encryptionService encryption.IService
+	templateService   template.IService
+	multiLLM          *config.MultiLLM
+	policy            *policy.Registry
 }
 
-func NewService(chatRepo repo, multiLLM *config.MultiLLM) IService {
+func NewService(chatRepo repo, apiKeyService apikey.IService, encryptionService encryption.IService, templateService template.IService, multiLLM *config.MultiLLM, policy *policy.Registry) IService {
 	return &service{
-		chatRepo: chatRepo,
-		multiLLM: multiLLM,
+		chatRepo:          chatRepo,
+		apiKeyService:     apiKeyService,
+		encryptionService: encryptionService,
+		templateService:   templateService,
+		multiLLM:          multiLLM,
+		policy:            policy,
+	}
+}
+
+func (s *service) getTitles(userID string, filters Filters, cursor Cursor, limit int) ([]Chat, Metadata, error) {
+	c, err := s.encryptionService.ForUser(userID)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	chats, next, err := s.chatRepo.getTitles(userID, filters, cursor, limit, c)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	return chats, newMetadata(next, limit), nil
+}
+
+func (s *service) getChatHistory(userID string, chatID int32, cursor Cursor, limit int) ([]Message, Metadata, error) {
+	c, err := s.encryptionService.ForUser(userID)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	messages, next, err := s.chatRepo.getMessagePage(userID, chatID, cursor, limit, c)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	return messages, newMetadata(next, limit), nil
+}
+
+func (s *service) updateChat(userID string, chatID int32, update ChatUpdate) error {
+	return s.chatRepo.updateChat(userID, chatID, update)
+}
+
+func (s *service) checkChatUpdate(v *validator.Validator, update ChatUpdate) {
+	v.Check(update.Pinned != nil || update.Archived != nil || update.FolderID != nil || update.Tags != nil || update.ReasoningLevel != nil, "chat", "must change at least one field")
+	if update.FolderID != nil {
+		v.Check(*update.FolderID >= 0, "folder_id", "must not be negative")
+	}
+	v.Check(len(update.Tags) <= 20, "tags", "must not contain more than 20 tags")
+	for _, tag := range update.Tags {
+		v.Check(tag != "", "tags", "must not contain empty tags")
+		v.Check(len(tag) <= 50, "tags", "must not contain tags longer than 50 bytes")
+	}
+	if update.ReasoningLevel != nil {
+		v.Check(validator.In(*update.ReasoningLevel, append(config.ReasoningLevels, "")...), "reasoning_level", "must be low, medium or high")
+	}
+}
+
+// checkReasoningLevel validates the reasoning level sent with a prompt, which
+// only reasoning models take.
+func (s *service) checkReasoningLevel(v *validator.Validator, modelType string, model string, level string) {
+	if level == "" {
+		return
+	}
+	if model == "" && len(config.LLMLists[modelType]) > 0 {
+		model = config.LLMLists[modelType][0]
+	}
+	if v.Check(validator.In(level, config.ReasoningLevels...), "reasoning_level", "must be low, medium or high"); v.Valid() {
+		v.Check(config.SupportsReasoning(model), "reasoning_level", "is not supported by the model")
+	}
+}
+
+// checkResponseSchema compiles the JSON schema sent with a prompt, if any.
+func (s *service) checkResponseSchema(v *validator.Validator, raw json.RawMessage) *jsonschema.Schema {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
 	}
+	if v.Check(len(raw) <= maxSchemaSize, "response_schema", fmt.Sprintf("must not be more than %d bytes long", maxSchemaSize)); !v.Valid() {
+		return nil
+	}
+	schema, err := jsonschema.Compile(raw)
+	if err != nil {
+		v.AddError("response_schema", err.Error())
+		return nil
+	}
+	return schema
 }
 
-func (s *service) getTitles(userID string) ([]Chat, error) {
+// rememberReasoningLevel makes the reasoning level of the prompt the level of
+// its chat, so the prompts after it, sent or scheduled, reason alike.
+func (s *service) rememberReasoningLevel(prompt *Prompt) error {
+	if prompt.ReasoningLevel == "" {
+		return nil
+	}
+	if prompt.isGuest() {
+		return s.chatRepo.setGuestReasoningLevel(prompt.GuestToken, prompt.ChatID, prompt.ReasoningLevel)
+	}
+	return s.chatRepo.updateChat(prompt.UserID, prompt.ChatID, ChatUpdate{ReasoningLevel: &prompt.ReasoningLevel})
+}
 
-	return s.chatRepo.getTitles(userID)
+// reasoningLevel returns the reasoning level of the prompt, or else of its chat.
+func (s *service) reasoningLevel(prompt *Prompt) (string, error) {
+	if prompt.ReasoningLevel != "" {
+		return prompt.ReasoningLevel, nil
+	}
+	if prompt.isGuest() {
+		return s.chatRepo.getGuestReasoningLevel(prompt.GuestToken, prompt.ChatID)
+	}
+	return s.chatRepo.getReasoningLevel(prompt.ChatID)
 }
 
-func (s *service) getChatHistory(chatID int32) ([]llms.MessageContent, error) {
-	return s.chatRepo.getMessageHistory(chatID)
+func newMetadata(next Cursor, limit int) Metadata {
+	metadata := Metadata{Limit: limit}
+	if next.ID != 0 {
+		metadata.NextCursor = next.encode()
+	}
+	return metadata
 }
 
 func withAPIKey(modelType string, apiKey string) (llms.Model, error) {
 	if modelType == "OpenAI" {
-		return openai.New(openai.WithToken(apiKey))
+		return openai.New(openai.WithToken(apiKey), openai.WithModel(config.LLMLists["OpenAI"][0]), openai.WithBaseURL(config.ProviderBaseURL("OpenAI")), openai.WithHTTPClient(provider.NewClient()))
 	} else if modelType == "Google" {
-		return googleai.New(context.Background(), googleai.WithAPIKey(apiKey))
+		return googleai.New(context.Background(), append(config.GoogleEndpoint(), googleai.WithAPIKey(apiKey), googleai.WithDefaultModel(config.LLMLists["Google"][0]))...)
 	} else if modelType == "Anthropic" {
-		return anthropic.New(anthropic.WithToken(apiKey))
+		return anthropic.New(anthropic.WithToken(apiKey), anthropic.WithModel(config.LLMLists["Anthropic"][0]), anthropic.WithBaseURL(config.ProviderBaseURL("Anthropic")), anthropic.WithHTTPClient(provider.NewClient()))
 	}
 	return nil, fmt.Errorf("invalid model type: %s", modelType)
 }
@@ -74,74 +217,652 @@ func getModel(modelType string, apiKey string, multiLLM *config.MultiLLM) (llms.
 	return withoutAPIKey(modelType, multiLLM), nil
 }
 
-func (s *service) generateTitle(userID string, modelType string, modelName string, apiKey string, prompt string) (int32, string, error) {
-	option, err := getModel(modelType, apiKey, s.multiLLM)
+// promptModel picks the model for a prompt. A key sent with the request wins,
+// then the user's stored key for the provider, then the server key.
+func (s *service) promptModel(prompt *Prompt) (llms.Model, error) {
+	apiKey := prompt.APIKey
+	if apiKey == "" && !prompt.isGuest() {
+		var err error
+		if apiKey, err = s.apiKeyService.GetKey(prompt.UserID, prompt.ModelType); err != nil {
+			return nil, err
+		}
+	}
+	return getModel(prompt.ModelType, apiKey, s.multiLLM)
+}
+
+// createChat starts a new chat for the prompt and points the prompt at it.
+func (s *service) createChat(prompt *Prompt) error {
+	var err error
+	if prompt.isGuest() {
+		prompt.ChatID, err = s.chatRepo.createGuestChat(prompt.GuestToken, defaultTitle, config.GuestChatTTL())
+		return err
+	}
+
+	c, err := s.encryptionService.ForUser(prompt.UserID)
+	if err != nil {
+		return err
+	}
+
+	prompt.ChatID, _, err = s.chatRepo.insertTitle(prompt.UserID, defaultTitle, c)
+	return err
+}
+
+// discardChat deletes the chat created for a prompt whose first reply failed, so
+// it isn't left behind empty under the default title. Its title is generated
+// alongside the reply, so that is waited for first.
+func (s *service) discardChat(prompt *Prompt, titleCh <-chan string) error {
+	for range titleCh {
+	}
+	if prompt.isGuest() {
+		return s.chatRepo.deleteGuestChat(prompt.GuestToken, prompt.ChatID)
+	}
+	return s.chatRepo.deleteEmptyChat(prompt.UserID, prompt.ChatID)
+}
+
+// generateTitle names a chat with the cheap title model of the same provider, so
+// it can run alongside processOutput without holding up the reply.
+func (s *service) generateTitle(ctx context.Context, prompt *Prompt) (string, error) {
+	option, err := s.promptModel(prompt)
 	if err != nil {
-		return 0, "", err
+		return "", err
 	}
 
 	var opts []llms.CallOption
-	if modelName != "" {
-		opts = append(opts, llms.WithModel(modelName))
+	if titleModel := config.TitleModel(prompt.ModelType); titleModel != "" {
+		opts = append(opts, llms.WithModel(titleModel))
 	}
 
+	redactor := redact.New()
+	text := prompt.Text
+	if config.RedactPrompts() {
+		text, _ = redactor.Redact(text)
+	}
 	titlePrompt := fmt.Sprintf(
 		"Based on the following initial prompt, generate a concise and descriptive title for the conversation:\n\n%s",
-		prompt,
+		text,
 	)
-	titles, err := option.GenerateContent(context.Background(), []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, titlePrompt)}, opts...)
+	titles, err := option.GenerateContent(ctx, []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, titlePrompt)}, opts...)
 	if err != nil {
-		return 0, "", err
+		return "", err
+	}
+	title := titles.Choices[0].Content
+	if config.RestoreRedactions() {
+		title = redactor.Restore(title)
 	}
 
-	if userID == "" {
-		return 0, titles.Choices[0].Content, nil
+	if prompt.isGuest() {
+		err = s.chatRepo.updateGuestTitle(prompt.GuestToken, prompt.ChatID, title)
+	} else {
+		var c *encryption.UserCipher
+		if c, err = s.encryptionService.ForUser(prompt.UserID); err == nil {
+			err = s.chatRepo.updateTitle(prompt.UserID, prompt.ChatID, title, c)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return title, nil
+}
+
+// screenPrompt runs the prompt through the policy hooks, which may rewrite or
+// annotate it, or block it with a policy.BlockedError. A prompt is only screened
+// once, so the handler can screen it before anything else sees it.
+//
+// Secrets and personal data are masked before the hooks see the prompt, as some
+// send it off the server, and what was masked is recorded on it. The prompt
+// keeps its values, the hooks' rewrites applied around the placeholders.
+func (s *service) screenPrompt(ctx context.Context, prompt *Prompt) error {
+	if prompt.screened {
+		return nil
+	}
+
+	prompt.redactor = redact.New()
+	text := prompt.Text
+	var annotations []policy.Annotation
+	if config.RedactPrompts() {
+		var masked []redact.Redaction
+		text, masked = prompt.redactor.Redact(text)
+		for _, r := range masked {
+			annotations = append(annotations, policy.Annotation{Hook: "redaction", Note: fmt.Sprintf("masked %s as %s", r.Label, r.Placeholder)})
+		}
 	}
 
-	return s.chatRepo.insertTitle(userID, titles.Choices[0].Content)
+	content := &policy.Content{
+		Stage:     policy.StagePrompt,
+		UserID:    prompt.UserID,
+		ModelType: prompt.ModelType,
+		Model:     prompt.Model,
+		Text:      text,
+	}
+	if err := s.policy.Run(ctx, content); err != nil {
+		return err
+	}
+	if content.Text != text {
+		prompt.Text = prompt.redactor.Restore(content.Text)
+	}
+	prompt.Annotations, prompt.screened = append(annotations, content.Annotations...), true
+	return nil
+}
+
+// screenReply runs the reply through the policy hooks, which may rewrite or
+// annotate it, or block it with a policy.BlockedError. A rewritten reply loses
+// its data, which no longer is what the text says. The reply is screened with
+// its placeholders, before the values behind them are restored.
+func (s *service) screenReply(ctx context.Context, prompt *Prompt, reply *Message) error {
+	content := &policy.Content{
+		Stage:     policy.StageReply,
+		UserID:    prompt.UserID,
+		ModelType: reply.Provider,
+		Model:     reply.Model,
+		Text:      reply.Text,
+	}
+	if err := s.policy.Run(ctx, content); err != nil {
+		return err
+	}
+	if content.Text != reply.Text && reply.Data != nil {
+		reply.Data, reply.DataErrors = nil, []string{"the reply was rewritten by policy"}
+	}
+	reply.Text, reply.Annotations = content.Text, content.Annotations
+	return nil
 }
 
-func (s *service) processOutput(userID string, chatID int32, modelType string, modelName string, apiKey string, prompt string) (string, error) {
-	option, err := getModel(modelType, apiKey, s.multiLLM)
+// processOutput answers the prompt, stores both and returns the stored reply.
+// When ctx is cancelled the reply generated so far is stored marked as stopped,
+// and returned along with generation.ErrStopped. A prompt or reply blocked by
+// policy isn't stored, and policy.ErrBlocked is returned. While reply hooks are
+// registered a streamed reply is held back until it has been screened, then
+// sent whole, so a client never reads what a hook blocks or rewrites.
+func (s *service) processOutput(ctx context.Context, prompt *Prompt) (*Message, error) {
+	option, err := s.promptModel(prompt)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if err := s.screenPrompt(ctx, prompt); err != nil {
+		return nil, err
 	}
 
-	conversation, err := s.chatRepo.getMessageHistory(chatID)
+	var conversation []llms.MessageContent
+	var c *encryption.UserCipher
+	if prompt.isGuest() {
+		if err := s.chatRepo.checkGuestChat(prompt.GuestToken, prompt.ChatID); err != nil {
+			return nil, err
+		}
+		conversation, err = s.chatRepo.getGuestHistory(prompt.GuestToken, prompt.ChatID)
+	} else {
+		if err := s.chatRepo.checkChat(prompt.UserID, prompt.ChatID); err != nil {
+			return nil, err
+		}
+		if c, err = s.encryptionService.ForUser(prompt.UserID); err != nil {
+			return nil, err
+		}
+		conversation, err = s.chatRepo.getMessageHistory(prompt.ChatID, c)
+	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	conversation = append(conversation, llms.TextParts(llms.ChatMessageTypeHuman, prompt))
 	var opts []llms.CallOption
-	if modelName != "" {
-		opts = append(opts, llms.WithModel(modelName))
+	if prompt.Model != "" {
+		opts = append(opts, llms.WithModel(prompt.Model))
+	}
+
+	// Structured output goes through the provider's own schema support. Gemini
+	// only has a JSON mode through langchaingo, so it's told the schema instead.
+	turn := llms.TextParts(llms.ChatMessageTypeHuman, prompt.Text)
+	if prompt.Schema != nil && prompt.ModelType == "Google" {
+		opts = append(opts, llms.WithJSONMode())
+		turn.Parts = append(turn.Parts, llms.TextPart(fmt.Sprintf(schemaInstruction, prompt.Schema.Raw())))
+	}
+
+	// Secrets and personal data are replaced by placeholders in everything sent,
+	// the history included, with the placeholders the prompt was screened with.
+	redactor := prompt.redactor
+	if config.RedactPrompts() {
+		for i := range conversation {
+			conversation[i] = redactMessage(redactor, conversation[i])
+		}
+		turn = redactMessage(redactor, turn)
+	}
+	conversation = append(conversation, turn)
+
+	// The reply is always streamed, so what was generated before a stop isn't
+	// lost. Repairs aren't, they'd only confuse a client reading along. Streamed
+	// chunks get their placeholders restored as they go.
+	repairOpts := opts
+	restore := config.RestoreRedactions()
+	holdBack := s.policy.Has(policy.StageReply)
+	stream := redactor.Stream()
+	var partial strings.Builder
+	opts = append(slices.Clip(opts), llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+		partial.Write(chunk)
+		if prompt.Stream == nil || holdBack {
+			return nil
+		}
+		text := string(chunk)
+		if restore {
+			text = stream.Write(text)
+		}
+		if text != "" {
+			prompt.Stream(text)
+		}
+		return nil
+	}))
+
+	reply := &Message{
+		Role:         string(llms.ChatMessageTypeAI),
+		Provider:     prompt.ModelType,
+		Model:        prompt.Model,
+		GenerationID: prompt.GenerationID,
+	}
+	if reply.Model == "" {
+		reply.Model = config.LLMLists[prompt.ModelType][0]
+	}
+
+	// Reasoning is kept apart from the reply. It's stored with it but never sent
+	// back to the model: no provider needs it outside of tool use. A chat's
+	// reasoning level is ignored once it moves on to a model that doesn't reason.
+	generationCtx, capture := provider.WithCapture(ctx)
+	if config.SupportsReasoning(reply.Model) {
+		level, err := s.reasoningLevel(prompt)
+		if err != nil {
+			return nil, err
+		}
+		generationCtx = provider.WithReasoning(generationCtx, config.ReasoningParams(reply.Model, level))
+	}
+	if prompt.Schema != nil {
+		generationCtx = provider.WithResponseSchema(generationCtx, prompt.Schema.Raw())
+	}
+	started := time.Now()
+	content, err := option.GenerateContent(generationCtx, conversation, opts...)
+	reply.LatencyMS = time.Since(started).Milliseconds()
+	switch {
+	case err == nil:
+		choice := content.Choices[0]
+		reply.Text = choice.Content
+		reply.FinishReason = choice.StopReason
+		reply.PromptTokens, reply.CompletionTokens, reply.ReasoningTokens = tokenCounts(choice.GenerationInfo)
+		// A reply stopped while it's being repaired keeps the last attempt.
+		if prompt.Schema != nil {
+			if err := conform(generationCtx, option, conversation, repairOpts, prompt.Schema, reply); err != nil {
+				if ctx.Err() == nil {
+					return nil, err
+				}
+				reply.Stopped = true
+			}
+		}
+	case ctx.Err() != nil:
+		reply.Text, reply.Stopped = partial.String(), true
+	default:
+		return nil, err
+	}
+	reply.Reasoning = capture.Reasoning()
+
+	// The reply is screened even when stopped, as what was generated of it is
+	// stored all the same.
+	if err := s.screenReply(context.WithoutCancel(ctx), prompt, reply); err != nil {
+		return nil, err
 	}
 
-	content, err := option.GenerateContent(context.Background(), conversation, opts...)
+	if restore {
+		if rest := stream.Flush(); rest != "" && prompt.Stream != nil && !holdBack {
+			prompt.Stream(rest)
+		}
+		reply.Text, reply.Reasoning = redactor.Restore(reply.Text), redactor.Restore(reply.Reasoning)
+		if reply.Data != nil {
+			reply.Data = redactor.RestoreJSON(reply.Data)
+		}
+	}
+	if holdBack && prompt.Stream != nil && reply.Text != "" {
+		prompt.Stream(reply.Text)
+	}
+
+	message := &Message{Role: string(llms.ChatMessageTypeHuman), Text: prompt.Text, Annotations: prompt.Annotations}
+	if prompt.isGuest() {
+		message.Timestamp = time.Now()
+		reply.Timestamp = message.Timestamp
+		stored := *reply
+		stored.Data, stored.DataErrors = nil, nil
+		err = s.chatRepo.insertGuestMessages(prompt.GuestToken, prompt.ChatID, []Message{*message, stored}, config.GuestChatTTL())
+	} else {
+		err = s.chatRepo.insertLatestMessage(prompt.ChatID, message, reply, c)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if reply.Stopped {
+		return reply, generation.ErrStopped
+	}
+	return reply, nil
+}
+
+// redactMessage returns message with the text of its parts redacted.
+func redactMessage(r *redact.Redactor, message llms.MessageContent) llms.MessageContent {
+	parts := make([]llms.ContentPart, len(message.Parts))
+	for i, part := range message.Parts {
+		if text, ok := part.(llms.TextContent); ok {
+			text.Text, _ = r.Redact(text.Text)
+			part = text
+		}
+		parts[i] = part
+	}
+	return llms.MessageContent{Role: message.Role, Parts: parts}
+}
+
+// schemaInstruction tells a model without native structured output the schema
+// its reply must match.
+const schemaInstruction = "Reply with only a JSON object matching this JSON schema:\n%s"
+
+// repairInstruction sends a reply that doesn't match its schema back to the
+// model, with how it doesn't.
+const repairInstruction = "Your reply does not match the JSON schema:\n- %s\nReply with only the corrected JSON object."
+
+// conform validates the reply against schema, sending it back to be repaired up
+// to maxRepairs times while it doesn't match. The reply ends up with the last
+// text generated, and its data or what is still wrong with it. Latency and tokens
+// add up over the repairs.
+func conform(ctx context.Context, model llms.Model, conversation []llms.MessageContent, opts []llms.CallOption, schema *jsonschema.Schema, reply *Message) error {
+	for repairs := 0; ; repairs++ {
+		text := stripCodeFence(reply.Text)
+		problems, err := schema.Validate([]byte(text))
+		switch {
+		case errors.Is(err, jsonschema.ErrTooComplex):
+			reply.DataErrors = []string{err.Error()}
+			return nil
+		case err != nil:
+			problems = []string{"the reply is not valid JSON"}
+		}
+		if len(problems) == 0 {
+			reply.Text, reply.Data, reply.DataErrors = text, json.RawMessage(text), nil
+			return nil
+		}
+		reply.DataErrors = problems
+		if repairs == maxRepairs {
+			return nil
+		}
+
+		conversation = append(conversation,
+			llms.TextParts(llms.ChatMessageTypeAI, reply.Text),
+			llms.TextParts(llms.ChatMessageTypeHuman, fmt.Sprintf(repairInstruction, strings.Join(problems, "\n- "))))
+		started := time.Now()
+		content, err := model.GenerateContent(ctx, conversation, opts...)
+		reply.LatencyMS += time.Since(started).Milliseconds()
+		if err != nil {
+			return err
+		}
+
+		choice := content.Choices[0]
+		promptTokens, completionTokens, reasoningTokens := tokenCounts(choice.GenerationInfo)
+		reply.Text = choice.Content
+		reply.FinishReason = choice.StopReason
+		reply.PromptTokens += promptTokens
+		reply.CompletionTokens += completionTokens
+		reply.ReasoningTokens += reasoningTokens
+	}
+}
+
+// stripCodeFence takes the Markdown code fence models like to wrap JSON in off
+// the text.
+func stripCodeFence(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") || !strings.HasSuffix(text, "```") || len(text) < 6 {
+		return text
+	}
+	text = strings.TrimSuffix(text[3:], "```")
+	if newline := strings.IndexByte(text, '\n'); newline >= 0 {
+		text = text[newline+1:]
+	}
+	return strings.TrimSpace(text)
+}
+
+// tokenCounts reads the prompt, completion and reasoning token counts out of a
+// reply's generation info, which every provider reports under its own keys and
+// types. Reasoning tokens are part of the completion tokens, and only OpenAI
+// counts them apart.
+func tokenCounts(info map[string]any) (int, int, int) {
+	count := func(keys ...string) int {
+		for _, key := range keys {
+			switch n := info[key].(type) {
+			case int:
+				return n
+			case int32:
+				return int(n)
+			case int64:
+				return int(n)
+			case float64:
+				return int(n)
+			}
+		}
+		return 0
+	}
+	return count("PromptTokens", "InputTokens", "input_tokens"), count("CompletionTokens", "OutputTokens", "output_tokens"), count("ReasoningTokens")
+}
+
+// AppendPrompt answers text in one of the user's chats as if they had sent it,
+// for prompts that aren't answered within a request, such as scheduled prompts and
+// jobs. The reply is stored like any other, with generationID if it has one, and
+// returned.
+func (s *service) AppendPrompt(ctx context.Context, userID string, chatID int32, modelType string, model string, apiKey string, text string, generationID string) (string, error) {
+	reply, err := s.processOutput(ctx, &Prompt{
+		UserID:       userID,
+		ChatID:       chatID,
+		ModelType:    modelType,
+		Model:        model,
+		APIKey:       apiKey,
+		Text:         text,
+		GenerationID: generationID,
+	})
+	if reply == nil {
+		return "", err
+	}
+	return reply.Text, err
+}
+
+// FindReply returns the stored reply of a generation in one of the user's chats
+// and whether it was stopped, for a generation that may have been answered
+// before it was interrupted. utils.ErrRecordNotFound means it wasn't.
+func (s *service) FindReply(userID string, chatID int32, generationID string) (string, bool, error) {
+	if generationID == "" {
+		return "", false, utils.ErrRecordNotFound
+	}
+	c, err := s.encryptionService.ForUser(userID)
+	if err != nil {
+		return "", false, err
+	}
+	reply, err := s.chatRepo.getGenerationReply(userID, chatID, generationID, c)
 	if err != nil {
+		return "", false, err
+	}
+	return reply.Text, reply.Stopped, nil
+}
+
+// openStream starts the stream of the prompt's generation with a start event
+// carrying start, makes it the chat's current stream, and returns its key.
+func (s *service) openStream(prompt *Prompt, start any) (string, error) {
+	key := streamKey(prompt.GuestToken, prompt.ChatID, prompt.GenerationID)
+	if err := s.publish(key, eventStart, start, streamTTL); err != nil {
 		return "", err
 	}
+	return key, s.chatRepo.setCurrentStream(currentStreamKey(prompt.GuestToken, prompt.ChatID), prompt.GenerationID, currentStreamTTL)
+}
+
+// streamOutput answers the prompt, publishing the reply to the stream at key as
+// it's generated. The reply is stored like any other, whether or not a client is
+// still reading, and the done event carries the chat as a synchronous answer
+// would. A stream that can't be written to doesn't stop the generation, only
+// cancelling ctx does.
+func (s *service) streamOutput(ctx context.Context, prompt *Prompt, key string, chat Chat, titleCh <-chan string) error {
+	var publishErr error
+	prompt.Stream = func(chunk string) {
+		if publishErr == nil {
+			publishErr = s.publish(key, eventChunk, map[string]string{"text": chunk}, streamTTL)
+		}
+	}
 
-	if userID != "" {
-		if err := s.chatRepo.insertLatestMessage(chatID, prompt, content.Choices[0].Content); err != nil {
-			return "", err
+	reply, err := s.processOutput(ctx, prompt)
+	if err != nil && !errors.Is(err, generation.ErrStopped) {
+		message := "the server encountered a problem and could not process your request"
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			message = "the requested resource could not be found"
+		case errors.Is(err, policy.ErrBlocked):
+			message = err.Error()
+		}
+		err = errors.Join(err, s.publish(key, eventError, map[string]string{"error": message}, streamDoneTTL))
+		if titleCh != nil {
+			err = errors.Join(err, s.discardChat(prompt, titleCh))
 		}
+		return err
+	}
+
+	if titleCh != nil {
+		if title, ok := <-titleCh; ok {
+			chat.Title = title
+		}
+	}
+	chat.Message = []Message{*reply}
+	return errors.Join(publishErr, s.publish(key, eventDone, map[string]any{"chat": chat}, streamDoneTTL))
+}
+
+func (s *service) publish(key string, eventType string, data any, ttl time.Duration) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return s.chatRepo.appendStreamEvent(key, eventType, string(b), ttl)
+}
+
+// findStream returns the key of the stream of the chat's latest generation for a
+// client reconnecting to it, once the chat is known to belong to the user or
+// guest.
+func (s *service) findStream(userID string, guestToken string, chatID int32) (string, error) {
+	var err error
+	if userID == "" {
+		err = s.chatRepo.checkGuestChat(guestToken, chatID)
+	} else {
+		err = s.chatRepo.checkChat(userID, chatID)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	generationID, err := s.chatRepo.getCurrentStream(currentStreamKey(guestToken, chatID))
+	if err != nil {
+		return "", err
+	}
+	if generationID == "" {
+		return "", utils.ErrRecordNotFound
+	}
+
+	key := streamKey(guestToken, chatID, generationID)
+	exists, err := s.chatRepo.streamExists(key)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", utils.ErrRecordNotFound
+	}
+	return key, nil
+}
+
+// readStream waits for the events after lastID. utils.ErrRecordNotFound means
+// the stream expired, its generation having most likely died with its instance.
+func (s *service) readStream(ctx context.Context, key string, lastID string) ([]streamEvent, error) {
+	events, err := s.chatRepo.readStream(ctx, key, lastID, streamBlock)
+	if err != nil || len(events) > 0 {
+		return events, err
+	}
+
+	exists, err := s.chatRepo.streamExists(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, utils.ErrRecordNotFound
+	}
+	return nil, nil
+}
+
+// MigrateGuestChats moves the anonymous chats kept under guestToken into the
+// account of userID, once the guest has signed in.
+func (s *service) MigrateGuestChats(guestToken string, userID string) error {
+	chats, err := s.chatRepo.getGuestChats(guestToken)
+	if err != nil || len(chats) == 0 {
+		return err
+	}
+
+	c, err := s.encryptionService.ForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.chatRepo.insertGuestChats(userID, chats, c); err != nil {
+		return err
+	}
+
+	chatIDs := make([]int32, 0, len(chats))
+	for _, chat := range chats {
+		chatIDs = append(chatIDs, chat.ID)
+	}
+	return s.chatRepo.deleteGuestChats(guestToken, chatIDs)
+}
+
+func ValidateGuestToken(v *validator.Validator, guestToken string) {
+	v.Check(guestToken != "", "guest_token", "must be provided")
+	v.Check(len(guestToken) == 26, "guest_token", "must be 26 bytes long")
+}
+
+func generateGuestToken() (string, error) {
+	randomBytes := make([]byte, 16)
+
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
 	}
 
-	return content.Choices[0].Content, nil
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
 }
 
 func (s *service) deleteChat(userID string, chatID int32) error {
 	return s.chatRepo.deleteChat(userID, chatID)
 }
 
+func (s *service) restoreChat(userID string, chatID int32) error {
+	return s.chatRepo.restoreChat(userID, chatID)
+}
+
+func (s *service) PurgeTrash(ctx context.Context) error {
+	return s.chatRepo.purgeTrash(ctx, config.ChatTrashRetention())
+}
+
 func (s *service) checkInput(modelType string, model string, prompt string) (bool, map[string]string) {
 	v := validator.New()
 
 	v.Check(prompt != "", "prompt", "Empty prompt")
+	v.Check(len(prompt) <= 20000, "prompt", "must not be more than 20000 bytes long")
 	v.Check(validator.In(modelType, "OpenAI", "Google", "Anthropic"), "modelType", "Invalid model type")
 	v.Check(validator.In(model, append(config.LLMLists[modelType], "")...), "model", "Invalid model")
 
 	return v.Valid(), v.Errors
 }
+
+// renderTemplate turns one of the user's prompt templates into the prompt text.
+// Missing or unknown variables are reported on v.
+func (s *service) renderTemplate(v *validator.Validator, userID string, templateID int64, values map[string]string) (string, error) {
+	return s.templateService.Render(v, userID, templateID, values)
+}
+
+func (s *service) search(userID string, query string, limit int) ([]SearchResult, error) {
+	c, err := s.encryptionService.ForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.chatRepo.search(userID, query, limit, c)
+}
+
+func (s *service) checkSearch(v *validator.Validator, query string, limit int) {
+	v.Check(query != "", "q", "must be provided")
+	v.Check(len(query) <= 500, "q", "must not be more than 500 bytes long")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 100, "limit", "must be a maximum of 100")
+}
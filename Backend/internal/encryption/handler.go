@@ -0,0 +1,78 @@
+package encryption
+
+import (
+	"Backend/middleware"
+	"Backend/responses"
+	"Backend/userContext"
+	"Backend/utils"
+	"Backend/validator"
+	"Backend/vault"
+	"errors"
+	"net/http"
+)
+
+type Handler struct {
+	service IService
+	er      *responses.ErrorResponses
+	utils   *utils.Utils
+}
+
+func NewHandler(service IService, er *responses.ErrorResponses, utils *utils.Utils) *Handler {
+	return &Handler{
+		service: service,
+		er:      er,
+		utils:   utils,
+	}
+}
+
+func (h *Handler) RegisterRoutes(mux *http.ServeMux, middle *middleware.Middleware) {
+	mux.HandleFunc("GET /v1/account/encryption", middle.RequireAuthenticatedUser(h.getEncryptionHandler))
+	mux.HandleFunc("PUT /v1/account/encryption", middle.RequireAuthenticatedUser(h.setEncryptionHandler))
+}
+
+func (h *Handler) getEncryptionHandler(w http.ResponseWriter, r *http.Request) {
+	user := userContext.ContextGetUser(r)
+
+	enabled, err := h.service.isEnabled(user.ID)
+	if err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"enabled": enabled}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+// setEncryptionHandler only changes how new messages and titles are stored,
+// existing content is left as it is.
+func (h *Handler) setEncryptionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := h.utils.ReadJSON(w, r, &input); err != nil {
+		h.er.BadRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(input.Enabled != nil, "enabled", "must be provided"); !v.Valid() {
+		h.er.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	if err := h.service.setEnabled(user.ID, *input.Enabled); err != nil {
+		switch {
+		case errors.Is(err, vault.ErrNoKey):
+			h.er.EncryptionUnavailableResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"enabled": *input.Enabled}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
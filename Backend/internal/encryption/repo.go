@@ -0,0 +1,112 @@
+package encryption
+
+import (
+	"Backend/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type dataKey struct {
+	userID  string
+	sealed  string
+	enabled bool
+}
+
+type repo interface {
+	getDataKey(string) (*dataKey, error)
+	insertDataKey(string, string) error
+	setEnabled(string, bool) error
+	getStaleDataKeys(string, int) ([]dataKey, error)
+	updateDataKey(dataKey, string) error
+}
+
+type Model struct {
+	db *sql.DB
+}
+
+func NewRepo(db *sql.DB) *Model {
+	return &Model{db: db}
+}
+
+func (m *Model) getDataKey(userID string) (*dataKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	key := &dataKey{userID: userID}
+	if err := m.db.QueryRowContext(ctx, "SELECT data_key, enabled FROM data_key WHERE user_id = $1", userID).Scan(&key.sealed, &key.enabled); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+// insertDataKey stores a new enabled data key. A user only ever gets one, since
+// replacing it would make everything sealed with the old one unreadable.
+func (m *Model) insertDataKey(userID string, sealed string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, "INSERT INTO data_key (user_id, data_key) VALUES ($1, $2) ON CONFLICT (user_id) DO UPDATE SET enabled = TRUE", userID, sealed)
+	return err
+}
+
+func (m *Model) setEnabled(userID string, enabled bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, "UPDATE data_key SET enabled = $1 WHERE user_id = $2", enabled, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return utils.ErrRecordNotFound
+	}
+	return nil
+}
+
+// getStaleDataKeys returns data keys that aren't wrapped with the active key yet.
+func (m *Model) getStaleDataKeys(activeKeyID string, limit int) ([]dataKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, "SELECT user_id, data_key, enabled FROM data_key WHERE split_part(data_key, '.', 1) <> $1 LIMIT $2", activeKeyID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	var keys []dataKey
+	for rows.Next() {
+		var key dataKey
+		if err := rows.Scan(&key.userID, &key.sealed, &key.enabled); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (m *Model) updateDataKey(key dataKey, sealed string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, "UPDATE data_key SET data_key = $1 WHERE user_id = $2 AND data_key = $3", sealed, key.userID, key.sealed)
+	return err
+}
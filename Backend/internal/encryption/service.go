@@ -0,0 +1,164 @@
+// Package encryption keeps chat titles and messages encrypted at rest for users
+// who turn it on. Each user gets their own data key, wrapped by the server
+// keyring, and rows record whether they were stored encrypted.
+//
+// The server still holds the keys, so everything that shows content to its
+// owner decrypts it: the chat API, exports and share links, which the owner
+// chose to make public. Full text search is the exception, encrypted rows aren't
+// indexed and never match a query.
+package encryption
+
+import (
+	"Backend/utils"
+	"Backend/vault"
+	"context"
+	"errors"
+	"fmt"
+)
+
+type IService interface {
+	isEnabled(string) (bool, error)
+	setEnabled(string, bool) error
+	ForUser(string) (*UserCipher, error)
+	RewrapDataKeys(context.Context) error
+}
+
+// rewrapBatchSize is how many data keys RewrapDataKeys moves per query.
+const rewrapBatchSize = 100
+
+// UserCipher encrypts one user's chat content with their data key. Content is
+// only encrypted while the user has encryption turned on, but anything already
+// encrypted can always be decrypted.
+type UserCipher struct {
+	enabled bool
+	cipher  *vault.Cipher
+}
+
+// Encrypt returns the text to store and whether it was encrypted.
+func (c *UserCipher) Encrypt(text string) (string, bool, error) {
+	if !c.enabled {
+		return text, false, nil
+	}
+
+	sealed, err := c.cipher.Seal(text)
+	if err != nil {
+		return "", false, err
+	}
+	return sealed, true, nil
+}
+
+func (c *UserCipher) Decrypt(text string, encrypted bool) (string, error) {
+	if !encrypted {
+		return text, nil
+	}
+	if c.cipher == nil {
+		return "", errors.New("content is encrypted but the user has no data key")
+	}
+	return c.cipher.Open(text)
+}
+
+type service struct {
+	repo    repo
+	keyring *vault.Keyring
+}
+
+func NewService(repo repo, keyring *vault.Keyring) IService {
+	return &service{
+		repo:    repo,
+		keyring: keyring,
+	}
+}
+
+func (s *service) isEnabled(userID string) (bool, error) {
+	key, err := s.repo.getDataKey(userID)
+	if err != nil {
+		if errors.Is(err, utils.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return key.enabled, nil
+}
+
+// setEnabled turns encryption of new content on or off. The data key is made the
+// first time it is turned on and kept afterwards, so older content stays readable.
+func (s *service) setEnabled(userID string, enabled bool) error {
+	if !enabled {
+		if err := s.repo.setEnabled(userID, false); err != nil && !errors.Is(err, utils.ErrRecordNotFound) {
+			return err
+		}
+		return nil
+	}
+
+	if err := s.repo.setEnabled(userID, true); !errors.Is(err, utils.ErrRecordNotFound) {
+		return err
+	}
+
+	key, err := vault.NewDataKey()
+	if err != nil {
+		return err
+	}
+	sealed, err := s.keyring.Seal(key)
+	if err != nil {
+		return err
+	}
+	return s.repo.insertDataKey(userID, sealed)
+}
+
+// ForUser loads the user's data key. Users who never turned encryption on get a
+// cipher that leaves content as it is.
+func (s *service) ForUser(userID string) (*UserCipher, error) {
+	key, err := s.repo.getDataKey(userID)
+	if err != nil {
+		if errors.Is(err, utils.ErrRecordNotFound) {
+			return &UserCipher{}, nil
+		}
+		return nil, err
+	}
+
+	plaintext, err := s.keyring.Open(key.sealed)
+	if err != nil {
+		return nil, fmt.Errorf("open data key of user %s: %w", userID, err)
+	}
+
+	c, err := vault.NewCipher(plaintext, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &UserCipher{enabled: key.enabled, cipher: c}, nil
+}
+
+// RewrapDataKeys moves every data key onto the active key encryption key.
+func (s *service) RewrapDataKeys(ctx context.Context) error {
+	activeKeyID := s.keyring.ActiveKeyID()
+	if activeKeyID == "" {
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		keys, err := s.repo.getStaleDataKeys(activeKeyID, rewrapBatchSize)
+		if err != nil {
+			return err
+		}
+
+		rewrapped := 0
+		for _, key := range keys {
+			sealed, _, err := s.keyring.Rewrap(key.sealed)
+			if err != nil {
+				continue
+			}
+			if err := s.repo.updateDataKey(key, sealed); err != nil {
+				return err
+			}
+			rewrapped++
+		}
+
+		if len(keys) < rewrapBatchSize || rewrapped == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package export
+
+import (
+	"Backend/middleware"
+	"Backend/responses"
+	"Backend/userContext"
+	"Backend/utils"
+	"Backend/validator"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type Handler struct {
+	exportService IService
+	er            *responses.ErrorResponses
+	utils         *utils.Utils
+}
+
+func NewHandler(exportService IService, er *responses.ErrorResponses, utils *utils.Utils) *Handler {
+	return &Handler{
+		exportService: exportService,
+		er:            er,
+		utils:         utils,
+	}
+}
+
+func (h *Handler) RegisterRoutes(mux *http.ServeMux, middle *middleware.Middleware) {
+	mux.HandleFunc("GET /v1/chat/{id}/export", middle.RequireAuthenticatedUser(h.exportChatHandler))
+	mux.HandleFunc("GET /v1/chat/export", middle.RequireAuthenticatedUser(h.exportAllHandler))
+	mux.HandleFunc("POST /v1/account/export", middle.RequireAuthenticatedUser(h.startDataExportHandler))
+	mux.HandleFunc("GET /v1/account/export/{id}", middle.RequireAuthenticatedUser(h.getDataExportHandler))
+	mux.HandleFunc("GET /v1/account/export/{id}/download", middle.RequireAuthenticatedUser(h.downloadDataExportHandler))
+}
+
+func (h *Handler) readFormat(r *http.Request, v *validator.Validator) string {
+	f := h.utils.ReadString(r.URL.Query(), "format", "md")
+	h.exportService.checkFormat(v, f)
+	return f
+}
+
+func (h *Handler) exportChatHandler(w http.ResponseWriter, r *http.Request) {
+	chatID, err := h.utils.ReadIDParam(r)
+	if err != nil {
+		h.er.NotFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	f := h.readFormat(r, v)
+	if !v.Valid() {
+		h.er.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	transcript, err := h.exportService.getTranscript(user.ID, int32(chatID))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := h.exportService.writeTranscript(&buf, f, transcript); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", formats[f].contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%d-%s.%s"`, transcript.ID, slug(transcript.Title), formats[f].extension))
+	w.WriteHeader(http.StatusOK)
+	_, _ = buf.WriteTo(w)
+}
+
+func (h *Handler) exportAllHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	f := h.readFormat(r, v)
+	if !v.Valid() {
+		h.er.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The archive is written as it is built, which can outlast the server's
+	// default write timeout for users with many chats.
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(5 * time.Minute)); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chats-%s.zip"`, time.Now().UTC().Format("2006-01-02")))
+	w.WriteHeader(http.StatusOK)
+
+	user := userContext.ContextGetUser(r)
+	if err := h.exportService.writeArchive(w, user.ID, f); err != nil {
+		// Headers are already sent, so all that is left is to log it; the client
+		// sees a truncated archive.
+		h.er.LogError(r, err)
+	}
+}
+
+func (h *Handler) startDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	user := userContext.ContextGetUser(r)
+
+	dataExport, err := h.exportService.startDataExport(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrDuplicateEntry):
+			h.er.FailedValidationResponse(w, r, map[string]string{"export": "an export is already in progress"})
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	h.utils.Background(func() {
+		if err := h.exportService.buildDataExport(user.ID, dataExport.ID); err != nil {
+			h.er.LogError(r, err)
+		}
+	})
+
+	if err := h.utils.WriteJSON(w, http.StatusAccepted, utils.Envelope{"data_export": dataExport}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) getDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	exportID, err := h.utils.ReadIDParam(r)
+	if err != nil {
+		h.er.NotFoundResponse(w, r)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	dataExport, err := h.exportService.getDataExport(user.ID, exportID)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data_export": dataExport}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) downloadDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	exportID, err := h.utils.ReadIDParam(r)
+	if err != nil {
+		h.er.NotFoundResponse(w, r)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	archive, err := h.exportService.getDataExportArchive(user.ID, exportID)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="g3-data-export-%d.zip"`, exportID))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(archive)
+}
@@ -0,0 +1,260 @@
+package export
+
+import (
+	"Backend/internal/encryption"
+	"Backend/utils"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+type Transcript struct {
+	ID         int32               `json:"id"`
+	Title      string              `json:"title"`
+	ExportedAt time.Time           `json:"exported_at"`
+	Messages   []TranscriptMessage `json:"messages"`
+}
+
+type TranscriptMessage struct {
+	Role      string    `json:"role"`
+	Model     string    `json:"model,omitempty"`
+	Text      string    `json:"text"`
+	Stopped   bool      `json:"stopped,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// DataExport is a "download my data" job. The archive itself is only read when
+// the user downloads it.
+type DataExport struct {
+	ID          int64      `json:"id"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+}
+
+const (
+	StatusPending  = "pending"
+	StatusComplete = "complete"
+	StatusFailed   = "failed"
+)
+
+type repo interface {
+	getTranscript(string, int32, *encryption.UserCipher) (*Transcript, error)
+	getChatIDs(string, bool) ([]int32, error)
+	getAccountData(string) (map[string]json.RawMessage, error)
+	insertDataExport(string, time.Time, time.Time) (*DataExport, error)
+	getDataExport(string, int64) (*DataExport, error)
+	getDataExportArchive(string, int64) ([]byte, bool, error)
+	completeDataExport(int64, []byte, bool) error
+	failDataExport(int64, string) error
+	deleteExpiredDataExports(context.Context) error
+}
+
+type Model struct {
+	db *sql.DB
+}
+
+func NewRepo(db *sql.DB) *Model {
+	return &Model{db: db}
+}
+
+// getTranscript returns a chat with its content decrypted, exports always being
+// plaintext for the user to keep.
+func (m *Model) getTranscript(userID string, chatID int32, c *encryption.UserCipher) (*Transcript, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	transcript := &Transcript{ID: chatID, ExportedAt: time.Now().UTC()}
+	var encrypted bool
+	err := m.db.QueryRowContext(ctx, "SELECT title, encrypted FROM title WHERE id = $1 AND user_id = $2", chatID, userID).Scan(&transcript.Title, &encrypted)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.ErrRecordNotFound
+		}
+		return nil, err
+	}
+	if transcript.Title, err = c.Decrypt(transcript.Title, encrypted); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.QueryContext(ctx, "SELECT type, model, text, encrypted, stopped, timestamp::TIMESTAMPTZ FROM message WHERE title_id = $1 ORDER BY timestamp, id", chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	transcript.Messages = []TranscriptMessage{}
+	for rows.Next() {
+		var message TranscriptMessage
+		if err := rows.Scan(&message.Role, &message.Model, &message.Text, &encrypted, &message.Stopped, &message.Timestamp); err != nil {
+			return nil, err
+		}
+		if message.Text, err = c.Decrypt(message.Text, encrypted); err != nil {
+			return nil, err
+		}
+		transcript.Messages = append(transcript.Messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return transcript, nil
+}
+
+// getChatIDs lists the user's chats, including the ones in the trash only when
+// includeTrashed is set.
+func (m *Model) getChatIDs(userID string, includeTrashed bool) ([]int32, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, "SELECT id FROM title WHERE user_id = $1 AND ($2 OR deleted_at IS NULL) ORDER BY id", userID, includeTrashed)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	var ids []int32
+	for rows.Next() {
+		var id int32
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// accountDataQueries build the non-chat parts of a data export as JSON, keyed by
+// the file name they end up in. Encrypted share titles are left out, the chat
+// they point at is in the export decrypted.
+var accountDataQueries = map[string]string{
+	"profile.json": "SELECT to_jsonb(u) - 'refresh_token' - 'refresh_token_sealed' FROM users u WHERE id = $1",
+	"folders.json": "SELECT COALESCE(jsonb_agg(jsonb_build_object('id', id, 'name', name) ORDER BY id), '[]') FROM folder WHERE user_id = $1",
+	"tags.json":    "SELECT COALESCE(jsonb_agg(jsonb_build_object('id', id, 'name', name) ORDER BY id), '[]') FROM tag WHERE user_id = $1",
+	"shares.json":  "SELECT COALESCE(jsonb_agg(jsonb_build_object('id', id, 'chat_id', title_id, 'title', CASE WHEN title_encrypted THEN NULL ELSE title END, 'live', live, 'created_at', created_at) ORDER BY id), '[]') FROM share WHERE user_id = $1",
+}
+
+func (m *Model) getAccountData(userID string) (map[string]json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	data := make(map[string]json.RawMessage, len(accountDataQueries))
+	for name, query := range accountDataQueries {
+		var raw []byte
+		if err := m.db.QueryRowContext(ctx, query, userID).Scan(&raw); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, utils.ErrRecordNotFound
+			}
+			return nil, err
+		}
+		data[name] = raw
+	}
+	return data, nil
+}
+
+// insertDataExport starts a new export job unless the user already has one
+// running, in which case utils.ErrDuplicateEntry is returned. Jobs still pending
+// from before staleBefore died with their instance; they are failed so they
+// don't block new ones.
+func (m *Model) insertDataExport(userID string, expiresAt time.Time, staleBefore time.Time) (*DataExport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `
+		WITH stale AS (
+			UPDATE data_export SET status = 'failed', error = 'the export could not be created', completed_at = NOW()
+			WHERE user_id = $1 AND status = 'pending' AND created_at < $3
+		)
+		INSERT INTO data_export (user_id, expires_at)
+		SELECT $1, $2 WHERE NOT EXISTS (SELECT 1 FROM data_export WHERE user_id = $1 AND status = 'pending' AND created_at >= $3)
+		RETURNING id, created_at`
+
+	dataExport := &DataExport{Status: StatusPending, ExpiresAt: expiresAt}
+	err := m.db.QueryRowContext(ctx, query, userID, expiresAt, staleBefore).Scan(&dataExport.ID, &dataExport.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.ErrDuplicateEntry
+		}
+		return nil, err
+	}
+	return dataExport, nil
+}
+
+func (m *Model) getDataExport(userID string, exportID int64) (*DataExport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var dataExport DataExport
+	var completedAt sql.NullTime
+	err := m.db.QueryRowContext(ctx,
+		"SELECT id, status, error, created_at, completed_at, expires_at FROM data_export WHERE id = $1 AND user_id = $2 AND expires_at > NOW()",
+		exportID, userID).Scan(&dataExport.ID, &dataExport.Status, &dataExport.Error, &dataExport.CreatedAt, &completedAt, &dataExport.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.ErrRecordNotFound
+		}
+		return nil, err
+	}
+	if completedAt.Valid {
+		dataExport.CompletedAt = &completedAt.Time
+	}
+	return &dataExport, nil
+}
+
+func (m *Model) getDataExportArchive(userID string, exportID int64) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var archive []byte
+	var encrypted bool
+	err := m.db.QueryRowContext(ctx,
+		"SELECT archive, encrypted FROM data_export WHERE id = $1 AND user_id = $2 AND status = 'complete' AND expires_at > NOW()",
+		exportID, userID).Scan(&archive, &encrypted)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false, utils.ErrRecordNotFound
+		}
+		return nil, false, err
+	}
+	return archive, encrypted, nil
+}
+
+func (m *Model) completeDataExport(exportID int64, archive []byte, encrypted bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, "UPDATE data_export SET status = 'complete', archive = $1, encrypted = $2, completed_at = NOW() WHERE id = $3", archive, encrypted, exportID)
+	return err
+}
+
+func (m *Model) failDataExport(exportID int64, message string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, "UPDATE data_export SET status = 'failed', error = $1, completed_at = NOW() WHERE id = $2", message, exportID)
+	return err
+}
+
+func (m *Model) deleteExpiredDataExports(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, "DELETE FROM data_export WHERE expires_at <= NOW()")
+	return err
+}
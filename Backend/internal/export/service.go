@@ -0,0 +1,378 @@
+package export
+
+import (
+	"Backend/internal/encryption"
+	"Backend/validator"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type format struct {
+	contentType string
+	extension   string
+}
+
+var formats = map[string]format{
+	"md":   {contentType: "text/markdown; charset=utf-8", extension: "md"},
+	"json": {contentType: "application/json", extension: "json"},
+	"html": {contentType: "text/html; charset=utf-8", extension: "html"},
+}
+
+type IService interface {
+	getTranscript(string, int32) (*Transcript, error)
+	writeTranscript(io.Writer, string, *Transcript) error
+	writeArchive(io.Writer, string, string) error
+	checkFormat(*validator.Validator, string)
+
+	startDataExport(string) (*DataExport, error)
+	buildDataExport(string, int64) error
+	getDataExport(string, int64) (*DataExport, error)
+	getDataExportArchive(string, int64) ([]byte, error)
+	PurgeExpiredDataExports(context.Context) error
+}
+
+const (
+	// dataExportTTL is how long a finished data export stays downloadable.
+	dataExportTTL = 7 * 24 * time.Hour
+	// dataExportTimeout is how long an export may stay pending before it's
+	// taken for dead and another one can be started.
+	dataExportTimeout = 30 * time.Minute
+	// maxDataExportSize caps the zip archive of a data export, which is built
+	// in memory and stored as a single value.
+	maxDataExportSize = 256 << 20
+)
+
+// errDataExportTooLarge is returned once an archive outgrows maxDataExportSize.
+var errDataExportTooLarge = errors.New("data export too large")
+
+// limitWriter fails any write that would take it past limit bytes.
+type limitWriter struct {
+	w     io.Writer
+	limit int64
+	n     int64
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if l.n+int64(len(p)) > l.limit {
+		return 0, errDataExportTooLarge
+	}
+	n, err := l.w.Write(p)
+	l.n += int64(n)
+	return n, err
+}
+
+type service struct {
+	repo              repo
+	encryptionService encryption.IService
+}
+
+func NewService(repo repo, encryptionService encryption.IService) IService {
+	return &service{
+		repo:              repo,
+		encryptionService: encryptionService,
+	}
+}
+
+func (s *service) getTranscript(userID string, chatID int32) (*Transcript, error) {
+	c, err := s.encryptionService.ForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repo.getTranscript(userID, chatID, c)
+}
+
+func (s *service) checkFormat(v *validator.Validator, f string) {
+	_, ok := formats[f]
+	v.Check(ok, "format", "must be one of md, json or html")
+}
+
+func (s *service) writeTranscript(w io.Writer, f string, transcript *Transcript) error {
+	switch f {
+	case "md":
+		return writeMarkdown(w, transcript)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(transcript)
+	case "html":
+		return htmlTemplate.Execute(w, transcript)
+	}
+	return fmt.Errorf("invalid export format: %s", f)
+}
+
+// writeArchive streams every chat of userID into a zip archive, one file per chat,
+// loading a single transcript at a time.
+func (s *service) writeArchive(w io.Writer, userID string, f string) error {
+	c, err := s.encryptionService.ForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	chatIDs, err := s.repo.getChatIDs(userID, false)
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	for _, chatID := range chatIDs {
+		transcript, err := s.repo.getTranscript(userID, chatID, c)
+		if err != nil {
+			return err
+		}
+
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     fmt.Sprintf("%d-%s.%s", transcript.ID, slug(transcript.Title), formats[f].extension),
+			Method:   zip.Deflate,
+			Modified: transcript.ExportedAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := s.writeTranscript(fw, f, transcript); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func (s *service) startDataExport(userID string) (*DataExport, error) {
+	now := time.Now()
+	return s.repo.insertDataExport(userID, now.Add(dataExportTTL), now.Add(-dataExportTimeout))
+}
+
+// buildDataExport bundles everything stored about the user into a zip archive:
+// their profile, folders, tags, share links and every chat as JSON and Markdown.
+// Failures are recorded on the job so the user can see them. While the archive
+// waits to be downloaded it is encrypted like the rest of the user's content.
+// An archive larger than maxDataExportSize fails the job.
+func (s *service) buildDataExport(userID string, exportID int64) error {
+	archive, encrypted, err := s.writeDataExport(userID)
+	if err != nil {
+		reason := "the export could not be created"
+		if errors.Is(err, errDataExportTooLarge) {
+			reason = "the export is too large to be created"
+		}
+		if failErr := s.repo.failDataExport(exportID, reason); failErr != nil {
+			return errors.Join(err, failErr)
+		}
+		return err
+	}
+	return s.repo.completeDataExport(exportID, archive, encrypted)
+}
+
+func (s *service) writeDataExport(userID string) ([]byte, bool, error) {
+	c, err := s.encryptionService.ForUser(userID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	archive, err := s.writeDataExportArchive(userID, c)
+	if err != nil {
+		return nil, false, err
+	}
+
+	sealed, encrypted, err := c.Encrypt(string(archive))
+	if err != nil {
+		return nil, false, err
+	}
+	return []byte(sealed), encrypted, nil
+}
+
+func (s *service) writeDataExportArchive(userID string, c *encryption.UserCipher) ([]byte, error) {
+	accountData, err := s.repo.getAccountData(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&limitWriter{w: &buf, limit: maxDataExportSize})
+
+	for name, data := range accountData {
+		fw, err := zw.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fw.Write(data); err != nil {
+			return nil, err
+		}
+	}
+
+	chatIDs, err := s.repo.getChatIDs(userID, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, chatID := range chatIDs {
+		transcript, err := s.repo.getTranscript(userID, chatID, c)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range []string{"json", "md"} {
+			fw, err := zw.Create(fmt.Sprintf("chats/%d-%s.%s", transcript.ID, slug(transcript.Title), formats[f].extension))
+			if err != nil {
+				return nil, err
+			}
+			if err := s.writeTranscript(fw, f, transcript); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *service) getDataExport(userID string, exportID int64) (*DataExport, error) {
+	return s.repo.getDataExport(userID, exportID)
+}
+
+func (s *service) getDataExportArchive(userID string, exportID int64) ([]byte, error) {
+	archive, encrypted, err := s.repo.getDataExportArchive(userID, exportID)
+	if err != nil || !encrypted {
+		return archive, err
+	}
+
+	c, err := s.encryptionService.ForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := c.Decrypt(string(archive), true)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(plaintext), nil
+}
+
+func (s *service) PurgeExpiredDataExports(ctx context.Context) error {
+	return s.repo.deleteExpiredDataExports(ctx)
+}
+
+func roleName(role string) string {
+	switch role {
+	case "human":
+		return "User"
+	case "ai":
+		return "Assistant"
+	case "system":
+		return "System"
+	}
+	return role
+}
+
+func writeMarkdown(w io.Writer, transcript *Transcript) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# %s\n\n_Exported %s_\n", transcript.Title, transcript.ExportedAt.Format(time.RFC1123))
+	for _, message := range transcript.Messages {
+		fmt.Fprintf(&b, "\n---\n\n### %s", roleName(message.Role))
+		if message.Model != "" {
+			fmt.Fprintf(&b, " (%s)", message.Model)
+		}
+		fmt.Fprintf(&b, " · %s\n\n%s\n", message.Timestamp.UTC().Format(time.RFC1123), strings.TrimRight(message.Text, "\n"))
+		if message.Stopped {
+			b.WriteString("\n_Stopped_\n")
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// segment is a run of message text that is either prose or the body of a fenced
+// code block, so the HTML export can keep code blocks verbatim.
+type segment struct {
+	Code     bool
+	Language string
+	Text     string
+}
+
+func splitCodeBlocks(text string) []segment {
+	var segments []segment
+	var b strings.Builder
+	inCode := false
+	language := ""
+
+	flush := func() {
+		if b.Len() > 0 || inCode {
+			segments = append(segments, segment{Code: inCode, Language: language, Text: b.String()})
+		}
+		b.Reset()
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			flush()
+			inCode = !inCode
+			language = strings.TrimPrefix(trimmed, "```")
+			continue
+		}
+		b.WriteString(line)
+	}
+	flush()
+
+	return segments
+}
+
+func slug(title string) string {
+	s := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return '-'
+	}, title)
+	s = strings.Trim(s, "-")
+	for strings.Contains(s, "--") {
+		s = strings.ReplaceAll(s, "--", "-")
+	}
+	// Titles aren't all ASCII, so they're cut at a character rather than a byte.
+	if runes := []rune(s); len(runes) > 50 {
+		s = strings.TrimRight(string(runes[:50]), "-")
+	}
+	if s == "" {
+		s = "chat"
+	}
+	return s
+}
+
+var htmlTemplate = template.Must(template.New("transcript").Funcs(template.FuncMap{
+	"roleName": roleName,
+	"segments": splitCodeBlocks,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 50rem; margin: 2rem auto; padding: 0 1rem; line-height: 1.5; }
+.meta, header { color: #666; font-size: 0.875rem; }
+section { border-top: 1px solid #ddd; padding: 1rem 0; }
+.text { white-space: pre-wrap; }
+pre { background: #f6f8fa; padding: 0.75rem; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="meta">Exported <time datetime="{{.ExportedAt.Format "2006-01-02T15:04:05Z07:00"}}">{{.ExportedAt.Format "Mon, 02 Jan 2006 15:04:05 MST"}}</time></p>
+{{range .Messages}}<section class="{{.Role}}">
+<header>{{roleName .Role}}{{with .Model}} ({{.}}){{end}} · <time datetime="{{.Timestamp.Format "2006-01-02T15:04:05Z07:00"}}">{{.Timestamp.UTC.Format "Mon, 02 Jan 2006 15:04:05 MST"}}</time></header>
+{{range segments .Text}}{{if .Code}}<pre><code{{with .Language}} class="language-{{.}}"{{end}}>{{.Text}}</code></pre>
+{{else}}<div class="text">{{.Text}}</div>
+{{end}}{{end}}</section>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,61 @@
+package generation
+
+import (
+	"Backend/middleware"
+	"Backend/responses"
+	"Backend/userContext"
+	"Backend/utils"
+	"Backend/validator"
+	"errors"
+	"net/http"
+)
+
+type Handler struct {
+	service IService
+	er      *responses.ErrorResponses
+	utils   *utils.Utils
+}
+
+func NewHandler(service IService, er *responses.ErrorResponses, utils *utils.Utils) *Handler {
+	return &Handler{
+		service: service,
+		er:      er,
+		utils:   utils,
+	}
+}
+
+func (h *Handler) RegisterRoutes(mux *http.ServeMux, middle *middleware.Middleware) {
+	mux.HandleFunc("POST /v1/generations/{id}/cancel", h.cancelGenerationHandler)
+}
+
+// cancelGenerationHandler is the stop button of streamed replies and jobs. Guests
+// identify themselves with the Guest-Token header their chat was started with.
+func (h *Handler) cancelGenerationHandler(w http.ResponseWriter, r *http.Request) {
+	generationID := r.PathValue("id")
+
+	user := userContext.ContextGetUser(r)
+	var guestToken string
+	if user.IsAnonymous() {
+		guestToken = r.Header.Get("Guest-Token")
+
+		v := validator.New()
+		if v.Check(guestToken != "", "guest_token", "must be provided"); !v.Valid() {
+			h.er.FailedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	if err := h.service.cancel(Owner(user.ID, guestToken), generationID); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusAccepted, utils.Envelope{"message": "Generation Stop Requested!"}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,78 @@
+package generation
+
+import (
+	"context"
+	"github.com/valkey-io/valkey-go"
+	"time"
+)
+
+type repo interface {
+	insert(string, string, time.Duration) error
+	getOwner(string) (string, error)
+	markCancelled(string, time.Duration) error
+	isCancelled(string) (bool, error)
+	delete(string) error
+}
+
+type Model struct {
+	vk valkey.Client
+}
+
+func NewRepo(vk valkey.Client) *Model {
+	return &Model{vk: vk}
+}
+
+// A generation is kept under its ID with its owner as the value, and a second
+// key marks it as cancelled.
+func generationKey(generationID string) string {
+	return "generation:" + generationID
+}
+
+func cancelKey(generationID string) string {
+	return "generation:" + generationID + ":cancel"
+}
+
+func (m *Model) insert(generationID string, owner string, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.vk.Do(ctx, m.vk.B().Set().Key(generationKey(generationID)).Value(owner).Ex(ttl).Build()).Error()
+}
+
+// getOwner returns the owner of a generation, or "" for an unknown or finished
+// generation.
+func (m *Model) getOwner(generationID string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	owner, err := m.vk.Do(ctx, m.vk.B().Get().Key(generationKey(generationID)).Build()).ToString()
+	if err != nil {
+		if valkey.IsValkeyNil(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return owner, nil
+}
+
+func (m *Model) markCancelled(generationID string, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.vk.Do(ctx, m.vk.B().Set().Key(cancelKey(generationID)).Value("1").Ex(ttl).Build()).Error()
+}
+
+func (m *Model) isCancelled(generationID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	count, err := m.vk.Do(ctx, m.vk.B().Exists().Key(cancelKey(generationID)).Build()).AsInt64()
+	return count == 1, err
+}
+
+func (m *Model) delete(generationID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.vk.Do(ctx, m.vk.B().Del().Key(generationKey(generationID), cancelKey(generationID)).Build()).Error()
+}
@@ -0,0 +1,121 @@
+// Package generation lets users stop a reply while it's being generated. Sync
+// prompts stop when their request goes away; replies that outlive their request,
+// streamed ones and jobs, get a generation ID that can be cancelled from any
+// instance.
+package generation
+
+import (
+	"Backend/utils"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// ErrStopped is returned with the partial reply of a stopped generation, which
+// has been saved marked as stopped.
+var ErrStopped = errors.New("generation stopped")
+
+const (
+	// generationTTL bounds how long a generation can be cancelled after it was
+	// started, jobs included.
+	generationTTL = 24 * time.Hour
+	// pollInterval is how often a running generation checks for being cancelled.
+	pollInterval = time.Second
+)
+
+type IService interface {
+	Start(string) (string, error)
+	Watch(context.Context, string) (context.Context, context.CancelFunc)
+	Cancelled(string) (bool, error)
+	cancel(string, string) error
+}
+
+type service struct {
+	repo repo
+}
+
+func NewService(repo repo) IService {
+	return &service{
+		repo: repo,
+	}
+}
+
+// Owner identifies who may cancel a generation: a user, or a guest by the hash
+// of their guest token.
+func Owner(userID string, guestToken string) string {
+	if userID != "" {
+		return "user:" + userID
+	}
+	hash := sha256.Sum256([]byte(guestToken))
+	return "guest:" + hex.EncodeToString(hash[:])
+}
+
+// Start hands out the ID of a new generation of owner.
+func (s *service) Start(owner string) (string, error) {
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	generationID := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	if err := s.repo.insert(generationID, owner, generationTTL); err != nil {
+		return "", err
+	}
+	return generationID, nil
+}
+
+// Watch returns a context that is cancelled once the generation is, along with
+// the function to call when the generation is over. A generation cancelled
+// before it was watched, such as a job still in the queue, is cancelled right
+// away.
+func (s *service) Watch(ctx context.Context, generationID string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+
+		for {
+			if cancelled, err := s.repo.isCancelled(generationID); err == nil && cancelled {
+				cancel()
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return ctx, func() {
+		cancel()
+		// Both keys expire anyway, deleting them only stops a late cancel
+		// from being accepted.
+		_ = s.repo.delete(generationID)
+	}
+}
+
+// Cancelled reports whether the generation was asked to stop, for a generation
+// that may have been cancelled before it started, such as a queued job.
+func (s *service) Cancelled(generationID string) (bool, error) {
+	return s.repo.isCancelled(generationID)
+}
+
+// cancel asks the generation to stop, which it does within pollInterval.
+// utils.ErrRecordNotFound is returned unless it's a running generation of owner.
+func (s *service) cancel(owner string, generationID string) error {
+	current, err := s.repo.getOwner(generationID)
+	if err != nil {
+		return err
+	}
+	if current == "" || current != owner {
+		return utils.ErrRecordNotFound
+	}
+	return s.repo.markCancelled(generationID, generationTTL)
+}
@@ -0,0 +1,81 @@
+package importer
+
+import (
+	"Backend/middleware"
+	"Backend/responses"
+	"Backend/userContext"
+	"Backend/utils"
+	"Backend/validator"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// maxImportBytes is well above utils.ReadJSON's limit, since exports hold a
+// user's whole history.
+const maxImportBytes = 64 << 20
+
+type Handler struct {
+	importService IService
+	er            *responses.ErrorResponses
+	utils         *utils.Utils
+}
+
+func NewHandler(importService IService, er *responses.ErrorResponses, utils *utils.Utils) *Handler {
+	return &Handler{
+		importService: importService,
+		er:            er,
+		utils:         utils,
+	}
+}
+
+func (h *Handler) RegisterRoutes(mux *http.ServeMux, middle *middleware.Middleware) {
+	mux.HandleFunc("POST /v1/chat/import", middle.RequireAuthenticatedUser(h.importHandler))
+}
+
+func (h *Handler) importHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	source := h.utils.ReadString(r.URL.Query(), "source", "")
+	if h.importService.checkSource(v, source); !v.Valid() {
+		h.er.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Now().Add(2 * time.Minute)); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+		return
+	}
+	if err := rc.SetWriteDeadline(time.Now().Add(5 * time.Minute)); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			h.er.BadRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxImportBytes))
+			return
+		}
+		h.er.BadRequestResponse(w, r, err)
+		return
+	}
+	if len(data) == 0 {
+		h.er.BadRequestResponse(w, r, errors.New("body must not be empty"))
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	results, err := h.importService.importConversations(user.ID, source, data)
+	if err != nil {
+		h.er.BadRequestResponse(w, r, err)
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"results": results}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
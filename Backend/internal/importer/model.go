@@ -0,0 +1,36 @@
+package importer
+
+import "time"
+
+// Conversation is a chat parsed out of an export file, before it is stored.
+// SourceID identifies it within its Source so re-imports can be skipped.
+type Conversation struct {
+	Source   string
+	SourceID string
+	Title    string
+	Messages []Message
+}
+
+type Message struct {
+	Role      string
+	Model     string
+	Text      string
+	Timestamp time.Time
+}
+
+const (
+	StatusImported = "imported"
+	StatusSkipped  = "skipped"
+	StatusFailed   = "failed"
+)
+
+// Result reports what happened to one conversation of an import.
+type Result struct {
+	Index    int    `json:"index"`
+	SourceID string `json:"source_id,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Status   string `json:"status"`
+	ChatID   int32  `json:"chat_id,omitempty"`
+	Messages int    `json:"messages,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
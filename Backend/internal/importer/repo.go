@@ -0,0 +1,80 @@
+package importer
+
+import (
+	"Backend/internal/encryption"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type repo interface {
+	insertConversation(string, *Conversation, *encryption.UserCipher) (int32, error)
+}
+
+type Model struct {
+	db *sql.DB
+}
+
+func NewRepo(db *sql.DB) *Model {
+	return &Model{db: db}
+}
+
+// insertConversation stores a conversation with its original timestamps and
+// returns the new chat ID, or 0 if it was already imported by this user.
+func (m *Model) insertConversation(userID string, conversation *Conversation, c *encryption.UserCipher) (int32, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	lastActivity := time.Now()
+	if n := len(conversation.Messages); n > 0 {
+		lastActivity = conversation.Messages[n-1].Timestamp
+	}
+
+	title, encrypted, err := c.Encrypt(conversation.Title)
+	if err != nil {
+		return 0, err
+	}
+
+	var chatID int32
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO title (user_id, title, encrypted, import_source, import_id, last_activity) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (user_id, import_source, import_id) DO NOTHING RETURNING id",
+		userID, title, encrypted, conversation.Source, conversation.SourceID, lastActivity).Scan(&chatID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO message (title_id, type, model, text, encrypted, timestamp) VALUES ($1, $2, $3, $4, $5, $6::TIMESTAMPTZ)")
+	if err != nil {
+		return 0, err
+	}
+	defer func(stmt *sql.Stmt) {
+		_ = stmt.Close()
+	}(stmt)
+
+	for _, message := range conversation.Messages {
+		text, encrypted, err := c.Encrypt(message.Text)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := stmt.ExecContext(ctx, chatID, message.Role, message.Model, text, encrypted, message.Timestamp); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return chatID, nil
+}
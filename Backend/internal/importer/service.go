@@ -0,0 +1,342 @@
+package importer
+
+import (
+	"Backend/internal/encryption"
+	"Backend/validator"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tmc/langchaingo/llms"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	SourceChatGPT = "chatgpt"
+	SourceClaude  = "claude"
+	SourceG3      = "g3"
+)
+
+var errNoMessages = errors.New("conversation has no text messages")
+
+type IService interface {
+	importConversations(string, string, []byte) ([]Result, error)
+	checkSource(*validator.Validator, string)
+}
+
+type service struct {
+	repo              repo
+	encryptionService encryption.IService
+}
+
+func NewService(repo repo, encryptionService encryption.IService) IService {
+	return &service{
+		repo:              repo,
+		encryptionService: encryptionService,
+	}
+}
+
+func (s *service) checkSource(v *validator.Validator, source string) {
+	v.Check(validator.In(source, "", SourceChatGPT, SourceClaude, SourceG3), "source", "must be one of chatgpt, claude or g3")
+}
+
+// importConversations stores every conversation in data, which is either a
+// single exported conversation or an array of them. With an empty source the
+// format of each conversation is detected from its fields. A failing
+// conversation is reported in its Result and doesn't stop the rest.
+func (s *service) importConversations(userID string, source string, data []byte) ([]Result, error) {
+	var raws []json.RawMessage
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		if err := json.Unmarshal(data, &raws); err != nil {
+			return nil, fmt.Errorf("body contains badly-formed JSON: %w", err)
+		}
+	} else {
+		raws = []json.RawMessage{data}
+	}
+
+	c, err := s.encryptionService.ForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, len(raws))
+	for i, raw := range raws {
+		results[i] = Result{Index: i}
+
+		conversation, err := parseConversation(source, raw)
+		if err != nil {
+			results[i].Status = StatusFailed
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].SourceID = conversation.SourceID
+		results[i].Title = conversation.Title
+
+		chatID, err := s.repo.insertConversation(userID, conversation, c)
+		switch {
+		case err != nil:
+			results[i].Status = StatusFailed
+			results[i].Error = err.Error()
+		case chatID == 0:
+			results[i].Status = StatusSkipped
+		default:
+			results[i].Status = StatusImported
+			results[i].ChatID = chatID
+			results[i].Messages = len(conversation.Messages)
+		}
+	}
+
+	return results, nil
+}
+
+func parseConversation(source string, raw json.RawMessage) (*Conversation, error) {
+	if source == "" {
+		var probe map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &probe); err != nil {
+			return nil, errors.New("conversation must be a JSON object")
+		}
+		switch {
+		case probe["mapping"] != nil:
+			source = SourceChatGPT
+		case probe["chat_messages"] != nil:
+			source = SourceClaude
+		case probe["messages"] != nil:
+			source = SourceG3
+		default:
+			return nil, errors.New("unrecognized conversation format")
+		}
+	}
+
+	var conversation *Conversation
+	var err error
+	switch source {
+	case SourceChatGPT:
+		conversation, err = parseChatGPT(raw)
+	case SourceClaude:
+		conversation, err = parseClaude(raw)
+	case SourceG3:
+		conversation, err = parseG3(raw)
+	default:
+		return nil, fmt.Errorf("invalid source: %s", source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(conversation.Messages) == 0 {
+		return nil, errNoMessages
+	}
+	if conversation.SourceID == "" {
+		return nil, errors.New("conversation has no ID")
+	}
+	conversation.Source = source
+	conversation.Title = normalizeTitle(conversation.Title)
+
+	// Fill in missing timestamps so messages keep their order once stored.
+	previous := time.Now()
+	for i := len(conversation.Messages) - 1; i >= 0; i-- {
+		if conversation.Messages[i].Timestamp.IsZero() {
+			conversation.Messages[i].Timestamp = previous
+		}
+		previous = conversation.Messages[i].Timestamp
+	}
+	return conversation, nil
+}
+
+// parseChatGPT reads one entry of ChatGPT's conversations.json. Messages form a
+// tree of edits and regenerations, so only the branch ending at current_node is
+// kept.
+func parseChatGPT(raw json.RawMessage) (*Conversation, error) {
+	var input struct {
+		ID             string  `json:"id"`
+		ConversationID string  `json:"conversation_id"`
+		Title          string  `json:"title"`
+		CreateTime     float64 `json:"create_time"`
+		CurrentNode    string  `json:"current_node"`
+		Mapping        map[string]struct {
+			Parent  string `json:"parent"`
+			Message *struct {
+				Author struct {
+					Role string `json:"role"`
+				} `json:"author"`
+				CreateTime float64 `json:"create_time"`
+				Content    struct {
+					ContentType string `json:"content_type"`
+					Parts       []any  `json:"parts"`
+				} `json:"content"`
+				Metadata struct {
+					ModelSlug string `json:"model_slug"`
+				} `json:"metadata"`
+			} `json:"message"`
+		} `json:"mapping"`
+	}
+	if err := json.Unmarshal(raw, &input); err != nil {
+		return nil, err
+	}
+
+	conversation := &Conversation{SourceID: input.ConversationID, Title: input.Title}
+	if conversation.SourceID == "" {
+		conversation.SourceID = input.ID
+	}
+
+	createTime := unixSeconds(input.CreateTime)
+	seen := make(map[string]bool)
+	for nodeID := input.CurrentNode; nodeID != "" && !seen[nodeID]; nodeID = input.Mapping[nodeID].Parent {
+		seen[nodeID] = true
+
+		message := input.Mapping[nodeID].Message
+		if message == nil || message.Content.ContentType != "text" {
+			continue
+		}
+		role, ok := mapRole(message.Author.Role)
+		if !ok {
+			continue
+		}
+
+		var parts []string
+		for _, part := range message.Content.Parts {
+			if text, ok := part.(string); ok && text != "" {
+				parts = append(parts, text)
+			}
+		}
+		if len(parts) == 0 {
+			continue
+		}
+
+		timestamp := createTime
+		if message.CreateTime != 0 {
+			timestamp = unixSeconds(message.CreateTime)
+		}
+		conversation.Messages = append(conversation.Messages, Message{
+			Role:      role,
+			Model:     message.Metadata.ModelSlug,
+			Text:      strings.Join(parts, "\n"),
+			Timestamp: timestamp,
+		})
+	}
+
+	// The walk went from the leaf up to the root.
+	for i, j := 0, len(conversation.Messages)-1; i < j; i, j = i+1, j-1 {
+		conversation.Messages[i], conversation.Messages[j] = conversation.Messages[j], conversation.Messages[i]
+	}
+	return conversation, nil
+}
+
+// parseClaude reads one entry of the conversations.json in Claude's data export.
+func parseClaude(raw json.RawMessage) (*Conversation, error) {
+	var input struct {
+		UUID         string `json:"uuid"`
+		Name         string `json:"name"`
+		ChatMessages []struct {
+			Text      string    `json:"text"`
+			Sender    string    `json:"sender"`
+			CreatedAt time.Time `json:"created_at"`
+			Content   []struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"content"`
+		} `json:"chat_messages"`
+	}
+	if err := json.Unmarshal(raw, &input); err != nil {
+		return nil, err
+	}
+
+	conversation := &Conversation{SourceID: input.UUID, Title: input.Name}
+	for _, message := range input.ChatMessages {
+		role, ok := mapRole(message.Sender)
+		if !ok {
+			continue
+		}
+
+		text := message.Text
+		if text == "" {
+			var parts []string
+			for _, content := range message.Content {
+				if content.Type == "text" && content.Text != "" {
+					parts = append(parts, content.Text)
+				}
+			}
+			text = strings.Join(parts, "\n")
+		}
+		if text == "" {
+			continue
+		}
+
+		conversation.Messages = append(conversation.Messages, Message{
+			Role:      role,
+			Text:      text,
+			Timestamp: message.CreatedAt,
+		})
+	}
+
+	sort.SliceStable(conversation.Messages, func(i, j int) bool {
+		return conversation.Messages[i].Timestamp.Before(conversation.Messages[j].Timestamp)
+	})
+	return conversation, nil
+}
+
+// parseG3 reads a transcript produced by the JSON export of this app.
+func parseG3(raw json.RawMessage) (*Conversation, error) {
+	var input struct {
+		ID       int32  `json:"id"`
+		Title    string `json:"title"`
+		Messages []struct {
+			Role      string    `json:"role"`
+			Model     string    `json:"model"`
+			Text      string    `json:"text"`
+			Timestamp time.Time `json:"timestamp"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(raw, &input); err != nil {
+		return nil, err
+	}
+
+	conversation := &Conversation{Title: input.Title}
+	if input.ID != 0 {
+		conversation.SourceID = fmt.Sprint(input.ID)
+	}
+	for _, message := range input.Messages {
+		role, ok := mapRole(message.Role)
+		if !ok || message.Text == "" {
+			continue
+		}
+		conversation.Messages = append(conversation.Messages, Message{
+			Role:      role,
+			Model:     message.Model,
+			Text:      message.Text,
+			Timestamp: message.Timestamp,
+		})
+	}
+	return conversation, nil
+}
+
+func mapRole(role string) (string, bool) {
+	switch role {
+	case "user", "human":
+		return string(llms.ChatMessageTypeHuman), true
+	case "assistant", "ai":
+		return string(llms.ChatMessageTypeAI), true
+	}
+	return "", false
+}
+
+func unixSeconds(seconds float64) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+	return time.UnixMicro(int64(seconds * 1e6))
+}
+
+func normalizeTitle(title string) string {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return "Imported Chat"
+	}
+	if runes := []rune(title); len(runes) > 255 {
+		title = string(runes[:255])
+	}
+	return title
+}
@@ -0,0 +1,54 @@
+package job
+
+import (
+	"Backend/middleware"
+	"Backend/responses"
+	"Backend/userContext"
+	"Backend/utils"
+	"errors"
+	"net/http"
+)
+
+type Handler struct {
+	service IService
+	er      *responses.ErrorResponses
+	utils   *utils.Utils
+}
+
+func NewHandler(service IService, er *responses.ErrorResponses, utils *utils.Utils) *Handler {
+	return &Handler{
+		service: service,
+		er:      er,
+		utils:   utils,
+	}
+}
+
+func (h *Handler) RegisterRoutes(mux *http.ServeMux, middle *middleware.Middleware) {
+	mux.HandleFunc("GET /v1/jobs/{id}", middle.RequireAuthenticatedUser(h.getJobHandler))
+}
+
+// getJobHandler is polled by clients that sent a prompt in job mode, until the
+// job is complete or failed.
+func (h *Handler) getJobHandler(w http.ResponseWriter, r *http.Request) {
+	jobID, err := h.utils.ReadIDParam(r)
+	if err != nil {
+		h.er.NotFoundResponse(w, r)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	job, err := h.service.getJob(user.ID, jobID)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"job": job}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,47 @@
+package job
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	StatusPending  = "pending"
+	StatusRunning  = "running"
+	StatusComplete = "complete"
+	StatusFailed   = "failed"
+	StatusStopped  = "stopped"
+)
+
+var ErrJobLimit = errors.New("too many unfinished jobs")
+
+// Job is a prompt answered in the background instead of within the request that
+// sent it. Result holds the reply once Status is complete, or what was generated
+// of it once stopped; it is also appended to the chat like any other reply. The
+// job is stopped by cancelling GenerationID.
+type Job struct {
+	ID           int64      `json:"id"`
+	ChatID       int32      `json:"chat_id"`
+	GenerationID string     `json:"generation_id"`
+	Status       string     `json:"status"`
+	Result       string     `json:"result,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"`
+}
+
+// task is everything a worker needs to run a job. The prompt and the API key
+// are only kept until the job finishes, the key sealed by the keyring.
+type task struct {
+	id              int64
+	generationID    string
+	userID          string
+	chatID          int32
+	modelType       string
+	model           string
+	prompt          string
+	promptEncrypted bool
+	apiKey          string
+	attempts        int
+}
@@ -0,0 +1,189 @@
+package job
+
+import (
+	"Backend/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type repo interface {
+	insert(*task, int) (*Job, error)
+	get(string, int64) (*Job, bool, error)
+	claim(int) ([]task, error)
+	heartbeat(int64) error
+	complete(int64, string, string, bool) error
+	fail(int64, string) error
+	requeueStale(time.Duration, int) error
+	deleteFinished(time.Duration) error
+}
+
+type Model struct {
+	db *sql.DB
+}
+
+func NewRepo(db *sql.DB) *Model {
+	return &Model{db: db}
+}
+
+// insert queues a job for a chat of the user, unless the user already has
+// maxUnfinished jobs pending or running, in which case ErrJobLimit is returned.
+func (m *Model) insert(t *task, maxUnfinished int) (*Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := m.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM title WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL)", t.chatID, t.userID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, utils.ErrRecordNotFound
+	}
+
+	var apiKey *string
+	if t.apiKey != "" {
+		apiKey = &t.apiKey
+	}
+
+	query := `
+		INSERT INTO generation_job (user_id, title_id, model_type, model, prompt, prompt_encrypted, api_key, generation_id)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8
+		WHERE (SELECT COUNT(*) FROM generation_job WHERE user_id = $1 AND status IN ('pending', 'running')) < $9
+		RETURNING id, created_at`
+
+	job := &Job{ChatID: t.chatID, GenerationID: t.generationID, Status: StatusPending}
+	err = m.db.QueryRowContext(ctx, query, t.userID, t.chatID, t.modelType, t.model, t.prompt, t.promptEncrypted, apiKey, t.generationID, maxUnfinished).
+		Scan(&job.ID, &job.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrJobLimit
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+// get returns a job of the user along with whether its result is encrypted.
+func (m *Model) get(userID string, jobID int64) (*Job, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	job := &Job{ID: jobID}
+	var resultEncrypted bool
+	var startedAt, completedAt sql.NullTime
+	err := m.db.QueryRowContext(ctx,
+		"SELECT title_id, generation_id, status, result, result_encrypted, error, created_at, started_at, completed_at FROM generation_job WHERE id = $1 AND user_id = $2",
+		jobID, userID).Scan(&job.ChatID, &job.GenerationID, &job.Status, &job.Result, &resultEncrypted, &job.Error, &job.CreatedAt, &startedAt, &completedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false, utils.ErrRecordNotFound
+		}
+		return nil, false, err
+	}
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if completedAt.Valid {
+		job.CompletedAt = &completedAt.Time
+	}
+	return job, resultEncrypted, nil
+}
+
+// claim marks up to limit pending jobs, oldest first, as running and returns
+// them. Jobs claimed by another instance at the same time are skipped.
+func (m *Model) claim(limit int) ([]task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE generation_job SET status = 'running', started_at = NOW(), heartbeat_at = NOW(), attempts = attempts + 1
+		WHERE id IN (SELECT id FROM generation_job WHERE status = 'pending' ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED)
+		RETURNING id, generation_id, user_id, title_id, model_type, model, prompt, prompt_encrypted, COALESCE(api_key, ''), attempts`
+
+	rows, err := m.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	var tasks []task
+	for rows.Next() {
+		var t task
+		if err := rows.Scan(&t.id, &t.generationID, &t.userID, &t.chatID, &t.modelType, &t.model, &t.prompt, &t.promptEncrypted, &t.apiKey, &t.attempts); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+// heartbeat tells that the job is still running on a live instance.
+func (m *Model) heartbeat(jobID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, "UPDATE generation_job SET heartbeat_at = NOW() WHERE id = $1 AND status = 'running'", jobID)
+	return err
+}
+
+// complete stores the result of a job, complete or stopped, and forgets its
+// prompt and API key.
+func (m *Model) complete(jobID int64, status string, result string, resultEncrypted bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx,
+		"UPDATE generation_job SET status = $1, result = $2, result_encrypted = $3, prompt = '', api_key = NULL, completed_at = NOW() WHERE id = $4",
+		status, result, resultEncrypted, jobID)
+	return err
+}
+
+func (m *Model) fail(jobID int64, message string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx,
+		"UPDATE generation_job SET status = 'failed', error = $1, prompt = '', api_key = NULL, completed_at = NOW() WHERE id = $2",
+		message, jobID)
+	return err
+}
+
+// requeueStale puts running jobs without a heartbeat for longer than staleAfter
+// back in the queue, their instance having died. Jobs that already had
+// maxAttempts tries fail instead.
+func (m *Model) requeueStale(staleAfter time.Duration, maxAttempts int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE generation_job SET status = 'pending', started_at = NULL, heartbeat_at = NULL
+		WHERE status = 'running' AND COALESCE(heartbeat_at, started_at) < $1 AND attempts < $2`
+	if _, err := m.db.ExecContext(ctx, query, time.Now().Add(-staleAfter), maxAttempts); err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE generation_job SET status = 'failed', error = 'the job was interrupted too many times', prompt = '', api_key = NULL, completed_at = NOW()
+		WHERE status = 'running' AND COALESCE(heartbeat_at, started_at) < $1`
+	_, err := m.db.ExecContext(ctx, query, time.Now().Add(-staleAfter))
+	return err
+}
+
+// deleteFinished deletes jobs that completed or failed more than retention ago.
+func (m *Model) deleteFinished(retention time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, "DELETE FROM generation_job WHERE completed_at < $1", time.Now().Add(-retention))
+	return err
+}
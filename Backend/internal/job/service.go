@@ -0,0 +1,258 @@
+package job
+
+import (
+	"Backend/internal/encryption"
+	"Backend/internal/generation"
+	"Backend/policy"
+	"Backend/utils"
+	"Backend/vault"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	// maxUnfinishedJobs is how many jobs one user may have pending or running.
+	maxUnfinishedJobs = 5
+	// maxRunningJobs is how many jobs one instance runs at the same time.
+	maxRunningJobs = 8
+	// heartbeatInterval is how often a running job tells it's still alive.
+	heartbeatInterval = time.Minute
+	// staleAfter is how long a running job may go without a heartbeat before
+	// it's taken for lost with its instance and queued again, at most
+	// maxAttempts times in all.
+	staleAfter  = 5 * time.Minute
+	maxAttempts = 3
+	// jobRetention is how long finished jobs can still be looked up.
+	jobRetention = 7 * 24 * time.Hour
+)
+
+// chatService is the part of chat.IService that runs a job. It is declared here
+// because the chat handler submits jobs, so chat can't be imported back.
+type chatService interface {
+	AppendPrompt(context.Context, string, int32, string, string, string, string, string) (string, error)
+	FindReply(string, int32, string) (string, bool, error)
+}
+
+type IService interface {
+	Submit(string, int32, string, string, string, string) (*Job, error)
+	getJob(string, int64) (*Job, error)
+	RunPending(context.Context) error
+	PurgeFinishedJobs(context.Context) error
+}
+
+type service struct {
+	repo              repo
+	chatService       chatService
+	encryptionService encryption.IService
+	generationService generation.IService
+	keyring           *vault.Keyring
+	utils             *utils.Utils
+	logger            *slog.Logger
+	slots             chan struct{}
+}
+
+func NewService(repo repo, chatService chatService, encryptionService encryption.IService, generationService generation.IService, keyring *vault.Keyring, utils *utils.Utils, logger *slog.Logger) IService {
+	return &service{
+		repo:              repo,
+		chatService:       chatService,
+		encryptionService: encryptionService,
+		generationService: generationService,
+		keyring:           keyring,
+		utils:             utils,
+		logger:            logger,
+		slots:             make(chan struct{}, maxRunningJobs),
+	}
+}
+
+// Submit queues a prompt to one of the user's chats. The prompt is stored like a
+// message of the chat, encrypted if the user turned that on, and an API key sent
+// with the request is sealed until the job is done. The job can be stopped
+// through its generation ID from the start, even while it's still queued.
+func (s *service) Submit(userID string, chatID int32, modelType string, model string, apiKey string, prompt string) (*Job, error) {
+	c, err := s.encryptionService.ForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &task{userID: userID, chatID: chatID, modelType: modelType, model: model}
+	if t.prompt, t.promptEncrypted, err = c.Encrypt(prompt); err != nil {
+		return nil, err
+	}
+	if apiKey != "" {
+		if t.apiKey, err = s.keyring.Seal([]byte(apiKey)); err != nil {
+			return nil, err
+		}
+	}
+	if t.generationID, err = s.generationService.Start(generation.Owner(userID, "")); err != nil {
+		return nil, err
+	}
+
+	return s.repo.insert(t, maxUnfinishedJobs)
+}
+
+func (s *service) getJob(userID string, jobID int64) (*Job, error) {
+	job, resultEncrypted, err := s.repo.get(userID, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job.Result == "" {
+		return job, nil
+	}
+
+	c, err := s.encryptionService.ForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if job.Result, err = c.Decrypt(job.Result, resultEncrypted); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// RunPending claims as many pending jobs as this instance has free slots for
+// and runs each in the background. Jobs are tracked like any other background
+// work, so the server finishes them before shutting down.
+func (s *service) RunPending(ctx context.Context) error {
+	if err := s.repo.requeueStale(staleAfter, maxAttempts); err != nil {
+		return err
+	}
+
+	free := cap(s.slots) - len(s.slots)
+	if free == 0 || ctx.Err() != nil {
+		return nil
+	}
+
+	tasks, err := s.repo.claim(free)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tasks {
+		s.slots <- struct{}{}
+		s.utils.Background(func() {
+			defer func() { <-s.slots }()
+
+			if err := s.run(t); err != nil {
+				s.logger.Error(err.Error(), "job", t.id)
+			}
+		})
+	}
+	return nil
+}
+
+// run answers a job's prompt and stores the reply, or why there is none. The job
+// heartbeats while it runs, so it isn't taken for lost however long it takes.
+func (s *service) run(t task) error {
+	// A job cancelled while it was queued is stopped before it says anything.
+	cancelled, err := s.generationService.Cancelled(t.generationID)
+	if err != nil {
+		return err
+	}
+	if cancelled {
+		return s.repo.complete(t.id, StatusStopped, "", false)
+	}
+
+	ctx, stop := s.generationService.Watch(context.Background(), t.generationID)
+	defer stop()
+
+	go s.heartbeat(ctx, t.id)
+
+	status := StatusComplete
+	result, resultEncrypted, err := s.answer(ctx, t)
+	if errors.Is(err, generation.ErrStopped) {
+		status, err = StatusStopped, nil
+	}
+	if err != nil {
+		if failErr := s.repo.fail(t.id, jobFailure(err)); failErr != nil {
+			return errors.Join(err, failErr)
+		}
+		return err
+	}
+	return s.repo.complete(t.id, status, result, resultEncrypted)
+}
+
+// heartbeat keeps the job's heartbeat up until ctx is done.
+func (s *service) heartbeat(ctx context.Context, jobID int64) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.repo.heartbeat(jobID); err != nil {
+				s.logger.Error(err.Error(), "job", jobID)
+			}
+		}
+	}
+}
+
+// answer returns the reply to the job's prompt, encrypted for storage. A stopped
+// reply comes with generation.ErrStopped. A job that is tried again first looks
+// for the reply an earlier try may have stored before it was interrupted.
+func (s *service) answer(ctx context.Context, t task) (string, bool, error) {
+	c, err := s.encryptionService.ForUser(t.userID)
+	if err != nil {
+		return "", false, err
+	}
+
+	if t.attempts > 1 {
+		reply, stopped, err := s.chatService.FindReply(t.userID, t.chatID, t.generationID)
+		switch {
+		case err == nil:
+			result, resultEncrypted, err := c.Encrypt(reply)
+			if err == nil && stopped {
+				err = generation.ErrStopped
+			}
+			return result, resultEncrypted, err
+		case !errors.Is(err, utils.ErrRecordNotFound):
+			return "", false, err
+		}
+	}
+
+	prompt, err := c.Decrypt(t.prompt, t.promptEncrypted)
+	if err != nil {
+		return "", false, err
+	}
+
+	var apiKey string
+	if t.apiKey != "" {
+		key, err := s.keyring.Open(t.apiKey)
+		if err != nil {
+			return "", false, fmt.Errorf("opening api key: %w", err)
+		}
+		apiKey = string(key)
+	}
+
+	reply, err := s.chatService.AppendPrompt(ctx, t.userID, t.chatID, t.modelType, t.model, apiKey, prompt, t.generationID)
+	if err != nil && !errors.Is(err, generation.ErrStopped) {
+		return "", false, err
+	}
+
+	result, resultEncrypted, encryptErr := c.Encrypt(reply)
+	if encryptErr != nil {
+		return "", false, encryptErr
+	}
+	return result, resultEncrypted, err
+}
+
+// jobFailure is the error shown to the user for a failed job, without any
+// internal detail.
+func jobFailure(err error) string {
+	switch {
+	case errors.Is(err, utils.ErrRecordNotFound):
+		return "the chat no longer exists"
+	case errors.Is(err, policy.ErrBlocked):
+		return err.Error()
+	default:
+		return "the prompt could not be answered"
+	}
+}
+
+func (s *service) PurgeFinishedJobs(ctx context.Context) error {
+	return s.repo.deleteFinished(jobRetention)
+}
@@ -0,0 +1,215 @@
+package organize
+
+import (
+	"Backend/middleware"
+	"Backend/responses"
+	"Backend/userContext"
+	"Backend/utils"
+	"Backend/validator"
+	"errors"
+	"net/http"
+)
+
+type Handler struct {
+	service IService
+	er      *responses.ErrorResponses
+	utils   *utils.Utils
+}
+
+func NewHandler(service IService, er *responses.ErrorResponses, utils *utils.Utils) *Handler {
+	return &Handler{
+		service: service,
+		er:      er,
+		utils:   utils,
+	}
+}
+
+func (h *Handler) RegisterRoutes(mux *http.ServeMux, middle *middleware.Middleware) {
+	mux.HandleFunc("GET /v1/folders", middle.RequireAuthenticatedUser(h.getFoldersHandler))
+	mux.HandleFunc("POST /v1/folders", middle.RequireAuthenticatedUser(h.createFolderHandler))
+	mux.HandleFunc("PATCH /v1/folders/{id}", middle.RequireAuthenticatedUser(h.renameFolderHandler))
+	mux.HandleFunc("DELETE /v1/folders/{id}", middle.RequireAuthenticatedUser(h.deleteFolderHandler))
+	mux.HandleFunc("GET /v1/tags", middle.RequireAuthenticatedUser(h.getTagsHandler))
+	mux.HandleFunc("POST /v1/tags", middle.RequireAuthenticatedUser(h.createTagHandler))
+	mux.HandleFunc("DELETE /v1/tags/{id}", middle.RequireAuthenticatedUser(h.deleteTagHandler))
+}
+
+func (h *Handler) getFoldersHandler(w http.ResponseWriter, r *http.Request) {
+	user := userContext.ContextGetUser(r)
+
+	folders, err := h.service.getFolders(user.ID)
+	if err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"folders": folders}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) createFolderHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+	}
+	if err := h.utils.ReadJSON(w, r, &input); err != nil {
+		h.er.BadRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if h.service.checkFolderName(v, input.Name); !v.Valid() {
+		h.er.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	folder, err := h.service.createFolder(user.ID, input.Name)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrDuplicateEntry):
+			v.AddError("name", "a folder with this name already exists")
+			h.er.FailedValidationResponse(w, r, v.Errors)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"folder": folder}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) renameFolderHandler(w http.ResponseWriter, r *http.Request) {
+	folderID, err := h.utils.ReadIDParam(r)
+	if err != nil {
+		h.er.NotFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Name string `json:"name"`
+	}
+	if err := h.utils.ReadJSON(w, r, &input); err != nil {
+		h.er.BadRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if h.service.checkFolderName(v, input.Name); !v.Valid() {
+		h.er.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	if err := h.service.renameFolder(user.ID, folderID, input.Name); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		case errors.Is(err, utils.ErrDuplicateEntry):
+			v.AddError("name", "a folder with this name already exists")
+			h.er.FailedValidationResponse(w, r, v.Errors)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"folder": Folder{ID: folderID, Name: input.Name}}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) deleteFolderHandler(w http.ResponseWriter, r *http.Request) {
+	folderID, err := h.utils.ReadIDParam(r)
+	if err != nil {
+		h.er.NotFoundResponse(w, r)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	if err := h.service.deleteFolder(user.ID, folderID); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "Folder Deletion Successful!"}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) getTagsHandler(w http.ResponseWriter, r *http.Request) {
+	user := userContext.ContextGetUser(r)
+
+	tags, err := h.service.getTags(user.ID)
+	if err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"tags": tags}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) createTagHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+	}
+	if err := h.utils.ReadJSON(w, r, &input); err != nil {
+		h.er.BadRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if h.service.checkTagName(v, input.Name); !v.Valid() {
+		h.er.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	tag, err := h.service.createTag(user.ID, input.Name)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrDuplicateEntry):
+			v.AddError("name", "a tag with this name already exists")
+			h.er.FailedValidationResponse(w, r, v.Errors)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"tag": tag}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) deleteTagHandler(w http.ResponseWriter, r *http.Request) {
+	tagID, err := h.utils.ReadIDParam(r)
+	if err != nil {
+		h.er.NotFoundResponse(w, r)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	if err := h.service.deleteTag(user.ID, tagID); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "Tag Deletion Successful!"}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,177 @@
+package organize
+
+import (
+	"Backend/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"time"
+)
+
+type Folder struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+type Tag struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+type repo interface {
+	getFolders(string) ([]Folder, error)
+	insertFolder(string, string) (*Folder, error)
+	renameFolder(string, int64, string) error
+	deleteFolder(string, int64) error
+	getTags(string) ([]Tag, error)
+	insertTag(string, string) (*Tag, error)
+	deleteTag(string, int64) error
+}
+
+type Model struct {
+	db *sql.DB
+}
+
+func NewRepo(db *sql.DB) *Model {
+	return &Model{db: db}
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func checkRowsAffected(result sql.Result) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return utils.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m *Model) getFolders(userID string) ([]Folder, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, "SELECT id, name FROM folder WHERE user_id = $1 ORDER BY name", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	folders := []Folder{}
+	for rows.Next() {
+		var folder Folder
+		if err := rows.Scan(&folder.ID, &folder.Name); err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return folders, nil
+}
+
+func (m *Model) insertFolder(userID string, name string) (*Folder, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	folder := &Folder{Name: name}
+	if err := m.db.QueryRowContext(ctx, "INSERT INTO folder (user_id, name) VALUES ($1, $2) RETURNING id", userID, name).Scan(&folder.ID); err != nil {
+		if isUniqueViolation(err) {
+			return nil, utils.ErrDuplicateEntry
+		}
+		return nil, err
+	}
+	return folder, nil
+}
+
+func (m *Model) renameFolder(userID string, folderID int64, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, "UPDATE folder SET name = $1 WHERE id = $2 AND user_id = $3", name, folderID, userID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return utils.ErrDuplicateEntry
+		}
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// deleteFolder removes the folder only; its chats stay and drop out of it.
+func (m *Model) deleteFolder(userID string, folderID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, "DELETE FROM folder WHERE id = $1 AND user_id = $2", folderID, userID)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+func (m *Model) getTags(userID string) ([]Tag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, "SELECT id, name FROM tag WHERE user_id = $1 ORDER BY name", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	tags := []Tag{}
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.ID, &tag.Name); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+func (m *Model) insertTag(userID string, name string) (*Tag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tag := &Tag{Name: name}
+	if err := m.db.QueryRowContext(ctx, "INSERT INTO tag (user_id, name) VALUES ($1, $2) RETURNING id", userID, name).Scan(&tag.ID); err != nil {
+		if isUniqueViolation(err) {
+			return nil, utils.ErrDuplicateEntry
+		}
+		return nil, err
+	}
+	return tag, nil
+}
+
+func (m *Model) deleteTag(userID string, tagID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, "DELETE FROM tag WHERE id = $1 AND user_id = $2", tagID, userID)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
@@ -0,0 +1,63 @@
+package organize
+
+import "Backend/validator"
+
+type IService interface {
+	getFolders(string) ([]Folder, error)
+	createFolder(string, string) (*Folder, error)
+	renameFolder(string, int64, string) error
+	deleteFolder(string, int64) error
+	getTags(string) ([]Tag, error)
+	createTag(string, string) (*Tag, error)
+	deleteTag(string, int64) error
+	checkFolderName(*validator.Validator, string)
+	checkTagName(*validator.Validator, string)
+}
+
+type service struct {
+	repo repo
+}
+
+func NewService(repo repo) IService {
+	return &service{
+		repo: repo,
+	}
+}
+
+func (s *service) getFolders(userID string) ([]Folder, error) {
+	return s.repo.getFolders(userID)
+}
+
+func (s *service) createFolder(userID string, name string) (*Folder, error) {
+	return s.repo.insertFolder(userID, name)
+}
+
+func (s *service) renameFolder(userID string, folderID int64, name string) error {
+	return s.repo.renameFolder(userID, folderID, name)
+}
+
+func (s *service) deleteFolder(userID string, folderID int64) error {
+	return s.repo.deleteFolder(userID, folderID)
+}
+
+func (s *service) getTags(userID string) ([]Tag, error) {
+	return s.repo.getTags(userID)
+}
+
+func (s *service) createTag(userID string, name string) (*Tag, error) {
+	return s.repo.insertTag(userID, name)
+}
+
+func (s *service) deleteTag(userID string, tagID int64) error {
+	return s.repo.deleteTag(userID, tagID)
+}
+
+func (s *service) checkFolderName(v *validator.Validator, name string) {
+	v.Check(name != "", "name", "must be provided")
+	v.Check(len(name) <= 255, "name", "must not be more than 255 bytes long")
+}
+
+func (s *service) checkTagName(v *validator.Validator, name string) {
+	v.Check(name != "", "name", "must be provided")
+	v.Check(len(name) <= 50, "name", "must not be more than 50 bytes long")
+}
@@ -0,0 +1,195 @@
+package schedule
+
+import (
+	"Backend/middleware"
+	"Backend/responses"
+	"Backend/userContext"
+	"Backend/utils"
+	"Backend/validator"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+type Handler struct {
+	service IService
+	er      *responses.ErrorResponses
+	utils   *utils.Utils
+}
+
+func NewHandler(service IService, er *responses.ErrorResponses, utils *utils.Utils) *Handler {
+	return &Handler{
+		service: service,
+		er:      er,
+		utils:   utils,
+	}
+}
+
+func (h *Handler) RegisterRoutes(mux *http.ServeMux, middle *middleware.Middleware) {
+	mux.HandleFunc("GET /v1/schedules", middle.RequireAuthenticatedUser(h.getSchedulesHandler))
+	mux.HandleFunc("POST /v1/schedules", middle.RequireAuthenticatedUser(h.createScheduleHandler))
+	mux.HandleFunc("GET /v1/schedules/{id}", middle.RequireAuthenticatedUser(h.getScheduleHandler))
+	mux.HandleFunc("PATCH /v1/schedules/{id}", middle.RequireAuthenticatedUser(h.updateScheduleHandler))
+	mux.HandleFunc("DELETE /v1/schedules/{id}", middle.RequireAuthenticatedUser(h.deleteScheduleHandler))
+}
+
+func (h *Handler) getSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	user := userContext.ContextGetUser(r)
+
+	schedules, err := h.service.getSchedules(user.ID)
+	if err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"schedules": schedules}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) getScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	scheduleID, err := h.utils.ReadIDParam(r)
+	if err != nil {
+		h.er.NotFoundResponse(w, r)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	schedule, err := h.service.getSchedule(user.ID, scheduleID)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"schedule": schedule}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) createScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ChatID     int32             `json:"chat_id"`
+		Cron       string            `json:"cron"`
+		Timezone   string            `json:"timezone"`
+		ModelType  string            `json:"model_type"`
+		Model      string            `json:"model"` //optional
+		Prompt     string            `json:"prompt"`
+		TemplateID *int64            `json:"template_id"`
+		Variables  map[string]string `json:"variables"`
+		Enabled    *bool             `json:"enabled"` //defaults to true
+	}
+	if err := h.utils.ReadJSON(w, r, &input); err != nil {
+		h.er.BadRequestResponse(w, r, err)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	schedule := &Schedule{
+		ChatID:     input.ChatID,
+		Cron:       input.Cron,
+		Timezone:   input.Timezone,
+		ModelType:  input.ModelType,
+		Model:      input.Model,
+		Prompt:     input.Prompt,
+		TemplateID: input.TemplateID,
+		Variables:  input.Variables,
+		Enabled:    input.Enabled == nil || *input.Enabled,
+		userID:     user.ID,
+	}
+
+	v := validator.New()
+	if err := h.service.checkSchedule(v, schedule); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		h.er.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := h.service.createSchedule(schedule); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			v.AddError("chat_id", "does not exist")
+			h.er.FailedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, errScheduleLimit):
+			v.AddError("schedule", fmt.Sprintf("you can't have more than %d schedules", maxSchedules))
+			h.er.FailedValidationResponse(w, r, v.Errors)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"schedule": schedule}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) updateScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	scheduleID, err := h.utils.ReadIDParam(r)
+	if err != nil {
+		h.er.NotFoundResponse(w, r)
+		return
+	}
+
+	var input ScheduleUpdate
+	if err := h.utils.ReadJSON(w, r, &input); err != nil {
+		h.er.BadRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if h.service.checkScheduleUpdate(v, input); !v.Valid() {
+		h.er.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	schedule, err := h.service.updateSchedule(v, user.ID, scheduleID, input)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !v.Valid() {
+		h.er.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"schedule": schedule}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) deleteScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	scheduleID, err := h.utils.ReadIDParam(r)
+	if err != nil {
+		h.er.NotFoundResponse(w, r)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	if err := h.service.deleteSchedule(user.ID, scheduleID); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "Schedule Deletion Successful!"}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,223 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	// Schedules run in the timezone their owner picked, which must not depend on
+	// the zone database of the host.
+	_ "time/tzdata"
+)
+
+var (
+	errScheduleLimit   = errors.New("schedule limit reached")
+	errTemplateChanged = errors.New("the template's variables no longer match the schedule")
+)
+
+// Schedule sends a prompt to one of the user's chats whenever its cron
+// expression matches in Timezone. The prompt is either Prompt itself or the
+// template TemplateID rendered with Variables.
+type Schedule struct {
+	ID         int64             `json:"id"`
+	ChatID     int32             `json:"chat_id"`
+	Cron       string            `json:"cron"`
+	Timezone   string            `json:"timezone"`
+	ModelType  string            `json:"model_type"`
+	Model      string            `json:"model,omitempty"`
+	Prompt     string            `json:"prompt,omitempty"`
+	TemplateID *int64            `json:"template_id,omitempty"`
+	Variables  map[string]string `json:"variables,omitempty"`
+	Enabled    bool              `json:"enabled"`
+	NextRunAt  time.Time         `json:"next_run_at"`
+	LastRunAt  *time.Time        `json:"last_run_at,omitempty"`
+	LastError  string            `json:"last_error,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+
+	userID string
+}
+
+// ScheduleUpdate holds the fields of a schedule to change; nil fields are left
+// untouched. Setting Prompt drops the template and setting TemplateID drops the
+// prompt, a schedule only ever has one of them.
+type ScheduleUpdate struct {
+	Cron       *string           `json:"cron"`
+	Timezone   *string           `json:"timezone"`
+	ModelType  *string           `json:"model_type"`
+	Model      *string           `json:"model"`
+	Prompt     *string           `json:"prompt"`
+	TemplateID *int64            `json:"template_id"`
+	Variables  map[string]string `json:"variables"`
+	Enabled    *bool             `json:"enabled"`
+}
+
+// cronSpec is a parsed five field cron expression: minute, hour, day of month,
+// month and day of week. Each field is a bitset of the values it matches.
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar record a day field starting with "*". As in cron, a
+	// day matches if either day field does when both are restricted.
+	domStar, dowStar bool
+}
+
+var cronFields = [5]struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// parseCron reads the usual "minute hour day-of-month month day-of-week" form,
+// with "*", lists, ranges and steps. Sunday is both 0 and 7.
+func parseCron(expr string) (*cronSpec, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.New("must have five fields: minute, hour, day of month, month and day of week")
+	}
+
+	var bits [5]uint64
+	for i, field := range fields {
+		b, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", cronFields[i].name, err)
+		}
+		bits[i] = b
+	}
+
+	spec := &cronSpec{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}
+	if spec.dow&(1<<7) != 0 {
+		spec.dow |= 1
+	}
+	return spec, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		span, stepText, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		if span != "*" {
+			start, end, isRange := strings.Cut(span, "-")
+
+			var err error
+			if lo, err = strconv.Atoi(start); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			switch {
+			case isRange:
+				if hi, err = strconv.Atoi(end); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			case !hasStep:
+				hi = lo
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+
+		for n := lo; n <= hi; n += step {
+			bits |= 1 << uint(n)
+		}
+	}
+	return bits, nil
+}
+
+// next returns the first matching minute after t, in t's location. It gives up
+// and returns the zero time after five years, which only a date that never
+// exists such as February 30th takes. Around a daylight saving change every
+// wall-clock time is tried once: a time the clock skips when it goes forward
+// is missed that day, and the hour it repeats when it goes back isn't run twice.
+func (c *cronSpec) next(t time.Time) time.Time {
+	loc := t.Location()
+	t = addMinute(t.Truncate(time.Minute))
+
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = wallClock(t.Year(), t.Month()+1, 1, 0, loc)
+		case !c.dayMatches(t):
+			t = wallClock(t.Year(), t.Month(), t.Day()+1, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = wallClock(t.Year(), t.Month(), t.Day(), t.Hour()+1, loc)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = addMinute(t)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// wallClock is the start of the given hour in loc. time.Date puts an hour the
+// clock skips before the gap, where next would never get past it, so it's moved
+// to just after the gap instead.
+func wallClock(year int, month time.Month, day int, hour int, loc *time.Location) time.Time {
+	t := time.Date(year, month, day, hour, 0, 0, 0, loc)
+	want := time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+	got := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+	return t.Add(want.Sub(got))
+}
+
+// addMinute moves t on a minute. When that turns the clock back, the repeated
+// wall-clock times have already been tried and are skipped.
+func addMinute(t time.Time) time.Time {
+	next := t.Add(time.Minute)
+	_, before := t.Zone()
+	if _, after := next.Zone(); after < before {
+		next = next.Add(time.Duration(before-after) * time.Second)
+	}
+	return next
+}
+
+func (c *cronSpec) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// nextRun is when a schedule with the given cron expression and timezone fires
+// next after t.
+func nextRun(cron string, timezone string, t time.Time) (time.Time, error) {
+	spec, err := parseCron(cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	next := spec.next(t.In(loc))
+	if next.IsZero() {
+		return time.Time{}, errors.New("never matches a date")
+	}
+	return next.UTC(), nil
+}
@@ -0,0 +1,157 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func bitsOf(values ...int) uint64 {
+	var bits uint64
+	for _, v := range values {
+		bits |= 1 << uint(v)
+	}
+	return bits
+}
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+		min   int
+		max   int
+		want  uint64
+		valid bool
+	}{
+		{"star", "*", 0, 7, bitsOf(0, 1, 2, 3, 4, 5, 6, 7), true},
+		{"single value", "5", 0, 59, bitsOf(5), true},
+		{"list", "1,3,5", 0, 59, bitsOf(1, 3, 5), true},
+		{"range", "2-4", 0, 59, bitsOf(2, 3, 4), true},
+		{"star step", "*/15", 0, 59, bitsOf(0, 15, 30, 45), true},
+		{"range step", "10-20/5", 0, 59, bitsOf(10, 15, 20), true},
+		{"start step", "50/5", 0, 59, bitsOf(50, 55), true},
+		{"step past the end", "1-4/3", 1, 12, bitsOf(1, 4), true},
+		{"list of ranges and steps", "1-2,10,*/20", 0, 59, bitsOf(0, 1, 2, 10, 20, 40), true},
+		{"bounds", "1-31", 1, 31, bitsOf(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31), true},
+		{"below min", "0", 1, 31, 0, false},
+		{"above max", "60", 0, 59, 0, false},
+		{"range above max", "50-60", 0, 59, 0, false},
+		{"reversed range", "5-1", 0, 59, 0, false},
+		{"zero step", "*/0", 0, 59, 0, false},
+		{"negative step", "*/-1", 0, 59, 0, false},
+		{"bad step", "*/x", 0, 59, 0, false},
+		{"not a number", "x", 0, 59, 0, false},
+		{"bad range end", "1-x", 0, 59, 0, false},
+		{"empty list entry", "1,", 0, 59, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCronField(tt.field, tt.min, tt.max)
+			if (err == nil) != tt.valid {
+				t.Fatalf("parseCronField(%q) error = %v, want valid %t", tt.field, err, tt.valid)
+			}
+			if got != tt.want {
+				t.Errorf("parseCronField(%q) = %b, want %b", tt.field, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		name  string
+		expr  string
+		valid bool
+	}{
+		{"every minute", "* * * * *", true},
+		{"weekdays at nine", "0 9 * * 1-5", true},
+		{"extra spaces", " 0  9 * *  1 ", true},
+		{"too few fields", "0 9 * *", false},
+		{"too many fields", "0 9 * * * *", false},
+		{"hour out of range", "0 24 * * *", false},
+		{"day of month zero", "0 9 0 * *", false},
+		{"month out of range", "0 9 * 13 *", false},
+		{"day of week out of range", "0 9 * * 8", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseCron(tt.expr)
+			if (err == nil) != tt.valid {
+				t.Errorf("parseCron(%q) error = %v, want valid %t", tt.expr, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Santiago's clocks go forward at midnight.
+	santiago, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(loc *time.Location, year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, loc)
+	}
+
+	// 2026-10-19 is a Monday.
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"next minute", "* * * * *", at(time.UTC, 2026, 10, 19, 10, 0), at(time.UTC, 2026, 10, 19, 10, 1)},
+		{"seconds are dropped", "* * * * *", at(time.UTC, 2026, 10, 19, 10, 0).Add(30 * time.Second), at(time.UTC, 2026, 10, 19, 10, 1)},
+		{"later today", "30 12 * * *", at(time.UTC, 2026, 10, 19, 10, 0), at(time.UTC, 2026, 10, 19, 12, 30)},
+		{"tomorrow", "30 9 * * *", at(time.UTC, 2026, 10, 19, 10, 0), at(time.UTC, 2026, 10, 20, 9, 30)},
+		{"steps", "*/20 * * * *", at(time.UTC, 2026, 10, 19, 10, 21), at(time.UTC, 2026, 10, 19, 10, 40)},
+		{"next month", "0 0 1 * *", at(time.UTC, 2026, 10, 19, 10, 0), at(time.UTC, 2026, 11, 1, 0, 0)},
+		{"next year", "0 0 1 1 *", at(time.UTC, 2026, 10, 19, 10, 0), at(time.UTC, 2027, 1, 1, 0, 0)},
+		{"leap day", "0 0 29 2 *", at(time.UTC, 2026, 10, 19, 10, 0), at(time.UTC, 2028, 2, 29, 0, 0)},
+		{"sunday as 0", "0 9 * * 0", at(time.UTC, 2026, 10, 19, 10, 0), at(time.UTC, 2026, 10, 25, 9, 0)},
+		{"sunday as 7", "0 9 * * 7", at(time.UTC, 2026, 10, 19, 10, 0), at(time.UTC, 2026, 10, 25, 9, 0)},
+		{"weekdays skip the weekend", "0 9 * * 1-5", at(time.UTC, 2026, 10, 23, 10, 0), at(time.UTC, 2026, 10, 26, 9, 0)},
+		{"either day field, day of month first", "0 9 20 * 5", at(time.UTC, 2026, 10, 19, 10, 0), at(time.UTC, 2026, 10, 20, 9, 0)},
+		{"either day field, day of week first", "0 9 30 * 3", at(time.UTC, 2026, 10, 19, 10, 0), at(time.UTC, 2026, 10, 21, 9, 0)},
+		{"day of month with star day of week", "0 9 30 * *", at(time.UTC, 2026, 10, 19, 10, 0), at(time.UTC, 2026, 10, 30, 9, 0)},
+		{"day of week with star day of month", "0 9 * * 3", at(time.UTC, 2026, 10, 19, 10, 0), at(time.UTC, 2026, 10, 21, 9, 0)},
+		{"stepped star day of week is not restricted", "0 9 13 * */2", at(time.UTC, 2026, 10, 19, 10, 0), at(time.UTC, 2026, 12, 13, 9, 0)},
+		{"in the schedule's timezone", "0 9 * * *", at(newYork, 2026, 10, 19, 10, 0), at(newYork, 2026, 10, 20, 9, 0)},
+		{"across spring forward", "0 9 * * *", at(newYork, 2026, 3, 7, 10, 0), at(newYork, 2026, 3, 8, 9, 0)},
+		{"across fall back", "0 9 * * *", at(newYork, 2026, 10, 31, 10, 0), at(newYork, 2026, 11, 1, 9, 0)},
+		{"hour skipped by spring forward", "30 2 * * *", at(newYork, 2026, 3, 7, 10, 0), at(newYork, 2026, 3, 9, 2, 30)},
+		{"hour repeated by fall back", "30 1 * * *", at(newYork, 2026, 10, 31, 10, 0), at(newYork, 2026, 11, 1, 1, 30)},
+		{"repeated hour runs once", "30 1 * * *", at(newYork, 2026, 11, 1, 1, 30), at(newYork, 2026, 11, 2, 1, 30)},
+		{"every minute through fall back", "* * * * *", at(newYork, 2026, 11, 1, 1, 59), at(newYork, 2026, 11, 1, 1, 59).Add(time.Hour + time.Minute)},
+		{"every minute through spring forward", "* * * * *", at(newYork, 2026, 3, 8, 1, 59), at(newYork, 2026, 3, 8, 3, 0)},
+		{"midnight skipped by spring forward", "0 9 * * *", time.Date(2026, 9, 5, 10, 0, 0, 0, santiago), time.Date(2026, 9, 6, 9, 0, 0, 0, santiago)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("parseCron(%q) error = %v", tt.expr, err)
+			}
+			if got := spec.next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("next(%v) for %q = %v, want %v", tt.from, tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	spec, err := parseCron("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := spec.next(time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)); !got.IsZero() {
+		t.Errorf("next() for February 30th = %v, want the zero time", got)
+	}
+}
@@ -0,0 +1,279 @@
+package schedule
+
+import (
+	"Backend/utils"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/valkey-io/valkey-go"
+	"time"
+)
+
+type repo interface {
+	getAll(string) ([]Schedule, error)
+	get(string, int64) (*Schedule, error)
+	count(string) (int, error)
+	insert(*Schedule) error
+	update(*Schedule) error
+	delete(string, int64) error
+	getDue(time.Time, int) ([]Schedule, error)
+	lock(int64, time.Time, time.Duration) (bool, error)
+	claim(int64, time.Time, time.Time) (bool, error)
+	finishRun(int64, string, bool) error
+}
+
+type Model struct {
+	db *sql.DB
+	vk valkey.Client
+}
+
+func NewRepo(db *sql.DB, vk valkey.Client) *Model {
+	return &Model{db: db, vk: vk}
+}
+
+const scheduleColumns = "id, user_id, title_id, cron, timezone, model_type, model, prompt, template_id, variables, enabled, next_run_at, last_run_at, last_error, created_at, updated_at"
+
+type scanner interface {
+	Scan(...any) error
+}
+
+func scanSchedule(row scanner) (*Schedule, error) {
+	var schedule Schedule
+	var templateID sql.NullInt64
+	var variables []byte
+	var lastRunAt sql.NullTime
+
+	err := row.Scan(&schedule.ID, &schedule.userID, &schedule.ChatID, &schedule.Cron, &schedule.Timezone, &schedule.ModelType, &schedule.Model,
+		&schedule.Prompt, &templateID, &variables, &schedule.Enabled, &schedule.NextRunAt, &lastRunAt, &schedule.LastError, &schedule.CreatedAt, &schedule.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if templateID.Valid {
+		schedule.TemplateID = &templateID.Int64
+	}
+	if lastRunAt.Valid {
+		schedule.LastRunAt = &lastRunAt.Time
+	}
+	if err := json.Unmarshal(variables, &schedule.Variables); err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+func encodeVariables(variables map[string]string) (string, error) {
+	if len(variables) == 0 {
+		return "{}", nil
+	}
+	b, err := json.Marshal(variables)
+	return string(b), err
+}
+
+func (m *Model) getAll(userID string) ([]Schedule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, "SELECT "+scheduleColumns+" FROM prompt_schedule WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	schedules := []Schedule{}
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, *schedule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+func (m *Model) get(userID string, scheduleID int64) (*Schedule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	schedule, err := scanSchedule(m.db.QueryRowContext(ctx, "SELECT "+scheduleColumns+" FROM prompt_schedule WHERE id = $1 AND user_id = $2", scheduleID, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return schedule, nil
+}
+
+func (m *Model) count(userID string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM prompt_schedule WHERE user_id = $1", userID).Scan(&count)
+	return count, err
+}
+
+// insert saves a new schedule for a chat of its user. utils.ErrRecordNotFound is
+// returned when the chat doesn't exist, belongs to someone else or is trashed.
+func (m *Model) insert(schedule *Schedule) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	variables, err := encodeVariables(schedule.Variables)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO prompt_schedule (user_id, title_id, cron, timezone, model_type, model, prompt, template_id, variables, enabled, next_run_at)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+		WHERE EXISTS (SELECT 1 FROM title WHERE id = $2 AND user_id = $1 AND deleted_at IS NULL)
+		RETURNING id, created_at, updated_at`
+
+	err = m.db.QueryRowContext(ctx, query, schedule.userID, schedule.ChatID, schedule.Cron, schedule.Timezone, schedule.ModelType, schedule.Model,
+		schedule.Prompt, schedule.TemplateID, variables, schedule.Enabled, schedule.NextRunAt).Scan(&schedule.ID, &schedule.CreatedAt, &schedule.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return utils.ErrRecordNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// update saves every field of the schedule a user may change, along with its
+// recomputed next run.
+func (m *Model) update(schedule *Schedule) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	variables, err := encodeVariables(schedule.Variables)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE prompt_schedule
+		SET cron = $3, timezone = $4, model_type = $5, model = $6, prompt = $7, template_id = $8, variables = $9,
+			enabled = $10, next_run_at = $11, updated_at = NOW()
+		WHERE id = $1 AND user_id = $2
+		RETURNING updated_at`
+
+	err = m.db.QueryRowContext(ctx, query, schedule.ID, schedule.userID, schedule.Cron, schedule.Timezone, schedule.ModelType, schedule.Model,
+		schedule.Prompt, schedule.TemplateID, variables, schedule.Enabled, schedule.NextRunAt).Scan(&schedule.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return utils.ErrRecordNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (m *Model) delete(userID string, scheduleID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, "DELETE FROM prompt_schedule WHERE id = $1 AND user_id = $2", scheduleID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return utils.ErrRecordNotFound
+	}
+	return nil
+}
+
+// getDue returns the enabled schedules whose next run is not after now, the
+// longest overdue first.
+func (m *Model) getDue(now time.Time, limit int) ([]Schedule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, "SELECT "+scheduleColumns+" FROM prompt_schedule WHERE enabled AND next_run_at <= $1 ORDER BY next_run_at LIMIT $2", now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	var schedules []Schedule
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, *schedule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+// lock takes the Valkey lock on one run of a schedule, the run being told apart
+// by its due time. It reports false when another instance already holds it. The
+// lock is never released, it expires after ttl.
+func (m *Model) lock(scheduleID int64, dueAt time.Time, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	key := fmt.Sprintf("schedule:%d:%d", scheduleID, dueAt.Unix())
+	err := m.vk.Do(ctx, m.vk.B().Set().Key(key).Value("1").Nx().Px(ttl).Build()).Error()
+	if err != nil {
+		if valkey.IsValkeyNil(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// claim moves a schedule on from the run due at dueAt to nextRunAt. It reports
+// false when the schedule was changed or already moved on in the meantime, so
+// the run must be skipped.
+func (m *Model) claim(scheduleID int64, dueAt time.Time, nextRunAt time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx,
+		"UPDATE prompt_schedule SET next_run_at = $3, last_run_at = NOW() WHERE id = $1 AND next_run_at = $2 AND enabled",
+		scheduleID, dueAt, nextRunAt)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// finishRun records how a run went, turning the schedule off when disable is set.
+func (m *Model) finishRun(scheduleID int64, lastError string, disable bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, "UPDATE prompt_schedule SET last_error = $1, enabled = enabled AND NOT $2 WHERE id = $3", lastError, disable, scheduleID)
+	return err
+}
@@ -0,0 +1,268 @@
+package schedule
+
+import (
+	"Backend/config"
+	"Backend/internal/chat"
+	"Backend/internal/template"
+	"Backend/policy"
+	"Backend/utils"
+	"Backend/validator"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// maxSchedules is how many schedules one user may have.
+	maxSchedules = 25
+	// runBatchSize is how many due schedules RunDue fires at once.
+	runBatchSize = 20
+	// runLockTTL outlasts a run, so no other instance picks the same run up
+	// while it is still being answered.
+	runLockTTL = 10 * time.Minute
+)
+
+type IService interface {
+	getSchedules(string) ([]Schedule, error)
+	getSchedule(string, int64) (*Schedule, error)
+	createSchedule(*Schedule) error
+	updateSchedule(*validator.Validator, string, int64, ScheduleUpdate) (*Schedule, error)
+	deleteSchedule(string, int64) error
+	checkSchedule(*validator.Validator, *Schedule) error
+	checkScheduleUpdate(*validator.Validator, ScheduleUpdate)
+	RunDue(context.Context) error
+}
+
+type service struct {
+	repo            repo
+	chatService     chat.IService
+	templateService template.IService
+}
+
+func NewService(repo repo, chatService chat.IService, templateService template.IService) IService {
+	return &service{
+		repo:            repo,
+		chatService:     chatService,
+		templateService: templateService,
+	}
+}
+
+func (s *service) getSchedules(userID string) ([]Schedule, error) {
+	return s.repo.getAll(userID)
+}
+
+func (s *service) getSchedule(userID string, scheduleID int64) (*Schedule, error) {
+	return s.repo.get(userID, scheduleID)
+}
+
+// createSchedule saves a schedule that passed checkSchedule, first due at the
+// next time its cron expression matches.
+func (s *service) createSchedule(schedule *Schedule) error {
+	count, err := s.repo.count(schedule.userID)
+	if err != nil {
+		return err
+	}
+	if count >= maxSchedules {
+		return errScheduleLimit
+	}
+
+	if schedule.NextRunAt, err = nextRun(schedule.Cron, schedule.Timezone, time.Now()); err != nil {
+		return err
+	}
+	return s.repo.insert(schedule)
+}
+
+// updateSchedule applies update to the schedule and checks the result as a
+// whole. When that fails the problems are reported on v and nothing is saved.
+// Changing when the schedule runs, or turning it back on, recomputes its next
+// run from now.
+func (s *service) updateSchedule(v *validator.Validator, userID string, scheduleID int64, update ScheduleUpdate) (*Schedule, error) {
+	schedule, err := s.repo.get(userID, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Cron != nil {
+		schedule.Cron = *update.Cron
+	}
+	if update.Timezone != nil {
+		schedule.Timezone = *update.Timezone
+	}
+	if update.ModelType != nil {
+		schedule.ModelType = *update.ModelType
+	}
+	if update.Model != nil {
+		schedule.Model = *update.Model
+	}
+	if update.Prompt != nil {
+		schedule.Prompt = *update.Prompt
+		schedule.TemplateID = nil
+		schedule.Variables = nil
+	}
+	if update.TemplateID != nil {
+		schedule.TemplateID = update.TemplateID
+		schedule.Prompt = ""
+	}
+	if update.Variables != nil {
+		schedule.Variables = update.Variables
+	}
+	if update.Enabled != nil {
+		schedule.Enabled = *update.Enabled
+	}
+
+	if err := s.checkSchedule(v, schedule); err != nil || !v.Valid() {
+		return nil, err
+	}
+
+	if update.Cron != nil || update.Timezone != nil || update.Enabled != nil {
+		if schedule.NextRunAt, err = nextRun(schedule.Cron, schedule.Timezone, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.repo.update(schedule); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+func (s *service) deleteSchedule(userID string, scheduleID int64) error {
+	return s.repo.delete(userID, scheduleID)
+}
+
+// checkSchedule validates a whole schedule. A schedule sends either a prompt or
+// a template, whose variables are checked by rendering it.
+func (s *service) checkSchedule(v *validator.Validator, schedule *Schedule) error {
+	v.Check(schedule.ChatID > 0, "chat_id", "must be provided")
+
+	v.Check(schedule.Timezone != "" && schedule.Timezone != "Local", "timezone", "must be provided")
+	loc, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		v.AddError("timezone", "must be an IANA timezone such as Europe/Berlin")
+	}
+	spec, err := parseCron(schedule.Cron)
+	if err != nil {
+		v.AddError("cron", err.Error())
+	} else if loc != nil && spec.next(time.Now().In(loc)).IsZero() {
+		v.AddError("cron", "never matches a date")
+	}
+
+	v.Check(validator.In(schedule.ModelType, "OpenAI", "Google", "Anthropic"), "model_type", "must be OpenAI, Google or Anthropic")
+	v.Check(validator.In(schedule.Model, append(config.LLMLists[schedule.ModelType], "")...), "model", "must be a model of the model type")
+
+	if schedule.TemplateID == nil {
+		v.Check(schedule.Prompt != "", "prompt", "must be provided, or a template_id instead")
+		v.Check(len(schedule.Prompt) <= 20000, "prompt", "must not be more than 20000 bytes long")
+		v.Check(len(schedule.Variables) == 0, "variables", "must only be provided with a template_id")
+		return nil
+	}
+
+	v.Check(schedule.Prompt == "", "prompt", "must be empty when a template is used")
+	if _, err := s.templateService.Render(v, schedule.userID, *schedule.TemplateID, schedule.Variables); err != nil {
+		if errors.Is(err, utils.ErrRecordNotFound) {
+			v.AddError("template_id", "does not exist")
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *service) checkScheduleUpdate(v *validator.Validator, update ScheduleUpdate) {
+	v.Check(update.Cron != nil || update.Timezone != nil || update.ModelType != nil || update.Model != nil ||
+		update.Prompt != nil || update.TemplateID != nil || update.Variables != nil || update.Enabled != nil,
+		"schedule", "must change at least one field")
+}
+
+// RunDue fires the schedules whose time has come. It runs as a worker on every
+// instance, the Valkey lock and the claim in run keep a schedule from firing
+// twice.
+func (s *service) RunDue(ctx context.Context) error {
+	schedules, err := s.repo.getDue(time.Now(), runBatchSize)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(schedules))
+	for i := range schedules {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.run(&schedules[i])
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// run fires one schedule. The schedule is moved on to its next run before the
+// prompt is sent, so a failing prompt isn't retried every tick and runs missed
+// while the server was down are only made up for once. A schedule whose chat or
+// template is gone is turned off, as none of its runs could succeed.
+func (s *service) run(schedule *Schedule) error {
+	locked, err := s.repo.lock(schedule.ID, schedule.NextRunAt, runLockTTL)
+	if err != nil || !locked {
+		return err
+	}
+
+	next, err := nextRun(schedule.Cron, schedule.Timezone, time.Now())
+	if err != nil {
+		return fmt.Errorf("schedule %d: %w", schedule.ID, err)
+	}
+
+	claimed, err := s.repo.claim(schedule.ID, schedule.NextRunAt, next)
+	if err != nil || !claimed {
+		return err
+	}
+
+	sendErr := s.send(schedule)
+	gone := errors.Is(sendErr, utils.ErrRecordNotFound)
+	if err := s.repo.finishRun(schedule.ID, runFailure(sendErr), gone); err != nil {
+		return err
+	}
+	if sendErr != nil {
+		return fmt.Errorf("schedule %d: %w", schedule.ID, sendErr)
+	}
+	return nil
+}
+
+// send appends the schedule's prompt to its chat, where the reply shows up like
+// any other.
+func (s *service) send(schedule *Schedule) error {
+	text := schedule.Prompt
+	if schedule.TemplateID != nil {
+		v := validator.New()
+
+		var err error
+		if text, err = s.templateService.Render(v, schedule.userID, *schedule.TemplateID, schedule.Variables); err != nil {
+			return err
+		}
+		if !v.Valid() {
+			return errTemplateChanged
+		}
+	}
+
+	_, err := s.chatService.AppendPrompt(context.Background(), schedule.userID, schedule.ChatID, schedule.ModelType, schedule.Model, "", text, "")
+	return err
+}
+
+// runFailure is the last_error shown to the user for a failed run, without any
+// internal detail.
+func runFailure(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, utils.ErrRecordNotFound):
+		return "the chat or template no longer exists, so the schedule was turned off"
+	case errors.Is(err, errTemplateChanged):
+		return errTemplateChanged.Error()
+	case errors.Is(err, policy.ErrBlocked):
+		return err.Error()
+	default:
+		return "the prompt could not be answered"
+	}
+}
@@ -12,6 +12,7 @@ import (
 type repo interface {
 	insert(*domain.User, *Session) error
 	get(string) (string, error)
+	deleteAllForUser(string) error
 }
 
 type Model struct {
@@ -30,7 +31,24 @@ func (m *Model) insert(user *domain.User, session *Session) error {
 	if err != nil {
 		return err
 	}
-	return m.vk.Do(ctx, m.vk.B().Set().Key(string(session.Hash)).Value(string(userStr)).Ex(session.Expiry).Build()).Error()
+	// Each user also gets a set of their session hashes, so every session can be
+	// dropped at once when the account goes away.
+	userKey := userSessionsKey(user.ID)
+	for _, resp := range m.vk.DoMulti(ctx,
+		m.vk.B().Set().Key(string(session.Hash)).Value(string(userStr)).Ex(session.Expiry).Build(),
+		m.vk.B().Sadd().Key(userKey).Member(string(session.Hash)).Build(),
+		m.vk.B().Expire().Key(userKey).Seconds(int64(session.Expiry.Seconds())).Gt().Build(),
+		m.vk.B().Expire().Key(userKey).Seconds(int64(session.Expiry.Seconds())).Nx().Build(),
+	) {
+		if err := resp.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func userSessionsKey(userID string) string {
+	return "user_sessions:" + userID
 }
 
 func (m *Model) get(tokenPlaintext string) (string, error) {
@@ -41,3 +59,16 @@ func (m *Model) get(tokenPlaintext string) (string, error) {
 
 	return m.vk.Do(ctx, m.vk.B().Get().Key(string(tokenHash[:])).Build()).ToString()
 }
+
+func (m *Model) deleteAllForUser(userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	userKey := userSessionsKey(userID)
+	hashes, err := m.vk.Do(ctx, m.vk.B().Smembers().Key(userKey).Build()).AsStrSlice()
+	if err != nil {
+		return err
+	}
+
+	return m.vk.Do(ctx, m.vk.B().Del().Key(append(hashes, userKey)...).Build()).Error()
+}
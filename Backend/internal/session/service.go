@@ -14,6 +14,7 @@ import (
 type IService interface {
 	NewSessionToken(*domain.User, time.Duration) (*Session, error)
 	CheckSession(string) (*domain.User, error)
+	DeleteAllForUser(string) error
 }
 
 type service struct {
@@ -81,3 +82,7 @@ func (s *service) CheckSession(tokenPlaintext string) (*domain.User, error) {
 
 	return user, nil
 }
+
+func (s *service) DeleteAllForUser(userID string) error {
+	return s.repo.deleteAllForUser(userID)
+}
@@ -0,0 +1,126 @@
+package share
+
+import (
+	"Backend/middleware"
+	"Backend/responses"
+	"Backend/userContext"
+	"Backend/utils"
+	"Backend/validator"
+	"errors"
+	"net/http"
+)
+
+type Handler struct {
+	shareService IService
+	er           *responses.ErrorResponses
+	utils        *utils.Utils
+}
+
+func NewHandler(shareService IService, er *responses.ErrorResponses, utils *utils.Utils) *Handler {
+	return &Handler{
+		shareService: shareService,
+		er:           er,
+		utils:        utils,
+	}
+}
+
+func (h *Handler) RegisterRoutes(mux *http.ServeMux, middle *middleware.Middleware) {
+	mux.HandleFunc("POST /v1/chat/{id}/share", middle.RequireAuthenticatedUser(h.createShareHandler))
+	mux.HandleFunc("GET /v1/shares", middle.RequireAuthenticatedUser(h.getSharesHandler))
+	mux.HandleFunc("DELETE /v1/shares/{id}", middle.RequireAuthenticatedUser(h.revokeShareHandler))
+	mux.HandleFunc("GET /v1/share/{token}", h.getSharedChatHandler)
+}
+
+func (h *Handler) createShareHandler(w http.ResponseWriter, r *http.Request) {
+	chatID, err := h.utils.ReadIDParam(r)
+	if err != nil {
+		h.er.NotFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Live bool `json:"live"`
+	}
+	if err := h.utils.ReadJSON(w, r, &input); err != nil {
+		h.er.BadRequestResponse(w, r, err)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	share, err := h.shareService.createShare(user.ID, int32(chatID), input.Live)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"share": share}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) getSharesHandler(w http.ResponseWriter, r *http.Request) {
+	user := userContext.ContextGetUser(r)
+
+	shares, err := h.shareService.getShares(user.ID)
+	if err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"shares": shares}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) revokeShareHandler(w http.ResponseWriter, r *http.Request) {
+	shareID, err := h.utils.ReadIDParam(r)
+	if err != nil {
+		h.er.NotFoundResponse(w, r)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	if err := h.shareService.revokeShare(user.ID, shareID); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "Share Link Revoked!"}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) getSharedChatHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+
+	v := validator.New()
+	if ValidateTokenPlaintext(v, token); !v.Valid() {
+		h.er.NotFoundResponse(w, r)
+		return
+	}
+
+	chat, err := h.shareService.getSharedChat(token)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"chat": chat}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,30 @@
+package share
+
+import "time"
+
+// Share is a read-only link to a chat. Plaintext is only known when the link is
+// created; afterwards the token is looked up by its hash.
+type Share struct {
+	ID        int64     `json:"id"`
+	ChatID    int32     `json:"chat_id"`
+	Title     string    `json:"title"`
+	Live      bool      `json:"live"`
+	Plaintext string    `json:"token,omitempty"`
+	Hash      []byte    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SharedChat is what an unauthenticated viewer of a share link gets back. It
+// deliberately carries nothing about the owner.
+type SharedChat struct {
+	Title    string          `json:"title"`
+	Live     bool            `json:"live"`
+	SharedAt time.Time       `json:"shared_at"`
+	Messages []SharedMessage `json:"messages"`
+}
+
+type SharedMessage struct {
+	Role      string    `json:"role"`
+	Text      string    `json:"text"`
+	Timestamp time.Time `json:"timestamp"`
+}
@@ -0,0 +1,200 @@
+package share
+
+import (
+	"Backend/internal/encryption"
+	"Backend/utils"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+type repo interface {
+	insert(string, int32, *Share, *encryption.UserCipher) error
+	getOwner([]byte) (string, error)
+	get([]byte, *encryption.UserCipher) (*SharedChat, error)
+	getAll(string, *encryption.UserCipher) ([]Share, error)
+	delete(string, int64) error
+}
+
+// snapshotMessage is a message as frozen into a snapshot. Encrypted messages stay
+// encrypted in the snapshot and are only decrypted when the share is viewed.
+type snapshotMessage struct {
+	Role      string    `json:"role"`
+	Text      string    `json:"text"`
+	Encrypted bool      `json:"encrypted"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type Model struct {
+	db *sql.DB
+}
+
+func NewRepo(db *sql.DB) *Model {
+	return &Model{db: db}
+}
+
+// insert creates the share for a chat owned by userID. Unless the share is live,
+// the chat's messages are frozen into the snapshot column in the same statement.
+func (m *Model) insert(userID string, chatID int32, share *Share, c *encryption.UserCipher) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO share (hash, user_id, title_id, live, title, title_encrypted, snapshot)
+		SELECT $1, t.user_id, t.id, $4, t.title, t.encrypted, CASE WHEN $4 THEN NULL ELSE COALESCE((
+			SELECT jsonb_agg(jsonb_build_object('role', type, 'text', text, 'encrypted', encrypted, 'timestamp', timestamp::TIMESTAMPTZ) ORDER BY timestamp, id)
+			FROM message WHERE title_id = t.id
+		), '[]'::JSONB) END
+		FROM title t
+		WHERE t.id = $3 AND t.user_id = $2 AND t.deleted_at IS NULL
+		RETURNING id, title_id, title, title_encrypted, created_at`
+
+	var encrypted bool
+	err := m.db.QueryRowContext(ctx, stmt, share.Hash, userID, chatID, share.Live).
+		Scan(&share.ID, &share.ChatID, &share.Title, &encrypted, &share.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return utils.ErrRecordNotFound
+		}
+		return err
+	}
+
+	share.Title, err = c.Decrypt(share.Title, encrypted)
+	return err
+}
+
+// getOwner returns who shared the chat, whose data key opens its content.
+func (m *Model) getOwner(hash []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var userID string
+	if err := m.db.QueryRowContext(ctx, "SELECT user_id FROM share WHERE hash = $1", hash).Scan(&userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", utils.ErrRecordNotFound
+		}
+		return "", err
+	}
+	return userID, nil
+}
+
+func (m *Model) get(hash []byte, c *encryption.UserCipher) (*SharedChat, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var chat SharedChat
+	var chatID int64
+	var titleEncrypted bool
+	var snapshot []byte
+	err := m.db.QueryRowContext(ctx,
+		"SELECT share.title_id, share.live, share.created_at, CASE WHEN share.live THEN title.title ELSE share.title END, CASE WHEN share.live THEN title.encrypted ELSE share.title_encrypted END, share.snapshot FROM share JOIN title ON title.id = share.title_id WHERE hash = $1 AND title.deleted_at IS NULL",
+		hash).Scan(&chatID, &chat.Live, &chat.SharedAt, &chat.Title, &titleEncrypted, &snapshot)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.ErrRecordNotFound
+		}
+		return nil, err
+	}
+	if chat.Title, err = c.Decrypt(chat.Title, titleEncrypted); err != nil {
+		return nil, err
+	}
+
+	if !chat.Live {
+		var messages []snapshotMessage
+		if err := json.Unmarshal(snapshot, &messages); err != nil {
+			return nil, err
+		}
+
+		chat.Messages = make([]SharedMessage, 0, len(messages))
+		for _, message := range messages {
+			text, err := c.Decrypt(message.Text, message.Encrypted)
+			if err != nil {
+				return nil, err
+			}
+			chat.Messages = append(chat.Messages, SharedMessage{Role: message.Role, Text: text, Timestamp: message.Timestamp})
+		}
+		return &chat, nil
+	}
+
+	rows, err := m.db.QueryContext(ctx, "SELECT type, text, encrypted, timestamp::TIMESTAMPTZ FROM message WHERE title_id = $1 ORDER BY timestamp, id", chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	chat.Messages = []SharedMessage{}
+	for rows.Next() {
+		var message SharedMessage
+		var encrypted bool
+		if err := rows.Scan(&message.Role, &message.Text, &encrypted, &message.Timestamp); err != nil {
+			return nil, err
+		}
+		if message.Text, err = c.Decrypt(message.Text, encrypted); err != nil {
+			return nil, err
+		}
+		chat.Messages = append(chat.Messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &chat, nil
+}
+
+func (m *Model) getAll(userID string, c *encryption.UserCipher) ([]Share, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, "SELECT id, title_id, title, title_encrypted, live, created_at FROM share WHERE user_id = $1 ORDER BY created_at DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	shares := []Share{}
+	for rows.Next() {
+		var share Share
+		var encrypted bool
+		if err := rows.Scan(&share.ID, &share.ChatID, &share.Title, &encrypted, &share.Live, &share.CreatedAt); err != nil {
+			return nil, err
+		}
+		if share.Title, err = c.Decrypt(share.Title, encrypted); err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return shares, nil
+}
+
+func (m *Model) delete(userID string, shareID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, "DELETE FROM share WHERE id = $1 AND user_id = $2", shareID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return utils.ErrRecordNotFound
+	}
+	return nil
+}
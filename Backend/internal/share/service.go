@@ -0,0 +1,104 @@
+package share
+
+import (
+	"Backend/internal/encryption"
+	"Backend/validator"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+)
+
+type IService interface {
+	createShare(string, int32, bool) (*Share, error)
+	getSharedChat(string) (*SharedChat, error)
+	getShares(string) ([]Share, error)
+	revokeShare(string, int64) error
+}
+
+type service struct {
+	repo              repo
+	encryptionService encryption.IService
+}
+
+func NewService(repo repo, encryptionService encryption.IService) IService {
+	return &service{
+		repo:              repo,
+		encryptionService: encryptionService,
+	}
+}
+
+func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
+	v.Check(tokenPlaintext != "", "token", "must be provided")
+	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+}
+
+func hashToken(tokenPlaintext string) []byte {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	return hash[:]
+}
+
+func generateToken(live bool) (*Share, error) {
+	share := &Share{
+		Live: live,
+	}
+
+	randomBytes := make([]byte, 16)
+
+	if _, err := rand.Read(randomBytes); err != nil {
+		return nil, err
+	}
+
+	share.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	share.Hash = hashToken(share.Plaintext)
+
+	return share, nil
+}
+
+func (s *service) createShare(userID string, chatID int32, live bool) (*Share, error) {
+	share, err := generateToken(live)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := s.encryptionService.ForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.insert(userID, chatID, share, c); err != nil {
+		return nil, err
+	}
+
+	return share, nil
+}
+
+// getSharedChat decrypts whatever the owner had encrypted, since a share is the
+// owner's choice to show the chat to anyone holding the link.
+func (s *service) getSharedChat(tokenPlaintext string) (*SharedChat, error) {
+	hash := hashToken(tokenPlaintext)
+
+	ownerID, err := s.repo.getOwner(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := s.encryptionService.ForUser(ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repo.get(hash, c)
+}
+
+func (s *service) getShares(userID string) ([]Share, error) {
+	c, err := s.encryptionService.ForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repo.getAll(userID, c)
+}
+
+func (s *service) revokeShare(userID string, shareID int64) error {
+	return s.repo.delete(userID, shareID)
+}
@@ -0,0 +1,168 @@
+package template
+
+import (
+	"Backend/middleware"
+	"Backend/responses"
+	"Backend/userContext"
+	"Backend/utils"
+	"Backend/validator"
+	"errors"
+	"net/http"
+)
+
+type Handler struct {
+	service IService
+	er      *responses.ErrorResponses
+	utils   *utils.Utils
+}
+
+func NewHandler(service IService, er *responses.ErrorResponses, utils *utils.Utils) *Handler {
+	return &Handler{
+		service: service,
+		er:      er,
+		utils:   utils,
+	}
+}
+
+func (h *Handler) RegisterRoutes(mux *http.ServeMux, middle *middleware.Middleware) {
+	mux.HandleFunc("GET /v1/templates", middle.RequireAuthenticatedUser(h.getTemplatesHandler))
+	mux.HandleFunc("POST /v1/templates", middle.RequireAuthenticatedUser(h.createTemplateHandler))
+	mux.HandleFunc("GET /v1/templates/{id}", middle.RequireAuthenticatedUser(h.getTemplateHandler))
+	mux.HandleFunc("PATCH /v1/templates/{id}", middle.RequireAuthenticatedUser(h.updateTemplateHandler))
+	mux.HandleFunc("DELETE /v1/templates/{id}", middle.RequireAuthenticatedUser(h.deleteTemplateHandler))
+}
+
+func (h *Handler) getTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	user := userContext.ContextGetUser(r)
+
+	templates, err := h.service.getTemplates(user.ID)
+	if err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"templates": templates}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) getTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	templateID, err := h.utils.ReadIDParam(r)
+	if err != nil {
+		h.er.NotFoundResponse(w, r)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	template, err := h.service.getTemplate(user.ID, templateID)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"template": template}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) createTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+		Body string `json:"body"`
+	}
+	if err := h.utils.ReadJSON(w, r, &input); err != nil {
+		h.er.BadRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	h.service.checkName(v, input.Name)
+	if h.service.checkBody(v, input.Body); !v.Valid() {
+		h.er.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	template, err := h.service.createTemplate(user.ID, input.Name, input.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrDuplicateEntry):
+			v.AddError("name", "a template with this name already exists")
+			h.er.FailedValidationResponse(w, r, v.Errors)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"template": template}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) updateTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	templateID, err := h.utils.ReadIDParam(r)
+	if err != nil {
+		h.er.NotFoundResponse(w, r)
+		return
+	}
+
+	var input TemplateUpdate
+	if err := h.utils.ReadJSON(w, r, &input); err != nil {
+		h.er.BadRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if h.service.checkTemplateUpdate(v, input); !v.Valid() {
+		h.er.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	template, err := h.service.updateTemplate(user.ID, templateID, input)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		case errors.Is(err, utils.ErrDuplicateEntry):
+			v.AddError("name", "a template with this name already exists")
+			h.er.FailedValidationResponse(w, r, v.Errors)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"template": template}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) deleteTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	templateID, err := h.utils.ReadIDParam(r)
+	if err != nil {
+		h.er.NotFoundResponse(w, r)
+		return
+	}
+
+	user := userContext.ContextGetUser(r)
+	if err := h.service.deleteTemplate(user.ID, templateID); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "Template Deletion Successful!"}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
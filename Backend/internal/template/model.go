@@ -0,0 +1,44 @@
+package template
+
+import (
+	"regexp"
+	"time"
+)
+
+// placeholder matches a {{variable}} in a template body, spaces inside the
+// braces allowed.
+var placeholder = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// Template is a reusable prompt. Variables are the distinct placeholder names
+// in Body, in order of first appearance.
+type Template struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Body      string    `json:"body"`
+	Variables []string  `json:"variables"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type TemplateUpdate struct {
+	Name *string `json:"name"`
+	Body *string `json:"body"`
+}
+
+func variables(body string) []string {
+	names := []string{}
+	seen := make(map[string]bool)
+	for _, match := range placeholder.FindAllStringSubmatch(body, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+func render(body string, values map[string]string) string {
+	return placeholder.ReplaceAllStringFunc(body, func(match string) string {
+		return values[placeholder.FindStringSubmatch(match)[1]]
+	})
+}
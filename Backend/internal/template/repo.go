@@ -0,0 +1,135 @@
+package template
+
+import (
+	"Backend/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"time"
+)
+
+type repo interface {
+	getAll(string) ([]Template, error)
+	get(string, int64) (*Template, error)
+	insert(string, string, string) (*Template, error)
+	update(string, int64, TemplateUpdate) (*Template, error)
+	delete(string, int64) error
+}
+
+type Model struct {
+	db *sql.DB
+}
+
+func NewRepo(db *sql.DB) *Model {
+	return &Model{db: db}
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func (m *Model) getAll(userID string) ([]Template, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, "SELECT id, name, body, created_at, updated_at FROM prompt_template WHERE user_id = $1 ORDER BY name", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	templates := []Template{}
+	for rows.Next() {
+		var template Template
+		if err := rows.Scan(&template.ID, &template.Name, &template.Body, &template.CreatedAt, &template.UpdatedAt); err != nil {
+			return nil, err
+		}
+		template.Variables = variables(template.Body)
+		templates = append(templates, template)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+func (m *Model) get(userID string, templateID int64) (*Template, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	template := &Template{ID: templateID}
+	err := m.db.QueryRowContext(ctx, "SELECT name, body, created_at, updated_at FROM prompt_template WHERE id = $1 AND user_id = $2", templateID, userID).
+		Scan(&template.Name, &template.Body, &template.CreatedAt, &template.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.ErrRecordNotFound
+		}
+		return nil, err
+	}
+	template.Variables = variables(template.Body)
+	return template, nil
+}
+
+func (m *Model) insert(userID string, name string, body string) (*Template, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	template := &Template{Name: name, Body: body, Variables: variables(body)}
+	err := m.db.QueryRowContext(ctx, "INSERT INTO prompt_template (user_id, name, body) VALUES ($1, $2, $3) RETURNING id, created_at, updated_at", userID, name, body).
+		Scan(&template.ID, &template.CreatedAt, &template.UpdatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, utils.ErrDuplicateEntry
+		}
+		return nil, err
+	}
+	return template, nil
+}
+
+func (m *Model) update(userID string, templateID int64, update TemplateUpdate) (*Template, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	template := &Template{ID: templateID}
+	err := m.db.QueryRowContext(ctx,
+		"UPDATE prompt_template SET name = COALESCE($3, name), body = COALESCE($4, body), updated_at = NOW() WHERE id = $1 AND user_id = $2 RETURNING name, body, created_at, updated_at",
+		templateID, userID, update.Name, update.Body).Scan(&template.Name, &template.Body, &template.CreatedAt, &template.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, utils.ErrRecordNotFound
+		case isUniqueViolation(err):
+			return nil, utils.ErrDuplicateEntry
+		default:
+			return nil, err
+		}
+	}
+	template.Variables = variables(template.Body)
+	return template, nil
+}
+
+func (m *Model) delete(userID string, templateID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, "DELETE FROM prompt_template WHERE id = $1 AND user_id = $2", templateID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return utils.ErrRecordNotFound
+	}
+	return nil
+}
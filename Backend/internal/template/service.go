@@ -0,0 +1,101 @@
+package template
+
+import (
+	"Backend/validator"
+	"fmt"
+	"strings"
+)
+
+type IService interface {
+	getTemplates(string) ([]Template, error)
+	getTemplate(string, int64) (*Template, error)
+	createTemplate(string, string, string) (*Template, error)
+	updateTemplate(string, int64, TemplateUpdate) (*Template, error)
+	deleteTemplate(string, int64) error
+	checkName(*validator.Validator, string)
+	checkBody(*validator.Validator, string)
+	checkTemplateUpdate(*validator.Validator, TemplateUpdate)
+	Render(*validator.Validator, string, int64, map[string]string) (string, error)
+}
+
+type service struct {
+	repo repo
+}
+
+func NewService(repo repo) IService {
+	return &service{
+		repo: repo,
+	}
+}
+
+func (s *service) getTemplates(userID string) ([]Template, error) {
+	return s.repo.getAll(userID)
+}
+
+func (s *service) getTemplate(userID string, templateID int64) (*Template, error) {
+	return s.repo.get(userID, templateID)
+}
+
+func (s *service) createTemplate(userID string, name string, body string) (*Template, error) {
+	return s.repo.insert(userID, name, body)
+}
+
+func (s *service) updateTemplate(userID string, templateID int64, update TemplateUpdate) (*Template, error) {
+	return s.repo.update(userID, templateID, update)
+}
+
+func (s *service) deleteTemplate(userID string, templateID int64) error {
+	return s.repo.delete(userID, templateID)
+}
+
+func (s *service) checkName(v *validator.Validator, name string) {
+	v.Check(strings.TrimSpace(name) != "", "name", "must be provided")
+	v.Check(len(name) <= 100, "name", "must not be more than 100 bytes long")
+}
+
+func (s *service) checkBody(v *validator.Validator, body string) {
+	v.Check(strings.TrimSpace(body) != "", "body", "must be provided")
+	v.Check(len(body) <= 20000, "body", "must not be more than 20000 bytes long")
+	v.Check(len(variables(body)) <= 50, "body", "must not contain more than 50 variables")
+}
+
+func (s *service) checkTemplateUpdate(v *validator.Validator, update TemplateUpdate) {
+	v.Check(update.Name != nil || update.Body != nil, "template", "must change at least one field")
+	if update.Name != nil {
+		s.checkName(v, *update.Name)
+	}
+	if update.Body != nil {
+		s.checkBody(v, *update.Body)
+	}
+}
+
+// Render fills the template's placeholders with values. Every variable needs a
+// value and every value a variable; anything else is reported on v, keyed by
+// "variables.<name>", and the returned prompt is empty. The prompt is held to
+// the length of one typed in, which is reported under "variables".
+func (s *service) Render(v *validator.Validator, userID string, templateID int64, values map[string]string) (string, error) {
+	template, err := s.repo.get(userID, templateID)
+	if err != nil {
+		return "", err
+	}
+
+	used := make(map[string]bool, len(template.Variables))
+	for _, name := range template.Variables {
+		used[name] = true
+		value, ok := values[name]
+		v.Check(ok, fmt.Sprintf("variables.%s", name), "must be provided")
+		v.Check(len(value) <= 20000, fmt.Sprintf("variables.%s", name), "must not be more than 20000 bytes long")
+	}
+	for name := range values {
+		v.Check(used[name], fmt.Sprintf("variables.%s", name), "is not used by the template")
+	}
+	if !v.Valid() {
+		return "", nil
+	}
+
+	text := render(template.Body, values)
+	if v.Check(len(text) <= 20000, "variables", "must not make the prompt more than 20000 bytes long"); !v.Valid() {
+		return "", nil
+	}
+	return text, nil
+}
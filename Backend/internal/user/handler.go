@@ -2,11 +2,13 @@ package user
 
 import (
 	"Backend/domain"
+	"Backend/internal/chat"
 	"Backend/internal/session"
 	"Backend/middleware"
 	"Backend/responses"
 	"Backend/userContext"
 	"Backend/utils"
+	"Backend/validator"
 	"errors"
 	"fmt"
 	"io"
@@ -18,14 +20,16 @@ import (
 type Handler struct {
 	userService    IService
 	sessionService session.IService
+	chatService    chat.IService
 	er             *responses.ErrorResponses
 	utils          *utils.Utils
 }
 
-func NewHandler(userService IService, sessionService session.IService, er *responses.ErrorResponses, utils *utils.Utils) *Handler {
+func NewHandler(userService IService, sessionService session.IService, chatService chat.IService, er *responses.ErrorResponses, utils *utils.Utils) *Handler {
 	return &Handler{
 		userService:    userService,
 		sessionService: sessionService,
+		chatService:    chatService,
 		er:             er,
 		utils:          utils,
 	}
@@ -36,10 +40,22 @@ func (h *Handler) RegisterRoutes(mux *http.ServeMux, middle *middleware.Middlewa
 	mux.HandleFunc("GET /v1/auth/google/callback", middle.RequireNonAuthenticatedUser(h.handleGoogleCallback))
 	mux.HandleFunc("GET /user", middle.RequireAuthenticatedUser(h.handlerUser))
 	mux.HandleFunc("DELETE /v1/auth/google/revoke", middle.RequireAuthenticatedUser(h.handleGoogleRevoke))
+	mux.HandleFunc("GET /v1/account/deletion", middle.RequireAuthenticatedUser(h.getDeletionHandler))
+	mux.HandleFunc("POST /v1/account/deletion", middle.RequireAuthenticatedUser(h.scheduleDeletionHandler))
+	mux.HandleFunc("DELETE /v1/account/deletion", middle.RequireAuthenticatedUser(h.cancelDeletionHandler))
 }
 
 func (h *Handler) handleGoogleLogin(w http.ResponseWriter, r *http.Request) {
-	authURL, err := h.userService.getAuthURL()
+	guestToken := r.Header.Get("Guest-Token")
+	if guestToken != "" {
+		v := validator.New()
+		if chat.ValidateGuestToken(v, guestToken); !v.Valid() {
+			h.er.FailedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	authURL, err := h.userService.getAuthURL(guestToken)
 	if err != nil {
 		h.er.ServerErrorResponse(w, r, err)
 		return
@@ -52,7 +68,7 @@ func (h *Handler) handleGoogleLogin(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) handleGoogleCallback(w http.ResponseWriter, r *http.Request) {
 	receivedStateToken := r.URL.Query().Get("state")
-	validStateToken, err := h.userService.checkStateToken(receivedStateToken)
+	guestToken, validStateToken, err := h.userService.checkStateToken(receivedStateToken)
 	if err != nil {
 		h.er.ServerErrorResponse(w, r, err)
 		return
@@ -111,6 +127,13 @@ func (h *Handler) handleGoogleCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if guestToken != "" {
+		// Losing the guest chats shouldn't stop the login, so this is only logged.
+		if err := h.chatService.MigrateGuestChats(guestToken, user.ID); err != nil {
+			h.er.LogError(r, err)
+		}
+	}
+
 	sessionToken, err := h.sessionService.NewSessionToken(user, 30*24*time.Hour)
 	if err != nil {
 		h.er.ServerErrorResponse(w, r, err)
@@ -132,6 +155,8 @@ func (h *Handler) handlerUser(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleGoogleRevoke revokes the Google grant and deletes the account right
+// away. POST /v1/account/deletion is the route with a grace period.
 func (h *Handler) handleGoogleRevoke(w http.ResponseWriter, r *http.Request) {
 	user := userContext.ContextGetUser(r)
 
@@ -155,6 +180,11 @@ func (h *Handler) handleGoogleRevoke(w http.ResponseWriter, r *http.Request) {
 	}(response.Body)
 
 	if response.StatusCode == http.StatusOK {
+		if err := h.sessionService.DeleteAllForUser(user.ID); err != nil {
+			h.er.ServerErrorResponse(w, r, err)
+			return
+		}
+
 		if err := h.userService.deleteUser(user.ID); err != nil {
 			switch {
 			case errors.Is(err, utils.ErrRecordNotFound):
@@ -173,3 +203,63 @@ func (h *Handler) handleGoogleRevoke(w http.ResponseWriter, r *http.Request) {
 
 	h.er.BadRequestResponse(w, r, errors.New("account deletion failed"))
 }
+
+// scheduleDeletionHandler schedules the account for deletion. The Google grant
+// is revoked and the data purged by a background worker once the grace period
+// is over, and until then the deletion can be cancelled.
+func (h *Handler) scheduleDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	user := userContext.ContextGetUser(r)
+
+	scheduledAt, err := h.userService.scheduleDeletion(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := utils.Envelope{"message": "Account Deletion Scheduled", "deletion_scheduled_at": scheduledAt}
+	if err := h.utils.WriteJSON(w, http.StatusAccepted, env, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) getDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	user := userContext.ContextGetUser(r)
+
+	scheduledAt, err := h.userService.getDeletionSchedule(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"deletion_scheduled_at": scheduledAt}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) cancelDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	user := userContext.ContextGetUser(r)
+
+	if err := h.userService.cancelDeletion(user.ID); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrRecordNotFound):
+			h.er.NotFoundResponse(w, r)
+		default:
+			h.er.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := h.utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "Account Deletion Cancelled"}, nil); err != nil {
+		h.er.ServerErrorResponse(w, r, err)
+	}
+}
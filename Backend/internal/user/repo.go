@@ -5,16 +5,31 @@ import (
 	"Backend/utils"
 	"context"
 	"database/sql"
+	"errors"
 	"github.com/valkey-io/valkey-go"
 	"time"
 )
 
+// storedToken is a refresh token as it sits in the database. Tokens saved before
+// encryption was introduced are still plaintext until they are rotated.
+type storedToken struct {
+	userID string
+	token  string
+	sealed bool
+}
+
 type repo interface {
-	upsert(*domain.User) error
+	upsert(*domain.User, bool) error
 	delete(string) error
-	getRefreshToken(string) (string, error)
-	getStateToken(string) (bool, error)
-	setStateToken(string) error
+	getRefreshToken(string) (*storedToken, error)
+	getUnrotatedRefreshTokens(string, int) ([]*storedToken, error)
+	updateRefreshToken(*storedToken, string) error
+	getStateToken(string) (string, bool, error)
+	setStateToken(string, string) error
+	scheduleDeletion(string, time.Time) (time.Time, error)
+	cancelDeletion(string) error
+	getDeletionSchedule(string) (*time.Time, error)
+	getDueDeletions(int) ([]*storedToken, error)
 }
 
 type Model struct {
@@ -29,13 +44,14 @@ func NewRepo(db *sql.DB, vk valkey.Client) *Model {
 	}
 }
 
-func (m *Model) upsert(user *domain.User) error {
+// upsert saves the user along with whether their refresh token is sealed.
+func (m *Model) upsert(user *domain.User, sealed bool) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	_, err := m.db.ExecContext(ctx,
-		"INSERT INTO users (id, name, email, picture, refresh_token) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (id) DO UPDATE SET name = excluded.name, picture = excluded.picture, refresh_token = excluded.refresh_token WHERE excluded.refresh_token <> ''",
-		user.ID, user.Name, user.Email, user.Picture, user.RefreshToken)
+		"INSERT INTO users (id, name, email, picture, refresh_token, refresh_token_sealed) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (id) DO UPDATE SET name = excluded.name, picture = excluded.picture, refresh_token = excluded.refresh_token, refresh_token_sealed = excluded.refresh_token_sealed WHERE excluded.refresh_token <> ''",
+		user.ID, user.Name, user.Email, user.Picture, user.RefreshToken, sealed)
 	return err
 }
 
@@ -58,26 +74,174 @@ func (m *Model) delete(id string) error {
 	return nil
 }
 
-func (m *Model) getRefreshToken(userID string) (string, error) {
+func (m *Model) getRefreshToken(userID string) (*storedToken, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var token string
-	if err := m.db.QueryRowContext(ctx, "SELECT refresh_token FROM users WHERE id = $1", userID).Scan(&token); err != nil {
-		return "", err
+	token := &storedToken{userID: userID}
+	if err := m.db.QueryRowContext(ctx, "SELECT refresh_token, refresh_token_sealed FROM users WHERE id = $1", userID).Scan(&token.token, &token.sealed); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.ErrRecordNotFound
+		}
+		return nil, err
 	}
 	return token, nil
 }
 
-func (m *Model) getStateToken(stateToken string) (bool, error) {
+// getUnrotatedRefreshTokens returns refresh tokens that are still plaintext or
+// were sealed with a key other than the active one.
+func (m *Model) getUnrotatedRefreshTokens(activeKeyID string, limit int) ([]*storedToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT id, refresh_token, refresh_token_sealed
+		FROM users
+		WHERE refresh_token <> ''
+		  AND (NOT refresh_token_sealed OR split_part(refresh_token, '.', 1) <> $1)
+		LIMIT $2`
+
+	rows, err := m.db.QueryContext(ctx, query, activeKeyID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	var tokens []*storedToken
+	for rows.Next() {
+		var token storedToken
+		if err := rows.Scan(&token.userID, &token.token, &token.sealed); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// updateRefreshToken replaces a refresh token with its sealed form, unless the
+// user logged in and got a new one since it was read.
+func (m *Model) updateRefreshToken(old *storedToken, sealed string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx,
+		"UPDATE users SET refresh_token = $1, refresh_token_sealed = TRUE WHERE id = $2 AND refresh_token = $3",
+		sealed, old.userID, old.token)
+	return err
+}
+
+// getStateToken consumes a state token, returning the guest token that started
+// the login, if any, and whether the state token was valid.
+func (m *Model) getStateToken(stateToken string) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	guestToken, err := m.vk.Do(ctx, m.vk.B().Getdel().Key(stateToken).Build()).ToString()
+	if err != nil {
+		if valkey.IsValkeyNil(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return guestToken, true, nil
+}
+
+func (m *Model) setStateToken(stateToken string, guestToken string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return m.vk.Do(ctx, m.vk.B().Setex().Key(stateToken).Seconds(300).Value(guestToken).Build()).Error()
+}
+
+// scheduleDeletion marks the user for deletion at the given time and returns when
+// the deletion will happen. Scheduling again keeps the original date.
+func (m *Model) scheduleDeletion(userID string, at time.Time) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var scheduledAt time.Time
+	err := m.db.QueryRowContext(ctx,
+		"UPDATE users SET deletion_scheduled_at = COALESCE(deletion_scheduled_at, $1) WHERE id = $2 RETURNING deletion_scheduled_at",
+		at, userID).Scan(&scheduledAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, utils.ErrRecordNotFound
+		}
+		return time.Time{}, err
+	}
+	return scheduledAt, nil
+}
+
+func (m *Model) cancelDeletion(userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, "UPDATE users SET deletion_scheduled_at = NULL WHERE id = $1 AND deletion_scheduled_at IS NOT NULL", userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return utils.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m *Model) getDeletionSchedule(userID string) (*time.Time, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return m.vk.Do(ctx, m.vk.B().Exists().Key(stateToken).Build()).AsBool()
+	var at sql.NullTime
+	if err := m.db.QueryRowContext(ctx, "SELECT deletion_scheduled_at FROM users WHERE id = $1", userID).Scan(&at); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.ErrRecordNotFound
+		}
+		return nil, err
+	}
+	if !at.Valid {
+		return nil, nil
+	}
+	return &at.Time, nil
 }
 
-func (m *Model) setStateToken(stateToken string) error {
+// getDueDeletions returns up to limit users whose grace period is over, with the
+// refresh token needed to revoke their Google grant.
+func (m *Model) getDueDeletions(limit int) ([]*storedToken, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	return m.vk.Do(ctx, m.vk.B().Setex().Key(stateToken).Seconds(300).Value("").Build()).Error()
+
+	rows, err := m.db.QueryContext(ctx, "SELECT id, refresh_token, refresh_token_sealed FROM users WHERE deletion_scheduled_at <= NOW() ORDER BY deletion_scheduled_at LIMIT $1", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	var users []*storedToken
+	for rows.Next() {
+		var user storedToken
+		if err := rows.Scan(&user.userID, &user.token, &user.sealed); err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
 }
@@ -1,43 +1,96 @@
 package user
 
 import (
+	"Backend/config"
 	"Backend/domain"
+	"Backend/internal/session"
+	"Backend/vault"
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"golang.org/x/oauth2"
+	"io"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 )
 
 type IService interface {
 	loginUser(*domain.User) error
 	getRefreshToken(string) (*oauth2.Token, error)
 	deleteUser(string) error
-	checkStateToken(string) (bool, error)
+	checkStateToken(string) (string, bool, error)
 
-	getAuthURL() (string, error)
+	getAuthURL(string) (string, error)
 	getExchangeToken(context.Context, string) (*oauth2.Token, error)
 	getOAuthClient(context.Context, *oauth2.Token) *http.Client
+
+	scheduleDeletion(string) (time.Time, error)
+	cancelDeletion(string) error
+	getDeletionSchedule(string) (*time.Time, error)
+	PurgeDeletedAccounts(context.Context) error
+	RotateRefreshTokens(context.Context) error
 }
 
+// rotateBatchSize is how many refresh tokens RotateRefreshTokens seals per query.
+const rotateBatchSize = 100
+
 type service struct {
-	userRepo repo
-	oauth    *oauth2.Config
+	userRepo       repo
+	sessionService session.IService
+	oauth          *oauth2.Config
+	keyring        *vault.Keyring
 }
 
-func NewService(userRepo repo, oauth *oauth2.Config) IService {
+func NewService(userRepo repo, sessionService session.IService, oauth *oauth2.Config, keyring *vault.Keyring) IService {
 	return &service{
-		userRepo: userRepo,
-		oauth:    oauth,
+		userRepo:       userRepo,
+		sessionService: sessionService,
+		oauth:          oauth,
+		keyring:        keyring,
 	}
 }
 
+// loginUser saves the user with their refresh token sealed. Google only sends a
+// refresh token on the first consent, so an empty one keeps the stored token.
+// Without an encryption key the token is stored as plaintext, to be sealed by
+// RotateRefreshTokens once a key is configured.
 func (s *service) loginUser(user *domain.User) error {
-	return s.userRepo.upsert(user)
+	if user.RefreshToken == "" || s.keyring.ActiveKeyID() == "" {
+		return s.userRepo.upsert(user, false)
+	}
+
+	sealed := *user
+	var err error
+	if sealed.RefreshToken, err = s.keyring.Seal([]byte(user.RefreshToken)); err != nil {
+		return err
+	}
+	return s.userRepo.upsert(&sealed, true)
+}
+
+// openRefreshToken returns the plaintext of a stored refresh token.
+func (s *service) openRefreshToken(stored *storedToken) (string, error) {
+	if !stored.sealed || stored.token == "" {
+		return stored.token, nil
+	}
+
+	token, err := s.keyring.Open(stored.token)
+	if err != nil {
+		return "", fmt.Errorf("open refresh token of user %s: %w", stored.userID, err)
+	}
+	return string(token), nil
 }
 
 func (s *service) getRefreshToken(userID string) (*oauth2.Token, error) {
-	refreshToken, err := s.userRepo.getRefreshToken(userID)
+	stored, err := s.userRepo.getRefreshToken(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := s.openRefreshToken(stored)
 	if err != nil {
 		return nil, err
 	}
@@ -53,13 +106,10 @@ func (s *service) deleteUser(userID string) error {
 	return s.userRepo.delete(userID)
 }
 
-func (s *service) checkStateToken(receivedStateToken string) (bool, error) {
-	stateToken, err := s.userRepo.getStateToken(receivedStateToken)
-	if err != nil {
-		return false, err
-	}
-
-	return stateToken, nil
+// checkStateToken reports whether the state token is valid and returns the guest
+// token it was issued for. A state token can only be checked once.
+func (s *service) checkStateToken(receivedStateToken string) (string, bool, error) {
+	return s.userRepo.getStateToken(receivedStateToken)
 }
 
 func generateStateToken() (string, error) {
@@ -70,13 +120,15 @@ func generateStateToken() (string, error) {
 	return base64.URLEncoding.EncodeToString(b), nil
 }
 
-func (s *service) getAuthURL() (string, error) {
+// getAuthURL starts a Google login. The guest token of an anonymous user is kept
+// with the state token so their chats can be moved over once they sign in.
+func (s *service) getAuthURL(guestToken string) (string, error) {
 	stateToken, err := generateStateToken()
 	if err != nil {
 		return "", err
 	}
 
-	if err := s.userRepo.setStateToken(stateToken); err != nil {
+	if err := s.userRepo.setStateToken(stateToken, guestToken); err != nil {
 		return "", err
 	}
 	return s.oauth.AuthCodeURL(stateToken, oauth2.AccessTypeOffline, oauth2.SetAuthURLParam("include_granted_scopes", "true")), nil
@@ -89,3 +141,123 @@ func (s *service) getExchangeToken(ctx context.Context, code string) (*oauth2.To
 func (s *service) getOAuthClient(ctx context.Context, token *oauth2.Token) *http.Client {
 	return s.oauth.Client(ctx, token)
 }
+
+func (s *service) scheduleDeletion(userID string) (time.Time, error) {
+	return s.userRepo.scheduleDeletion(userID, time.Now().Add(config.AccountDeletionGracePeriod()))
+}
+
+func (s *service) cancelDeletion(userID string) error {
+	return s.userRepo.cancelDeletion(userID)
+}
+
+func (s *service) getDeletionSchedule(userID string) (*time.Time, error) {
+	return s.userRepo.getDeletionSchedule(userID)
+}
+
+// PurgeDeletedAccounts deletes the accounts whose deletion grace period is over:
+// the Google grant is revoked first, then the sessions and finally the user row,
+// which cascades to everything they own. An account whose revocation fails is
+// left for the next run.
+func (s *service) PurgeDeletedAccounts(ctx context.Context) error {
+	users, err := s.userRepo.getDueDeletions(50)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, user := range users {
+		refreshToken, err := s.openRefreshToken(user)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := s.revokeGrant(ctx, refreshToken); err != nil {
+			errs = append(errs, fmt.Errorf("revoke grant of user %s: %w", user.userID, err))
+			continue
+		}
+		if err := s.sessionService.DeleteAllForUser(user.userID); err != nil {
+			errs = append(errs, fmt.Errorf("delete sessions of user %s: %w", user.userID, err))
+			continue
+		}
+		if err := s.deleteUser(user.userID); err != nil {
+			errs = append(errs, fmt.Errorf("delete user %s: %w", user.userID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RotateRefreshTokens seals the refresh tokens still stored as plaintext and
+// rewraps the ones sealed with an old key, so that key can be retired. Without
+// an encryption key there is nothing to seal with, and nothing is done.
+func (s *service) RotateRefreshTokens(ctx context.Context) error {
+	activeKeyID := s.keyring.ActiveKeyID()
+	if activeKeyID == "" {
+		return nil
+	}
+
+	var errs []error
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		tokens, err := s.userRepo.getUnrotatedRefreshTokens(activeKeyID, rotateBatchSize)
+		if err != nil {
+			return err
+		}
+
+		rotated := 0
+		for _, token := range tokens {
+			var sealed string
+			if token.sealed {
+				sealed, _, err = s.keyring.Rewrap(token.token)
+			} else {
+				sealed, err = s.keyring.Seal([]byte(token.token))
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("rotate refresh token of user %s: %w", token.userID, err))
+				continue
+			}
+			if err := s.userRepo.updateRefreshToken(token, sealed); err != nil {
+				return err
+			}
+			rotated++
+		}
+
+		if len(tokens) < rotateBatchSize || rotated == 0 {
+			return errors.Join(errs...)
+		}
+	}
+}
+
+// revokeGrant revokes the user's Google OAuth grant. A token Google no longer
+// knows about is treated as already revoked.
+func (s *service) revokeGrant(ctx context.Context, refreshToken string) error {
+	if refreshToken == "" {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://oauth2.googleapis.com/revoke",
+		strings.NewReader(url.Values{"token": {refreshToken}}.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		if err := Body.Close(); err != nil {
+			return
+		}
+	}(response.Body)
+
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusBadRequest:
+		return nil
+	default:
+		return fmt.Errorf("unexpected status from google revoke endpoint: %s", response.Status)
+	}
+}
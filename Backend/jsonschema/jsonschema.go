@@ -0,0 +1,564 @@
+// Package jsonschema validates JSON against a JSON Schema. It covers what models
+// are asked to produce: types, properties, items, enums, combinators, bounds,
+// patterns and local $refs. Annotations and keywords it doesn't know, such as
+// format, are accepted and ignored.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxErrors is how many violations Validate reports at most.
+const maxErrors = 10
+
+// maxDepth bounds how deep a schema may nest, $refs followed, while validating a
+// value. A schema that refers to itself without going deeper into the value
+// would recurse forever otherwise.
+const maxDepth = 64
+
+// maxSteps bounds how many times a value is checked against a (sub)schema in
+// one validation. Combinators try every branch, so a schema that refers to
+// itself through them would otherwise take time exponential in maxDepth.
+const maxSteps = 100_000
+
+var (
+	ErrInvalidJSON = errors.New("not valid JSON")
+	ErrTooComplex  = errors.New("the schema is too complex to validate against")
+)
+
+var types = []string{"object", "array", "string", "number", "integer", "boolean", "null"}
+
+// Schema is a compiled JSON Schema.
+type Schema struct {
+	raw      json.RawMessage
+	root     any
+	patterns map[string]*regexp.Regexp
+}
+
+// Compile parses a JSON Schema and checks that the keywords it validates with
+// are well-formed. The schema must describe an object, as providers require for
+// structured output.
+func Compile(raw []byte) (*Schema, error) {
+	root, err := decode(raw)
+	if err != nil {
+		return nil, err
+	}
+	object, ok := root.(map[string]any)
+	if !ok || object["type"] != "object" {
+		return nil, errors.New(`must be an object schema with "type": "object"`)
+	}
+
+	s := &Schema{raw: json.RawMessage(raw), root: root, patterns: make(map[string]*regexp.Regexp)}
+	if err := s.check(root, "#"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Raw returns the schema as it was compiled.
+func (s *Schema) Raw() json.RawMessage {
+	return s.raw
+}
+
+// Validate parses data and returns what in it doesn't match the schema, each
+// violation prefixed with the JSON pointer of where it is. ErrInvalidJSON is
+// returned for data that doesn't parse, ErrTooComplex when validating would take
+// more than maxSteps.
+func (s *Schema) Validate(data []byte) ([]string, error) {
+	value, err := decode(data)
+	if err != nil {
+		return nil, ErrInvalidJSON
+	}
+
+	steps := maxSteps
+	v := &validation{schema: s, steps: &steps}
+	v.validate(s.root, value, "", 0)
+	if steps < 0 {
+		return nil, ErrTooComplex
+	}
+	return v.errors, nil
+}
+
+// decode parses JSON keeping numbers exact, and rejects trailing data.
+func decode(data []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+	return value, nil
+}
+
+// check walks a schema at path, reporting the first malformed keyword.
+func (s *Schema) check(schema any, path string) error {
+	if _, ok := schema.(bool); ok {
+		return nil
+	}
+	object, ok := schema.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%s: must be an object or a boolean", path)
+	}
+
+	for keyword, value := range object {
+		at := path + "/" + keyword
+		var err error
+		switch keyword {
+		case "type":
+			err = checkType(value, at)
+		case "properties", "$defs", "definitions":
+			err = s.checkSchemaMap(value, at)
+		case "additionalProperties", "items", "not":
+			err = s.check(value, at)
+		case "prefixItems", "allOf", "anyOf", "oneOf":
+			err = s.checkSchemaList(value, at)
+		case "required":
+			err = checkStrings(value, at)
+		case "enum":
+			if _, ok := value.([]any); !ok {
+				err = fmt.Errorf("%s: must be an array", at)
+			}
+		case "minLength", "maxLength", "minItems", "maxItems", "minProperties", "maxProperties":
+			if n, ok := number(value); !ok || n < 0 || n != math.Trunc(n) {
+				err = fmt.Errorf("%s: must be a non-negative integer", at)
+			}
+		case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum":
+			if _, ok := number(value); !ok {
+				err = fmt.Errorf("%s: must be a number", at)
+			}
+		case "multipleOf":
+			if n, ok := number(value); !ok || n <= 0 {
+				err = fmt.Errorf("%s: must be a positive number", at)
+			}
+		case "uniqueItems":
+			if _, ok := value.(bool); !ok {
+				err = fmt.Errorf("%s: must be a boolean", at)
+			}
+		case "pattern":
+			err = s.checkPattern(value, at)
+		case "$ref":
+			ref, ok := value.(string)
+			if !ok {
+				err = fmt.Errorf("%s: must be a string", at)
+			} else if _, ok := s.resolve(ref); !ok {
+				err = fmt.Errorf("%s: %q can't be resolved, only references within the schema are supported", at, ref)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkType(value any, path string) error {
+	names, ok := value.([]any)
+	if !ok {
+		names = []any{value}
+	}
+	for _, name := range names {
+		if name, ok := name.(string); !ok || !slices.Contains(types, name) {
+			return fmt.Errorf("%s: must be one of %s, or an array of them", path, strings.Join(types, ", "))
+		}
+	}
+	return nil
+}
+
+func checkStrings(value any, path string) error {
+	list, ok := value.([]any)
+	if !ok {
+		return fmt.Errorf("%s: must be an array of strings", path)
+	}
+	for _, item := range list {
+		if _, ok := item.(string); !ok {
+			return fmt.Errorf("%s: must be an array of strings", path)
+		}
+	}
+	return nil
+}
+
+func (s *Schema) checkSchemaMap(value any, path string) error {
+	schemas, ok := value.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%s: must be an object", path)
+	}
+	for name, schema := range schemas {
+		if err := s.check(schema, path+"/"+escape(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schema) checkSchemaList(value any, path string) error {
+	schemas, ok := value.([]any)
+	if !ok || len(schemas) == 0 {
+		return fmt.Errorf("%s: must be a non-empty array", path)
+	}
+	for i, schema := range schemas {
+		if err := s.check(schema, path+"/"+strconv.Itoa(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schema) checkPattern(value any, path string) error {
+	pattern, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("%s: must be a string", path)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("%s: is not a supported regular expression", path)
+	}
+	s.patterns[pattern] = re
+	return nil
+}
+
+// resolve follows a reference to a part of the schema, such as "#/$defs/item".
+func (s *Schema) resolve(ref string) (any, bool) {
+	pointer, ok := strings.CutPrefix(ref, "#")
+	if !ok {
+		return nil, false
+	}
+
+	current := s.root
+	if pointer == "" {
+		return current, true
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, false
+	}
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch node := current.(type) {
+		case map[string]any:
+			if current, ok = node[token]; !ok {
+				return nil, false
+			}
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			current = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+type validation struct {
+	schema *Schema
+	errors []string
+	// steps is what is left of maxSteps, shared with the validations matches
+	// runs.
+	steps *int
+}
+
+func (v *validation) fail(path string, format string, args ...any) {
+	if len(v.errors) >= maxErrors {
+		return
+	}
+	if path == "" {
+		path = "/"
+	}
+	v.errors = append(v.errors, path+": "+fmt.Sprintf(format, args...))
+}
+
+// matches tells whether value matches schema, without reporting why not.
+func (v *validation) matches(schema any, value any, depth int) bool {
+	sub := &validation{schema: v.schema, steps: v.steps}
+	sub.validate(schema, value, "", depth)
+	return len(sub.errors) == 0
+}
+
+func (v *validation) validate(schema any, value any, path string, depth int) {
+	if *v.steps--; *v.steps < 0 {
+		v.fail(path, "takes too long to validate")
+		return
+	}
+	if depth > maxDepth {
+		v.fail(path, "is nested too deeply for the schema")
+		return
+	}
+	if b, ok := schema.(bool); ok {
+		if !b {
+			v.fail(path, "is not allowed")
+		}
+		return
+	}
+	object, ok := schema.(map[string]any)
+	if !ok {
+		return
+	}
+
+	if ref, ok := object["$ref"].(string); ok {
+		if target, ok := v.schema.resolve(ref); ok {
+			v.validate(target, value, path, depth+1)
+		}
+	}
+
+	if t, ok := object["type"]; ok && !hasType(t, value) {
+		v.fail(path, "must be of type %s", typeNames(t))
+		return
+	}
+	if enum, ok := object["enum"].([]any); ok && !slices.ContainsFunc(enum, func(item any) bool { return equal(item, value) }) {
+		v.fail(path, "must be one of the values of the enum")
+	}
+	if c, ok := object["const"]; ok && !equal(c, value) {
+		v.fail(path, "must be %s", encode(c))
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		v.validateObject(object, value, path, depth)
+	case []any:
+		v.validateArray(object, value, path, depth)
+	case string:
+		v.validateString(object, value, path)
+	case json.Number:
+		v.validateNumber(object, value, path)
+	}
+
+	if allOf, ok := object["allOf"].([]any); ok {
+		for _, schema := range allOf {
+			v.validate(schema, value, path, depth+1)
+		}
+	}
+	if anyOf, ok := object["anyOf"].([]any); ok {
+		if !slices.ContainsFunc(anyOf, func(schema any) bool { return v.matches(schema, value, depth+1) }) {
+			v.fail(path, "must match at least one schema of anyOf")
+		}
+	}
+	if oneOf, ok := object["oneOf"].([]any); ok {
+		matched := 0
+		for _, schema := range oneOf {
+			if v.matches(schema, value, depth+1) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			v.fail(path, "must match exactly one schema of oneOf, matches %d", matched)
+		}
+	}
+	if not, ok := object["not"]; ok && v.matches(not, value, depth+1) {
+		v.fail(path, "must not match the schema of not")
+	}
+}
+
+func (v *validation) validateObject(schema map[string]any, value map[string]any, path string, depth int) {
+	if required, ok := schema["required"].([]any); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, ok := value[name]; !ok {
+					v.fail(path, "is missing required property %q", name)
+				}
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]any)
+	additional, hasAdditional := schema["additionalProperties"]
+	names := make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		at := path + "/" + escape(name)
+		if property, ok := properties[name]; ok {
+			v.validate(property, value[name], at, depth+1)
+		} else if hasAdditional {
+			if allowed, ok := additional.(bool); ok && !allowed {
+				v.fail(path, "must not have property %q", name)
+			} else {
+				v.validate(additional, value[name], at, depth+1)
+			}
+		}
+	}
+
+	if n, ok := number(schema["minProperties"]); ok && float64(len(value)) < n {
+		v.fail(path, "must have at least %v properties", n)
+	}
+	if n, ok := number(schema["maxProperties"]); ok && float64(len(value)) > n {
+		v.fail(path, "must have at most %v properties", n)
+	}
+}
+
+func (v *validation) validateArray(schema map[string]any, value []any, path string, depth int) {
+	prefix, _ := schema["prefixItems"].([]any)
+	for i, item := range value {
+		at := path + "/" + strconv.Itoa(i)
+		if i < len(prefix) {
+			v.validate(prefix[i], item, at, depth+1)
+		} else if items, ok := schema["items"]; ok {
+			v.validate(items, item, at, depth+1)
+		}
+	}
+
+	if n, ok := number(schema["minItems"]); ok && float64(len(value)) < n {
+		v.fail(path, "must have at least %v items", n)
+	}
+	if n, ok := number(schema["maxItems"]); ok && float64(len(value)) > n {
+		v.fail(path, "must have at most %v items", n)
+	}
+	if unique, _ := schema["uniqueItems"].(bool); unique {
+		for i := range value {
+			for j := i + 1; j < len(value); j++ {
+				if equal(value[i], value[j]) {
+					v.fail(path, "must not contain duplicate items, %d and %d are equal", i, j)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (v *validation) validateString(schema map[string]any, value string, path string) {
+	length := float64(utf8.RuneCountInString(value))
+	if n, ok := number(schema["minLength"]); ok && length < n {
+		v.fail(path, "must be at least %v characters long", n)
+	}
+	if n, ok := number(schema["maxLength"]); ok && length > n {
+		v.fail(path, "must be at most %v characters long", n)
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		if re := v.schema.patterns[pattern]; re != nil && !re.MatchString(value) {
+			v.fail(path, "must match the pattern %q", pattern)
+		}
+	}
+}
+
+func (v *validation) validateNumber(schema map[string]any, value json.Number, path string) {
+	f, err := value.Float64()
+	if err != nil {
+		v.fail(path, "is out of range")
+		return
+	}
+	if n, ok := number(schema["minimum"]); ok && f < n {
+		v.fail(path, "must be at least %v", n)
+	}
+	if n, ok := number(schema["maximum"]); ok && f > n {
+		v.fail(path, "must be at most %v", n)
+	}
+	if n, ok := number(schema["exclusiveMinimum"]); ok && f <= n {
+		v.fail(path, "must be greater than %v", n)
+	}
+	if n, ok := number(schema["exclusiveMaximum"]); ok && f >= n {
+		v.fail(path, "must be less than %v", n)
+	}
+	if n, ok := number(schema["multipleOf"]); ok && n > 0 {
+		if q := f / n; math.Abs(q-math.Round(q)) > 1e-9 {
+			v.fail(path, "must be a multiple of %v", n)
+		}
+	}
+}
+
+func hasType(t any, value any) bool {
+	names, ok := t.([]any)
+	if !ok {
+		names = []any{t}
+	}
+	for _, name := range names {
+		switch name {
+		case "object":
+			_, ok = value.(map[string]any)
+		case "array":
+			_, ok = value.([]any)
+		case "string":
+			_, ok = value.(string)
+		case "number":
+			_, ok = value.(json.Number)
+		case "integer":
+			n, isNumber := number(value)
+			ok = isNumber && n == math.Trunc(n)
+		case "boolean":
+			_, ok = value.(bool)
+		case "null":
+			ok = value == nil
+		default:
+			ok = false
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func typeNames(t any) string {
+	names, ok := t.([]any)
+	if !ok {
+		return fmt.Sprint(t)
+	}
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprint(name))
+	}
+	return strings.Join(parts, " or ")
+}
+
+func number(value any) (float64, bool) {
+	n, ok := value.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return f, err == nil
+}
+
+// equal compares JSON values, numbers by value so that 1 and 1.0 are equal.
+func equal(a any, b any) bool {
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+func normalize(value any) any {
+	switch value := value.(type) {
+	case json.Number:
+		f, _ := value.Float64()
+		return f
+	case map[string]any:
+		normalized := make(map[string]any, len(value))
+		for k, v := range value {
+			normalized[k] = normalize(v)
+		}
+		return normalized
+	case []any:
+		normalized := make([]any, len(value))
+		for i, v := range value {
+			normalized[i] = normalize(v)
+		}
+		return normalized
+	}
+	return value
+}
+
+func encode(value any) string {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(b)
+}
+
+// escape turns a property name into a JSON pointer token.
+func escape(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,211 @@
+package jsonschema
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		valid  bool
+	}{
+		{"object", `{"type":"object"}`, true},
+		{"not an object schema", `{"type":"string"}`, false},
+		{"no type", `{"properties":{}}`, false},
+		{"not JSON", `{"type":`, false},
+		{"trailing data", `{"type":"object"} {}`, false},
+		{"unknown type", `{"type":"object","properties":{"a":{"type":"text"}}}`, false},
+		{"negative length", `{"type":"object","properties":{"a":{"maxLength":-1}}}`, false},
+		{"zero multipleOf", `{"type":"object","properties":{"a":{"multipleOf":0}}}`, false},
+		{"bad pattern", `{"type":"object","properties":{"a":{"pattern":"("}}}`, false},
+		{"required not strings", `{"type":"object","required":[1]}`, false},
+		{"anyOf not a list", `{"type":"object","anyOf":{}}`, false},
+		{"unresolved ref", `{"type":"object","properties":{"a":{"$ref":"#/$defs/missing"}}}`, false},
+		{"local ref", `{"type":"object","$defs":{"a":{"type":"string"}},"properties":{"a":{"$ref":"#/$defs/a"}}}`, true},
+		{"unknown keywords", `{"type":"object","properties":{"a":{"format":"email","description":"x"}}}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile([]byte(tt.schema))
+			if (err == nil) != tt.valid {
+				t.Errorf("Compile(%s) error = %v, want valid %t", tt.schema, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		data   string
+		errors []string
+	}{
+		{
+			name:   "properties and required",
+			schema: `{"type":"object","properties":{"name":{"type":"string"},"age":{"type":"integer"}},"required":["name"]}`,
+			data:   `{"age":1.5}`,
+			errors: []string{`/: is missing required property "name"`, "/age: must be of type integer"},
+		},
+		{
+			name:   "valid object",
+			schema: `{"type":"object","properties":{"name":{"type":"string"}},"required":["name"],"additionalProperties":false}`,
+			data:   `{"name":"a"}`,
+		},
+		{
+			name:   "additional properties",
+			schema: `{"type":"object","properties":{"name":{"type":"string"}},"additionalProperties":false}`,
+			data:   `{"name":"a","extra":1}`,
+			errors: []string{`/: must not have property "extra"`},
+		},
+		{
+			name:   "enum and const",
+			schema: `{"type":"object","properties":{"a":{"enum":["x","y"]},"b":{"const":3}}}`,
+			data:   `{"a":"z","b":3.0}`,
+			errors: []string{"/a: must be one of the values of the enum"},
+		},
+		{
+			name:   "string bounds and pattern",
+			schema: `{"type":"object","properties":{"a":{"type":"string","minLength":2,"maxLength":3,"pattern":"^[a-z]+$"}}}`,
+			data:   `{"a":"ABCD"}`,
+			errors: []string{"/a: must be at most 3 characters long", "/a: must match"},
+		},
+		{
+			name:   "string length counts characters",
+			schema: `{"type":"object","properties":{"a":{"type":"string","maxLength":2}}}`,
+			data:   `{"a":"éé"}`,
+		},
+		{
+			name:   "numeric bounds",
+			schema: `{"type":"object","properties":{"a":{"minimum":1,"exclusiveMaximum":5,"multipleOf":2}}}`,
+			data:   `{"a":5}`,
+			errors: []string{"/a: must be less than 5", "/a: must be a multiple of 2"},
+		},
+		{
+			name:   "array items",
+			schema: `{"type":"object","properties":{"a":{"type":"array","items":{"type":"number"},"minItems":1,"uniqueItems":true}}}`,
+			data:   `{"a":[1,"2",1]}`,
+			errors: []string{"/a/1: must be of type number", "/a: must not contain duplicate items"},
+		},
+		{
+			name:   "prefixItems",
+			schema: `{"type":"object","properties":{"a":{"type":"array","prefixItems":[{"type":"string"}],"items":{"type":"integer"}}}}`,
+			data:   `{"a":["x",1,"y"]}`,
+			errors: []string{"/a/2: must be of type integer"},
+		},
+		{
+			name:   "anyOf",
+			schema: `{"type":"object","properties":{"a":{"anyOf":[{"type":"string"},{"type":"null"}]}}}`,
+			data:   `{"a":1}`,
+			errors: []string{"/a: must match at least one schema of anyOf"},
+		},
+		{
+			name:   "oneOf",
+			schema: `{"type":"object","properties":{"a":{"oneOf":[{"type":"number"},{"type":"integer"}]}}}`,
+			data:   `{"a":1}`,
+			errors: []string{"/a: must match exactly one schema of oneOf, matches 2"},
+		},
+		{
+			name:   "not",
+			schema: `{"type":"object","properties":{"a":{"not":{"type":"string"}}}}`,
+			data:   `{"a":"x"}`,
+			errors: []string{"/a: must not match the schema of not"},
+		},
+		{
+			name:   "ref",
+			schema: `{"type":"object","$defs":{"id":{"type":"integer"}},"properties":{"a":{"$ref":"#/$defs/id"}}}`,
+			data:   `{"a":"1"}`,
+			errors: []string{"/a: must be of type integer"},
+		},
+		{
+			name:   "recursive ref",
+			schema: `{"type":"object","$defs":{"node":{"type":"object","properties":{"next":{"$ref":"#/$defs/node"},"v":{"type":"integer"}}}},"properties":{"root":{"$ref":"#/$defs/node"}}}`,
+			data:   `{"root":{"next":{"next":{"v":"x"}}}}`,
+			errors: []string{"/root/next/next/v: must be of type integer"},
+		},
+		{
+			name:   "escaped property names",
+			schema: `{"type":"object","properties":{"a/b":{"type":"string"}}}`,
+			data:   `{"a/b":1}`,
+			errors: []string{"/a~1b: must be of type string"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := Compile([]byte(tt.schema))
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+			got, err := schema.Validate([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			if len(got) != len(tt.errors) {
+				t.Fatalf("Validate(%s) = %q, want %d errors like %q", tt.data, got, len(tt.errors), tt.errors)
+			}
+			for i, want := range tt.errors {
+				if !strings.HasPrefix(got[i], want) {
+					t.Errorf("error %d = %q, want it to start with %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateInvalidJSON(t *testing.T) {
+	schema, err := Compile([]byte(`{"type":"object"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{``, `{"a":`, `{} {}`} {
+		if _, err := schema.Validate([]byte(data)); !errors.Is(err, ErrInvalidJSON) {
+			t.Errorf("Validate(%q) error = %v, want ErrInvalidJSON", data, err)
+		}
+	}
+}
+
+func TestValidateMaxErrors(t *testing.T) {
+	schema, err := Compile([]byte(`{"type":"object","additionalProperties":false}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := `{"a":1,"b":1,"c":1,"d":1,"e":1,"f":1,"g":1,"h":1,"i":1,"j":1,"k":1,"l":1}`
+	got, err := schema.Validate([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != maxErrors {
+		t.Errorf("got %d errors, want %d", len(got), maxErrors)
+	}
+}
+
+// A schema whose combinators refer back to themselves must not take time
+// exponential in how deep it may recurse.
+func TestValidateTooComplex(t *testing.T) {
+	schemas := []string{
+		`{"type":"object","$defs":{"a":{"anyOf":[{"$ref":"#/$defs/a"},{"$ref":"#/$defs/a"}]}},"properties":{"x":{"$ref":"#/$defs/a"}}}`,
+		`{"type":"object","$defs":{"a":{"oneOf":[{"$ref":"#/$defs/a"},{"$ref":"#/$defs/a"},{"$ref":"#/$defs/a"}]}},"properties":{"x":{"$ref":"#/$defs/a"}}}`,
+		`{"type":"object","$defs":{"a":{"allOf":[{"not":{"$ref":"#/$defs/a"}},{"not":{"$ref":"#/$defs/a"}}]}},"properties":{"x":{"$ref":"#/$defs/a"}}}`,
+	}
+	for _, raw := range schemas {
+		schema, err := Compile([]byte(raw))
+		if err != nil {
+			t.Fatalf("Compile: %v", err)
+		}
+
+		started := time.Now()
+		_, err = schema.Validate([]byte(`{"x":1}`))
+		if !errors.Is(err, ErrTooComplex) {
+			t.Errorf("Validate error = %v, want ErrTooComplex", err)
+		}
+		if elapsed := time.Since(started); elapsed > 2*time.Second {
+			t.Errorf("Validate took %s", elapsed)
+		}
+	}
+}
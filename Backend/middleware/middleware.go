@@ -181,7 +181,7 @@ func (m *Middleware) EnableCORS(next http.HandlerFunc) http.HandlerFunc {
 
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 						w.Header().Set("Access-Control-Allow-Methods", "PATCH, OPTIONS, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Api-Key")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Api-Key, Guest-Token, Last-Event-ID")
 
 						w.WriteHeader(http.StatusOK)
 						return
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS message_search_idx;
+ALTER TABLE message DROP COLUMN IF EXISTS search;
+
+DROP INDEX IF EXISTS title_search_idx;
+ALTER TABLE title DROP COLUMN IF EXISTS search;
\ No newline at end of file
@@ -0,0 +1,7 @@
+ALTER TABLE title
+    ADD COLUMN IF NOT EXISTS search TSVECTOR GENERATED ALWAYS AS (to_tsvector('english', title)) STORED;
+CREATE INDEX IF NOT EXISTS title_search_idx ON title USING GIN (search);
+
+ALTER TABLE message
+    ADD COLUMN IF NOT EXISTS search TSVECTOR GENERATED ALWAYS AS (to_tsvector('english', text)) STORED;
+CREATE INDEX IF NOT EXISTS message_search_idx ON message USING GIN (search);
\ No newline at end of file
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS message_title_id_idx;
+DROP INDEX IF EXISTS title_user_activity_idx;
+
+ALTER TABLE title DROP COLUMN IF EXISTS last_activity;
\ No newline at end of file
@@ -0,0 +1,8 @@
+ALTER TABLE title
+    ADD COLUMN IF NOT EXISTS last_activity TIMESTAMPTZ DEFAULT NOW() NOT NULL;
+
+UPDATE title
+SET last_activity = COALESCE((SELECT MAX(timestamp) FROM message WHERE title_id = title.id), last_activity);
+
+CREATE INDEX IF NOT EXISTS title_user_activity_idx ON title (user_id, last_activity DESC, id DESC);
+CREATE INDEX IF NOT EXISTS message_title_id_idx ON message (title_id, id);
\ No newline at end of file
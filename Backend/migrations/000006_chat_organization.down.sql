@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS title_user_pinned_activity_idx;
+CREATE INDEX IF NOT EXISTS title_user_activity_idx ON title (user_id, last_activity DESC, id DESC);
+
+ALTER TABLE title
+    DROP COLUMN IF EXISTS folder_id,
+    DROP COLUMN IF EXISTS archived,
+    DROP COLUMN IF EXISTS pinned;
+
+DROP TABLE IF EXISTS title_tag;
+DROP TABLE IF EXISTS tag;
+DROP TABLE IF EXISTS folder;
\ No newline at end of file
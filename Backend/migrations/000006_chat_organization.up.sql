@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS folder
+(
+    id      BIGSERIAL PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    name    VARCHAR(255) NOT NULL,
+    UNIQUE (user_id, name),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS tag
+(
+    id      BIGSERIAL PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    name    VARCHAR(50)  NOT NULL,
+    UNIQUE (user_id, name),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS title_tag
+(
+    title_id BIGINT NOT NULL,
+    tag_id   BIGINT NOT NULL,
+    PRIMARY KEY (title_id, tag_id),
+    FOREIGN KEY (title_id) REFERENCES title (id) ON DELETE CASCADE,
+    FOREIGN KEY (tag_id) REFERENCES tag (id) ON DELETE CASCADE
+);
+
+ALTER TABLE title
+    ADD COLUMN IF NOT EXISTS pinned    BOOLEAN DEFAULT FALSE NOT NULL,
+    ADD COLUMN IF NOT EXISTS archived  BOOLEAN DEFAULT FALSE NOT NULL,
+    ADD COLUMN IF NOT EXISTS folder_id BIGINT REFERENCES folder (id) ON DELETE SET NULL;
+
+DROP INDEX IF EXISTS title_user_activity_idx;
+CREATE INDEX IF NOT EXISTS title_user_pinned_activity_idx ON title (user_id, pinned DESC, last_activity DESC, id DESC);
\ No newline at end of file
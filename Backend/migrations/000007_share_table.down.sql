@@ -0,0 +1 @@
+DROP TABLE IF EXISTS share;
\ No newline at end of file
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS share
+(
+    id         BIGSERIAL PRIMARY KEY,
+    hash       BYTEA UNIQUE              NOT NULL,
+    user_id    VARCHAR(255)              NOT NULL,
+    title_id   BIGINT                    NOT NULL,
+    live       BOOLEAN     DEFAULT FALSE NOT NULL,
+    title      VARCHAR(255)              NOT NULL,
+    snapshot   JSONB,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (title_id) REFERENCES title (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS share_user_id_idx ON share (user_id, created_at DESC);
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE message
+    ADD COLUMN IF NOT EXISTS model VARCHAR(255) DEFAULT '' NOT NULL;
\ No newline at end of file
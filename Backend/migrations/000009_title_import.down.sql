@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS title_import_idx;
+
+ALTER TABLE title
+    DROP COLUMN IF EXISTS import_id,
+    DROP COLUMN IF EXISTS import_source;
\ No newline at end of file
@@ -0,0 +1,5 @@
+ALTER TABLE title
+    ADD COLUMN IF NOT EXISTS import_source VARCHAR(50),
+    ADD COLUMN IF NOT EXISTS import_id     VARCHAR(255);
+
+CREATE UNIQUE INDEX IF NOT EXISTS title_import_idx ON title (user_id, import_source, import_id);
\ No newline at end of file
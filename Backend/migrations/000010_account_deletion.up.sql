@@ -0,0 +1,17 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS deletion_scheduled_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS users_deletion_scheduled_at_idx ON users (deletion_scheduled_at) WHERE deletion_scheduled_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS data_export
+(
+    id           BIGSERIAL PRIMARY KEY,
+    user_id      VARCHAR(255)                NOT NULL,
+    status       VARCHAR(20) DEFAULT 'pending' NOT NULL,
+    archive      BYTEA,
+    error        TEXT        DEFAULT ''      NOT NULL,
+    created_at   TIMESTAMPTZ DEFAULT NOW()   NOT NULL,
+    completed_at TIMESTAMPTZ,
+    expires_at   TIMESTAMPTZ                 NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
\ No newline at end of file
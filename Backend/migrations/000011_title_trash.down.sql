@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS title_deleted_at_idx;
+ALTER TABLE title DROP COLUMN IF EXISTS deleted_at;
\ No newline at end of file
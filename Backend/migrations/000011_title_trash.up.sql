@@ -0,0 +1,4 @@
+ALTER TABLE title
+    ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS title_deleted_at_idx ON title (deleted_at) WHERE deleted_at IS NOT NULL;
\ No newline at end of file
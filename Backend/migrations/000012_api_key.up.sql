@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS api_key
+(
+    user_id    VARCHAR(255)              NOT NULL,
+    model_type VARCHAR(20)               NOT NULL,
+    secret     TEXT                      NOT NULL,
+    hint       VARCHAR(4)                NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (user_id, model_type),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
\ No newline at end of file
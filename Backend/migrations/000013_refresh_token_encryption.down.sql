@@ -0,0 +1,7 @@
+-- Sealed tokens can't be read back as plaintext here, so they are dropped and
+-- those users have to grant offline access again.
+UPDATE users SET refresh_token = '' WHERE refresh_token_sealed;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS refresh_token_sealed,
+    ALTER COLUMN refresh_token TYPE VARCHAR(255);
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE users
+    ALTER COLUMN refresh_token TYPE TEXT,
+    ADD COLUMN IF NOT EXISTS refresh_token_sealed BOOLEAN DEFAULT FALSE NOT NULL;
\ No newline at end of file
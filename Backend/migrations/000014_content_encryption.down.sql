@@ -0,0 +1,39 @@
+-- Without the data keys encrypted content can't be turned back into plaintext,
+-- so the migration refuses to run while there is any.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM message WHERE encrypted)
+        OR EXISTS (SELECT 1 FROM title WHERE encrypted)
+        OR EXISTS (SELECT 1 FROM data_export WHERE encrypted)
+        OR EXISTS (SELECT 1 FROM share WHERE title_encrypted OR snapshot @> '[{"encrypted": true}]') THEN
+        RAISE EXCEPTION 'encrypted content exists, decrypt or delete it before migrating down';
+    END IF;
+END
+$$;
+
+ALTER TABLE share
+    DROP COLUMN IF EXISTS title_encrypted,
+    ALTER COLUMN title TYPE VARCHAR(255) USING left(title, 255);
+
+DROP INDEX IF EXISTS message_search_idx;
+ALTER TABLE message
+    DROP COLUMN IF EXISTS search,
+    DROP COLUMN IF EXISTS encrypted;
+ALTER TABLE message
+    ADD COLUMN IF NOT EXISTS search TSVECTOR GENERATED ALWAYS AS (to_tsvector('english', text)) STORED;
+CREATE INDEX IF NOT EXISTS message_search_idx ON message USING GIN (search);
+
+DROP INDEX IF EXISTS title_search_idx;
+ALTER TABLE title
+    DROP COLUMN IF EXISTS search,
+    DROP COLUMN IF EXISTS encrypted;
+ALTER TABLE title
+    ALTER COLUMN title TYPE VARCHAR(255) USING left(title, 255);
+ALTER TABLE title
+    ADD COLUMN IF NOT EXISTS search TSVECTOR GENERATED ALWAYS AS (to_tsvector('english', title)) STORED;
+CREATE INDEX IF NOT EXISTS title_search_idx ON title USING GIN (search);
+
+ALTER TABLE data_export
+    DROP COLUMN IF EXISTS encrypted;
+
+DROP TABLE IF EXISTS data_key;
\ No newline at end of file
@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS data_key
+(
+    user_id    VARCHAR(255) PRIMARY KEY,
+    data_key   TEXT                      NOT NULL,
+    enabled    BOOLEAN     DEFAULT TRUE  NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- Ciphertext is longer than the text it hides and is useless to index, so the
+-- search columns are rebuilt to skip encrypted rows.
+DROP INDEX IF EXISTS title_search_idx;
+ALTER TABLE title
+    DROP COLUMN IF EXISTS search;
+ALTER TABLE title
+    ALTER COLUMN title TYPE TEXT,
+    ADD COLUMN IF NOT EXISTS encrypted BOOLEAN DEFAULT FALSE NOT NULL;
+ALTER TABLE title
+    ADD COLUMN IF NOT EXISTS search TSVECTOR GENERATED ALWAYS AS (CASE WHEN encrypted THEN NULL ELSE to_tsvector('english', title) END) STORED;
+CREATE INDEX IF NOT EXISTS title_search_idx ON title USING GIN (search);
+
+DROP INDEX IF EXISTS message_search_idx;
+ALTER TABLE message
+    DROP COLUMN IF EXISTS search;
+ALTER TABLE message
+    ADD COLUMN IF NOT EXISTS encrypted BOOLEAN DEFAULT FALSE NOT NULL;
+ALTER TABLE message
+    ADD COLUMN IF NOT EXISTS search TSVECTOR GENERATED ALWAYS AS (CASE WHEN encrypted THEN NULL ELSE to_tsvector('english', text) END) STORED;
+CREATE INDEX IF NOT EXISTS message_search_idx ON message USING GIN (search);
+
+ALTER TABLE share
+    ALTER COLUMN title TYPE TEXT,
+    ADD COLUMN IF NOT EXISTS title_encrypted BOOLEAN DEFAULT FALSE NOT NULL;
+
+ALTER TABLE data_export
+    ADD COLUMN IF NOT EXISTS encrypted BOOLEAN DEFAULT FALSE NOT NULL;
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS prompt_template;
\ No newline at end of file
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS prompt_template
+(
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    VARCHAR(255)              NOT NULL,
+    name       VARCHAR(100)              NOT NULL,
+    body       TEXT                      NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    UNIQUE (user_id, name),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
\ No newline at end of file
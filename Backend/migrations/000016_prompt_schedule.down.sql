@@ -0,0 +1 @@
+DROP TABLE IF EXISTS prompt_schedule;
\ No newline at end of file
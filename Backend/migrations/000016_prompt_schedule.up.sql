@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS prompt_schedule
+(
+    id          BIGSERIAL PRIMARY KEY,
+    user_id     VARCHAR(255)              NOT NULL,
+    title_id    BIGINT                    NOT NULL,
+    cron        VARCHAR(100)              NOT NULL,
+    timezone    VARCHAR(64)               NOT NULL,
+    model_type  VARCHAR(20)               NOT NULL,
+    model       VARCHAR(100) DEFAULT ''   NOT NULL,
+    prompt      TEXT         DEFAULT ''   NOT NULL,
+    template_id BIGINT,
+    variables   JSONB        DEFAULT '{}' NOT NULL,
+    enabled     BOOLEAN      DEFAULT TRUE NOT NULL,
+    next_run_at TIMESTAMPTZ               NOT NULL,
+    last_run_at TIMESTAMPTZ,
+    last_error  TEXT         DEFAULT ''   NOT NULL,
+    created_at  TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    updated_at  TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (title_id) REFERENCES title (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS prompt_schedule_due_idx ON prompt_schedule (next_run_at) WHERE enabled;
\ No newline at end of file
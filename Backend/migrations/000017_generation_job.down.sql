@@ -0,0 +1 @@
+DROP TABLE IF EXISTS generation_job;
\ No newline at end of file
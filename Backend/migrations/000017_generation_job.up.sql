@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS generation_job
+(
+    id               BIGSERIAL PRIMARY KEY,
+    user_id          VARCHAR(255)                   NOT NULL,
+    title_id         BIGINT                         NOT NULL,
+    model_type       VARCHAR(20)                    NOT NULL,
+    model            VARCHAR(100) DEFAULT ''        NOT NULL,
+    prompt           TEXT         DEFAULT ''        NOT NULL,
+    prompt_encrypted BOOLEAN      DEFAULT FALSE     NOT NULL,
+    api_key          TEXT,
+    status           VARCHAR(20)  DEFAULT 'pending' NOT NULL,
+    attempts         INTEGER      DEFAULT 0         NOT NULL,
+    result           TEXT         DEFAULT ''        NOT NULL,
+    result_encrypted BOOLEAN      DEFAULT FALSE     NOT NULL,
+    error            TEXT         DEFAULT ''        NOT NULL,
+    created_at       TIMESTAMPTZ  DEFAULT NOW()     NOT NULL,
+    started_at       TIMESTAMPTZ,
+    heartbeat_at     TIMESTAMPTZ,
+    completed_at     TIMESTAMPTZ,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (title_id) REFERENCES title (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS generation_job_status_idx ON generation_job (status, id) WHERE status IN ('pending', 'running');
\ No newline at end of file
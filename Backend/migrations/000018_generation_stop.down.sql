@@ -0,0 +1,8 @@
+ALTER TABLE generation_job
+    DROP COLUMN IF EXISTS generation_id;
+
+DROP INDEX IF EXISTS message_generation_id_idx;
+
+ALTER TABLE message
+    DROP COLUMN IF EXISTS generation_id,
+    DROP COLUMN IF EXISTS stopped;
\ No newline at end of file
@@ -0,0 +1,8 @@
+ALTER TABLE message
+    ADD COLUMN IF NOT EXISTS stopped BOOLEAN DEFAULT FALSE NOT NULL,
+    ADD COLUMN IF NOT EXISTS generation_id VARCHAR(26) DEFAULT '' NOT NULL;
+
+CREATE INDEX IF NOT EXISTS message_generation_id_idx ON message (generation_id) WHERE generation_id <> '';
+
+ALTER TABLE generation_job
+    ADD COLUMN IF NOT EXISTS generation_id VARCHAR(26) DEFAULT '' NOT NULL;
\ No newline at end of file
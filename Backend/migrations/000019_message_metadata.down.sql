@@ -0,0 +1,15 @@
+ALTER TABLE message
+    DROP COLUMN IF EXISTS completion_tokens,
+    DROP COLUMN IF EXISTS prompt_tokens,
+    DROP COLUMN IF EXISTS latency_ms,
+    DROP COLUMN IF EXISTS finish_reason,
+    DROP COLUMN IF EXISTS provider;
+
+DROP INDEX IF EXISTS message_search_idx;
+ALTER TABLE message
+    DROP COLUMN IF EXISTS search;
+ALTER TABLE message
+    ALTER COLUMN text TYPE VARCHAR(255) USING left(text, 255);
+ALTER TABLE message
+    ADD COLUMN IF NOT EXISTS search TSVECTOR GENERATED ALWAYS AS (CASE WHEN encrypted THEN NULL ELSE to_tsvector('english', text) END) STORED;
+CREATE INDEX IF NOT EXISTS message_search_idx ON message USING GIN (search);
\ No newline at end of file
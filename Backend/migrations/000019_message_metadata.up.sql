@@ -0,0 +1,17 @@
+-- Replies are routinely longer than 255 characters. The search column is
+-- generated from the text, so it's rebuilt around the change.
+DROP INDEX IF EXISTS message_search_idx;
+ALTER TABLE message
+    DROP COLUMN IF EXISTS search;
+ALTER TABLE message
+    ALTER COLUMN text TYPE TEXT;
+ALTER TABLE message
+    ADD COLUMN IF NOT EXISTS search TSVECTOR GENERATED ALWAYS AS (CASE WHEN encrypted THEN NULL ELSE to_tsvector('english', text) END) STORED;
+CREATE INDEX IF NOT EXISTS message_search_idx ON message USING GIN (search);
+
+ALTER TABLE message
+    ADD COLUMN IF NOT EXISTS provider          VARCHAR(255) DEFAULT '' NOT NULL,
+    ADD COLUMN IF NOT EXISTS finish_reason     VARCHAR(255) DEFAULT '' NOT NULL,
+    ADD COLUMN IF NOT EXISTS latency_ms        INTEGER      DEFAULT 0  NOT NULL,
+    ADD COLUMN IF NOT EXISTS prompt_tokens     INTEGER      DEFAULT 0  NOT NULL,
+    ADD COLUMN IF NOT EXISTS completion_tokens INTEGER      DEFAULT 0  NOT NULL;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE message
+    DROP COLUMN IF EXISTS reasoning_tokens,
+    DROP COLUMN IF EXISTS reasoning;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE message
+    ADD COLUMN IF NOT EXISTS reasoning        TEXT    DEFAULT '' NOT NULL,
+    ADD COLUMN IF NOT EXISTS reasoning_tokens INTEGER DEFAULT 0  NOT NULL;
\ No newline at end of file
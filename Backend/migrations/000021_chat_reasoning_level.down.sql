@@ -0,0 +1 @@
+ALTER TABLE title DROP COLUMN IF EXISTS reasoning_level;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE title
+    ADD COLUMN IF NOT EXISTS reasoning_level VARCHAR(255) DEFAULT '' NOT NULL;
\ No newline at end of file
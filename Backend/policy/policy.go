@@ -0,0 +1,285 @@
+// Package policy runs prompts and replies through hooks that enforce what may
+// be sent to a model and what may come back from it. A hook can block the text,
+// rewrite it or annotate it. Hooks are registered for a stage and run in the
+// order they were registered; the built-in ones are a denylist, a length limit
+// and OpenAI's moderation API.
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Stage is the point of a generation a hook runs at.
+type Stage string
+
+const (
+	// StagePrompt runs before the prompt is sent to the model.
+	StagePrompt Stage = "prompt"
+	// StageReply runs on the reply once it's generated, before it's stored.
+	StageReply Stage = "reply"
+)
+
+// ErrBlocked matches every BlockedError.
+var ErrBlocked = errors.New("blocked by policy")
+
+// BlockedError is returned when a hook blocks a prompt or a reply. Reason is
+// meant for the user and never quotes the text.
+type BlockedError struct {
+	Hook   string
+	Stage  Stage
+	Reason string
+}
+
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("the %s was blocked by policy: %s", e.Stage, e.Reason)
+}
+
+func (e *BlockedError) Is(target error) bool {
+	return target == ErrBlocked
+}
+
+// Block returns the error a hook blocks its content with.
+func Block(reason string) error {
+	return &BlockedError{Reason: reason}
+}
+
+// Annotation is a note a hook left on a prompt or a reply, stored with the
+// message.
+type Annotation struct {
+	Hook string `json:"hook"`
+	Note string `json:"note"`
+}
+
+// Content is a prompt or a reply on its way through the hooks. Hooks rewrite it
+// by changing Text.
+type Content struct {
+	Stage Stage
+	// UserID is empty for guests.
+	UserID      string
+	ModelType   string
+	Model       string
+	Text        string
+	Annotations []Annotation
+	hook        string
+}
+
+// Annotate leaves a note on the content from the running hook.
+func (c *Content) Annotate(note string) {
+	c.Annotations = append(c.Annotations, Annotation{Hook: c.hook, Note: note})
+}
+
+// Hook checks a prompt or a reply. It returns an error made by Block to block
+// it; any other error fails the generation, as content that couldn't be checked
+// isn't let through.
+type Hook interface {
+	Name() string
+	Apply(ctx context.Context, c *Content) error
+}
+
+// Registry holds the hooks of each stage. A nil Registry has no hooks.
+type Registry struct {
+	hooks map[Stage][]Hook
+}
+
+func NewRegistry() *Registry {
+	return &Registry{hooks: make(map[Stage][]Hook)}
+}
+
+// Register adds hook to the end of the chain of each of stages.
+func (r *Registry) Register(hook Hook, stages ...Stage) {
+	for _, stage := range stages {
+		r.hooks[stage] = append(r.hooks[stage], hook)
+	}
+}
+
+// Has reports whether any hook runs at stage.
+func (r *Registry) Has(stage Stage) bool {
+	return r != nil && len(r.hooks[stage]) > 0
+}
+
+// Run passes c through the hooks of its stage, stopping at the first that
+// blocks it or fails.
+func (r *Registry) Run(ctx context.Context, c *Content) error {
+	if r == nil {
+		return nil
+	}
+	for _, hook := range r.hooks[c.Stage] {
+		c.hook = hook.Name()
+		err := hook.Apply(ctx, c)
+		var blocked *BlockedError
+		switch {
+		case err == nil:
+			continue
+		case errors.As(err, &blocked):
+			blocked.Hook, blocked.Stage = hook.Name(), c.Stage
+			return blocked
+		default:
+			return fmt.Errorf("policy hook %s: %w", hook.Name(), err)
+		}
+	}
+	c.hook = ""
+	return nil
+}
+
+// Action is what the denylist does with text it matches.
+type Action string
+
+const (
+	ActionBlock Action = "block"
+	// ActionMask replaces every match with maskText.
+	ActionMask Action = "mask"
+	// ActionFlag lets the text through annotated.
+	ActionFlag Action = "flag"
+)
+
+const maskText = "[removed]"
+
+// Denylist acts on text matching a regular expression.
+type Denylist struct {
+	pattern *regexp.Regexp
+	action  Action
+}
+
+func NewDenylist(pattern string, action Action) (*Denylist, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	switch action {
+	case ActionBlock, ActionMask, ActionFlag:
+	default:
+		return nil, fmt.Errorf("unknown denylist action %q", action)
+	}
+	return &Denylist{pattern: re, action: action}, nil
+}
+
+func (d *Denylist) Name() string {
+	return "denylist"
+}
+
+func (d *Denylist) Apply(ctx context.Context, c *Content) error {
+	matches := d.pattern.FindAllStringIndex(c.Text, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+	switch d.action {
+	case ActionBlock:
+		return Block("it contains denied content")
+	case ActionMask:
+		c.Text = d.pattern.ReplaceAllLiteralString(c.Text, maskText)
+		c.Annotate(fmt.Sprintf("masked %d denied matches", len(matches)))
+	default:
+		c.Annotate(fmt.Sprintf("contains %d denied matches", len(matches)))
+	}
+	return nil
+}
+
+// MaxLength blocks text longer than the limit of its stage, in characters. A
+// limit of 0 is no limit.
+type MaxLength struct {
+	limits map[Stage]int
+}
+
+func NewMaxLength(prompt int, reply int) *MaxLength {
+	return &MaxLength{limits: map[Stage]int{StagePrompt: prompt, StageReply: reply}}
+}
+
+func (m *MaxLength) Name() string {
+	return "max_length"
+}
+
+func (m *MaxLength) Apply(ctx context.Context, c *Content) error {
+	limit := m.limits[c.Stage]
+	if limit > 0 && utf8.RuneCountInString(c.Text) > limit {
+		return Block(fmt.Sprintf("it is longer than %d characters", limit))
+	}
+	return nil
+}
+
+// moderationModel is the model OpenAI moderates with.
+const moderationModel = "omni-moderation-latest"
+
+// Moderation blocks text OpenAI's moderation API flags, naming the categories
+// it was flagged for.
+type Moderation struct {
+	client  *http.Client
+	baseURL string
+	apiKey  string
+}
+
+func NewModeration(baseURL string, apiKey string) *Moderation {
+	return &Moderation{
+		client:  &http.Client{Timeout: 10 * time.Second},
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  apiKey,
+	}
+}
+
+func (m *Moderation) Name() string {
+	return "moderation"
+}
+
+func (m *Moderation) Apply(ctx context.Context, c *Content) error {
+	if strings.TrimSpace(c.Text) == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(map[string]string{"model": moderationModel, "input": c.Text})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.baseURL+"/moderations", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("moderation API responded with %s", resp.Status)
+	}
+
+	var result struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	var categories []string
+	for _, r := range result.Results {
+		if !r.Flagged {
+			continue
+		}
+		for category, flagged := range r.Categories {
+			if flagged {
+				categories = append(categories, category)
+			}
+		}
+	}
+	if len(categories) > 0 {
+		slices.Sort(categories)
+		return Block("it was flagged for " + strings.Join(slices.Compact(categories), ", "))
+	}
+	return nil
+}
@@ -0,0 +1,728 @@
+// Package provider sits between langchaingo and the model providers' APIs, for
+// what langchaingo can't express. Requests get the reasoning and structured
+// output parameters it has no options for. Replies are read as they arrive and
+// reasoning is taken out of them before langchaingo sees it: it has no place for
+// reasoning, and fails on Claude's thinking blocks outright.
+//
+// OpenAI's reasoning models only share a summary of their reasoning through the
+// Responses API, so their chat completions are sent there and the replies made
+// back into chat completions. Gemini isn't covered: its client doesn't go
+// through here, and its SDK can't ask for the model's thoughts.
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// NewClient returns the HTTP client the OpenAI and Anthropic models are built
+// with.
+func NewClient() *http.Client {
+	return &http.Client{Transport: &transport{base: http.DefaultTransport}}
+}
+
+// Capture collects the reasoning of one generation. It's handed to the
+// transport through the context of the request.
+type Capture struct {
+	mu        sync.Mutex
+	reasoning strings.Builder
+}
+
+type captureKey struct{}
+
+// answerTokens is how many tokens a Claude reply keeps for its answer on top of
+// the thinking budget.
+const answerTokens = 4096
+
+// Reasoning is how hard a reasoning model should think, in its provider's
+// terms: an effort for OpenAI, a thinking budget in tokens for Claude. A zero
+// Reasoning leaves the provider's default.
+type Reasoning struct {
+	Effort       string
+	BudgetTokens int
+}
+
+type reasoningKey struct{}
+
+// WithReasoning returns a context whose generations are sent to a reasoning
+// model with r.
+func WithReasoning(ctx context.Context, r Reasoning) context.Context {
+	return context.WithValue(ctx, reasoningKey{}, r)
+}
+
+// WithCapture returns a context whose generations have their reasoning
+// collected in the returned Capture.
+func WithCapture(ctx context.Context) (context.Context, *Capture) {
+	c := &Capture{}
+	return context.WithValue(ctx, captureKey{}, c), c
+}
+
+// Reasoning returns the reasoning collected so far, which is all of it once the
+// generation is over.
+func (c *Capture) Reasoning() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reasoning.String()
+}
+
+func (c *Capture) add(text string) {
+	if c == nil || text == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reasoning.WriteString(text)
+}
+
+// respondTool is the tool Claude is made to call to answer with structured
+// output, as it has no native JSON schema mode.
+const respondTool = "respond"
+
+type schemaKey struct{}
+
+// WithResponseSchema returns a context whose generations answer with JSON
+// matching schema, through OpenAI's structured outputs or a tool Claude is made
+// to call. Claude's answer comes back as text all the same. Claude can't think
+// while it's made to call a tool, so its thinking budget is dropped.
+func WithResponseSchema(ctx context.Context, schema json.RawMessage) context.Context {
+	return context.WithValue(ctx, schemaKey{}, schema)
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r, hasReasoning := req.Context().Value(reasoningKey{}).(Reasoning)
+	schema, _ := req.Context().Value(schemaKey{}).(json.RawMessage)
+	c, _ := req.Context().Value(captureKey{}).(*Capture)
+	// Only the Responses API shares what OpenAI's reasoning models reasoned.
+	responses := hasReasoning && c != nil && strings.HasSuffix(req.URL.Path, "/chat/completions")
+	if hasReasoning || schema != nil {
+		path := req.URL.Path
+		var err error
+		req, err = rewriteRequest(req, func(body map[string]any) {
+			if hasReasoning {
+				r.apply(path, body)
+			}
+			if schema != nil {
+				applySchema(path, body, schema)
+			}
+			if responses {
+				toResponsesRequest(body)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if responses {
+			req.URL.Path = strings.TrimSuffix(req.URL.Path, "/chat/completions") + "/responses"
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	stream := strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+
+	switch {
+	case responses && stream:
+		resp.Body = newLineFilter(resp.Body, (&responsesStream{capture: c}).filter)
+	case responses:
+		return rewriteBody(resp, func(body []byte) []byte { return responsesCompletion(body, c) })
+	case strings.HasSuffix(req.URL.Path, "/messages") && stream:
+		s := &anthropicStream{capture: c, respond: schema != nil}
+		resp.Body = newLineFilter(resp.Body, func(line []byte) ([]byte, error) { return s.filter(line), nil })
+	case strings.HasSuffix(req.URL.Path, "/messages"):
+		return rewriteBody(resp, func(body []byte) []byte { return anthropicMessage(body, c, schema != nil) })
+	case strings.HasSuffix(req.URL.Path, "/chat/completions") && c == nil:
+		// Nothing to collect.
+	case strings.HasSuffix(req.URL.Path, "/chat/completions") && stream:
+		resp.Body = newLineFilter(resp.Body, func(line []byte) ([]byte, error) { return openAIChunk(line, c), nil })
+	case strings.HasSuffix(req.URL.Path, "/chat/completions"):
+		return rewriteBody(resp, func(body []byte) []byte { return openAICompletion(body, c) })
+	}
+	return resp, nil
+}
+
+// rewriteRequest returns a copy of a JSON request with its body changed by
+// rewrite.
+func rewriteRequest(req *http.Request, rewrite func(map[string]any)) (*http.Request, error) {
+	if req.Body == nil {
+		return req, nil
+	}
+	b, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var body map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err == nil {
+		rewrite(body)
+		if rewritten, err := json.Marshal(body); err == nil {
+			b = rewritten
+		}
+	}
+
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(b))
+	req.ContentLength = int64(len(b))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(b)), nil
+	}
+	return req, nil
+}
+
+// apply sets the reasoning parameters of a request to the chat completions or
+// messages endpoint. Reasoning models only take the default temperature, and
+// langchaingo always sends one.
+func (r Reasoning) apply(path string, body map[string]any) {
+	switch {
+	case strings.HasSuffix(path, "/chat/completions"):
+		delete(body, "temperature")
+		if r.Effort != "" {
+			body["reasoning_effort"] = r.Effort
+		}
+	case strings.HasSuffix(path, "/messages") && r.BudgetTokens > 0:
+		delete(body, "temperature")
+		delete(body, "top_p")
+		delete(body, "top_k")
+		body["thinking"] = map[string]any{"type": "enabled", "budget_tokens": r.BudgetTokens}
+
+		var maxTokens int64
+		if n, ok := body["max_tokens"].(json.Number); ok {
+			maxTokens, _ = n.Int64()
+		}
+		body["max_tokens"] = max(maxTokens, int64(r.BudgetTokens+answerTokens))
+	}
+}
+
+// applySchema asks for structured output matching schema on a request to the
+// chat completions or messages endpoint.
+func applySchema(path string, body map[string]any, schema json.RawMessage) {
+	switch {
+	case strings.HasSuffix(path, "/chat/completions"):
+		body["response_format"] = map[string]any{
+			"type":        "json_schema",
+			"json_schema": map[string]any{"name": "response", "schema": schema},
+		}
+	case strings.HasSuffix(path, "/messages"):
+		delete(body, "thinking")
+		body["tools"] = []any{map[string]any{
+			"name":         respondTool,
+			"description":  "Answer with the response, in the shape of its input schema.",
+			"input_schema": schema,
+		}}
+		body["tool_choice"] = map[string]any{"type": "tool", "name": respondTool}
+	}
+}
+
+// rewriteBody reads the whole body of a reply and replaces it with what rewrite
+// makes of it.
+func rewriteBody(resp *http.Response, rewrite func([]byte) []byte) (*http.Response, error) {
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	body = rewrite(body)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
+
+// lineFilter passes a server-sent event stream through filter line by line. A
+// line filtered to nothing is dropped. A filter that returns an error ends the
+// stream with it.
+type lineFilter struct {
+	src    *bufio.Reader
+	closer io.Closer
+	filter func([]byte) ([]byte, error)
+	buf    []byte
+	err    error
+}
+
+func newLineFilter(body io.ReadCloser, filter func([]byte) ([]byte, error)) *lineFilter {
+	return &lineFilter{src: bufio.NewReader(body), closer: body, filter: filter}
+}
+
+func (f *lineFilter) Read(p []byte) (int, error) {
+	for len(f.buf) == 0 {
+		if f.err != nil {
+			return 0, f.err
+		}
+		var line []byte
+		line, f.err = f.src.ReadBytes('\n')
+		if len(line) > 0 {
+			var err error
+			if f.buf, err = f.filter(line); err != nil {
+				f.buf, f.err = nil, err
+			}
+		}
+	}
+
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+	return n, nil
+}
+
+func (f *lineFilter) Close() error {
+	return f.closer.Close()
+}
+
+// eventData returns the JSON of a "data:" line of an event stream, or nil for
+// any other line.
+func eventData(line []byte) []byte {
+	data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+	if !ok {
+		return nil
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		return nil
+	}
+	return data
+}
+
+// isThinking tells Claude's thinking blocks apart from the answer. Redacted
+// thinking has nothing readable but is dropped all the same.
+func isThinking(blockType string) bool {
+	return blockType == "thinking" || blockType == "redacted_thinking"
+}
+
+// anthropicStream takes the thinking blocks out of a streamed Claude reply. The
+// blocks after them are renumbered, as langchaingo expects content blocks to be
+// numbered from 0 without gaps. When respond is set, the call to respondTool is
+// turned into text, the structured answer it is.
+type anthropicStream struct {
+	capture *Capture
+	respond bool
+	dropped []int
+	answers []int
+}
+
+func (s *anthropicStream) filter(line []byte) []byte {
+	data := eventData(line)
+	if data == nil {
+		return line
+	}
+
+	var event map[string]any
+	if err := json.Unmarshal(data, &event); err != nil {
+		return line
+	}
+
+	changed := false
+	if event["type"] == "message_delta" && s.respond {
+		if delta, _ := event["delta"].(map[string]any); delta != nil && delta["stop_reason"] == "tool_use" {
+			delta["stop_reason"] = "end_turn"
+			changed = true
+		}
+	}
+
+	index, ok := event["index"].(float64)
+	if !ok {
+		return s.encode(line, event, changed)
+	}
+
+	switch event["type"] {
+	case "content_block_start":
+		block, _ := event["content_block"].(map[string]any)
+		blockType, _ := block["type"].(string)
+		switch {
+		case isThinking(blockType):
+			s.dropped = append(s.dropped, int(index))
+			return nil
+		case s.respond && blockType == "tool_use" && block["name"] == respondTool:
+			s.answers = append(s.answers, int(index))
+			event["content_block"] = map[string]any{"type": "text", "text": ""}
+			changed = true
+		}
+	case "content_block_delta":
+		delta, _ := event["delta"].(map[string]any)
+		switch {
+		case slices.Contains(s.dropped, int(index)):
+			thinking, _ := delta["thinking"].(string)
+			s.capture.add(thinking)
+			return nil
+		case slices.Contains(s.answers, int(index)):
+			partial, _ := delta["partial_json"].(string)
+			event["delta"] = map[string]any{"type": "text_delta", "text": partial}
+			changed = true
+		}
+	case "content_block_stop":
+		if slices.Contains(s.dropped, int(index)) {
+			return nil
+		}
+	}
+
+	shift := 0
+	for _, dropped := range s.dropped {
+		if dropped < int(index) {
+			shift++
+		}
+	}
+	if shift > 0 {
+		event["index"] = int(index) - shift
+		changed = true
+	}
+	return s.encode(line, event, changed)
+}
+
+// encode turns an event back into its line if it was changed.
+func (s *anthropicStream) encode(line []byte, event map[string]any, changed bool) []byte {
+	if !changed {
+		return line
+	}
+	b, err := json.Marshal(event)
+	if err != nil {
+		return line
+	}
+	return append(append([]byte("data: "), b...), '\n')
+}
+
+// anthropicMessage takes the thinking blocks out of a Claude reply that wasn't
+// streamed, and turns the call to respondTool into text when respond is set.
+func anthropicMessage(body []byte, c *Capture, respond bool) []byte {
+	var message map[string]json.RawMessage
+	if err := json.Unmarshal(body, &message); err != nil {
+		return body
+	}
+	var blocks []json.RawMessage
+	if err := json.Unmarshal(message["content"], &blocks); err != nil {
+		return body
+	}
+
+	changed := false
+	kept := make([]json.RawMessage, 0, len(blocks))
+	for _, raw := range blocks {
+		var block struct {
+			Type     string          `json:"type"`
+			Thinking string          `json:"thinking"`
+			Name     string          `json:"name"`
+			Input    json.RawMessage `json:"input"`
+		}
+		if err := json.Unmarshal(raw, &block); err != nil {
+			return body
+		}
+
+		switch {
+		case isThinking(block.Type):
+			c.add(block.Thinking)
+			changed = true
+			continue
+		case respond && block.Type == "tool_use" && block.Name == respondTool:
+			text, err := json.Marshal(map[string]string{"type": "text", "text": string(block.Input)})
+			if err != nil {
+				return body
+			}
+			raw = text
+			changed = true
+		}
+		kept = append(kept, raw)
+	}
+	if respond && string(message["stop_reason"]) == `"tool_use"` {
+		message["stop_reason"] = json.RawMessage(`"end_turn"`)
+		changed = true
+	}
+	if !changed {
+		return body
+	}
+
+	content, err := json.Marshal(kept)
+	if err != nil {
+		return body
+	}
+	message["content"] = content
+	rewritten, err := json.Marshal(message)
+	if err != nil {
+		return body
+	}
+	return rewritten
+}
+
+// openAIChunk collects the reasoning of a streamed chat completion. OpenAI's own
+// models keep their reasoning to themselves over this API, which is why their
+// requests go to the Responses API, but compatible ones send it as
+// reasoning_content, which langchaingo drops.
+func openAIChunk(line []byte, c *Capture) []byte {
+	if data := eventData(line); data != nil {
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					ReasoningContent string `json:"reasoning_content"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal(data, &chunk); err == nil && len(chunk.Choices) > 0 {
+			c.add(chunk.Choices[0].Delta.ReasoningContent)
+		}
+	}
+	return line
+}
+
+func openAICompletion(body []byte, c *Capture) []byte {
+	var completion struct {
+		Choices []struct {
+			Message struct {
+				ReasoningContent string `json:"reasoning_content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(body, &completion); err == nil && len(completion.Choices) > 0 {
+		c.add(completion.Choices[0].Message.ReasoningContent)
+	}
+	return body
+}
+
+// toResponsesRequest turns a chat completions request into one to the Responses
+// API, asking for a summary of the reasoning. Nothing is stored on OpenAI's side,
+// as the chat's history is sent with every request.
+func toResponsesRequest(body map[string]any) {
+	messages, _ := body["messages"].([]any)
+	input := make([]any, 0, len(messages))
+	for _, m := range messages {
+		message, _ := m.(map[string]any)
+		role, _ := message["role"].(string)
+		input = append(input, map[string]any{"role": role, "content": responsesContent(role, message["content"])})
+	}
+
+	reasoning := map[string]any{"summary": "auto"}
+	if effort, ok := body["reasoning_effort"]; ok {
+		reasoning["effort"] = effort
+	}
+	request := map[string]any{
+		"model":     body["model"],
+		"input":     input,
+		"reasoning": reasoning,
+		"store":     false,
+	}
+	if stream, _ := body["stream"].(bool); stream {
+		request["stream"] = true
+	}
+	if maxTokens, ok := body["max_completion_tokens"]; ok {
+		request["max_output_tokens"] = maxTokens
+	}
+	if format, _ := body["response_format"].(map[string]any); format != nil {
+		if schema, _ := format["json_schema"].(map[string]any); schema != nil {
+			request["text"] = map[string]any{"format": map[string]any{
+				"type":   "json_schema",
+				"name":   schema["name"],
+				"schema": schema["schema"],
+			}}
+		}
+	}
+
+	clear(body)
+	maps.Copy(body, request)
+}
+
+// responsesContent turns the content parts of a chat message into those of the
+// Responses API, which tells what the model said apart from what it was given.
+// Text alone is passed as it is.
+func responsesContent(role string, content any) any {
+	parts, ok := content.([]any)
+	if !ok {
+		return content
+	}
+	textType := "input_text"
+	if role == "assistant" {
+		textType = "output_text"
+	}
+
+	converted := make([]any, 0, len(parts))
+	for _, p := range parts {
+		part, _ := p.(map[string]any)
+		switch part["type"] {
+		case "text":
+			converted = append(converted, map[string]any{"type": textType, "text": part["text"]})
+		case "image_url":
+			image, _ := part["image_url"].(map[string]any)
+			converted = append(converted, map[string]any{"type": "input_image", "image_url": image["url"]})
+		default:
+			converted = append(converted, part)
+		}
+	}
+	return converted
+}
+
+// responsesUsage is the token usage of a Responses API reply.
+type responsesUsage struct {
+	InputTokens         int `json:"input_tokens"`
+	OutputTokens        int `json:"output_tokens"`
+	TotalTokens         int `json:"total_tokens"`
+	OutputTokensDetails struct {
+		ReasoningTokens int `json:"reasoning_tokens"`
+	} `json:"output_tokens_details"`
+}
+
+// chatUsage is the usage of a chat completion made of a Responses API reply.
+func (u *responsesUsage) chatUsage() map[string]any {
+	if u == nil {
+		return nil
+	}
+	return map[string]any{
+		"prompt_tokens":             u.InputTokens,
+		"completion_tokens":         u.OutputTokens,
+		"total_tokens":              u.TotalTokens,
+		"completion_tokens_details": map[string]any{"reasoning_tokens": u.OutputTokensDetails.ReasoningTokens},
+	}
+}
+
+// responsesReply is a reply of the Responses API, whole or as it is at the end of
+// a stream.
+type responsesReply struct {
+	ID     string          `json:"id"`
+	Model  string          `json:"model"`
+	Status string          `json:"status"`
+	Usage  *responsesUsage `json:"usage"`
+	Output []struct {
+		Type    string `json:"type"`
+		Summary []struct {
+			Text string `json:"text"`
+		} `json:"summary"`
+		Content []struct {
+			Type    string `json:"type"`
+			Text    string `json:"text"`
+			Refusal string `json:"refusal"`
+		} `json:"content"`
+	} `json:"output"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// finishReason is the chat completion finish reason of the reply's status.
+func (r *responsesReply) finishReason() string {
+	if r.Status == "incomplete" {
+		return "length"
+	}
+	return "stop"
+}
+
+// responsesStream turns the events of a streamed Responses API reply into the
+// chunks of a streamed chat completion, collecting the reasoning summary on the
+// way.
+type responsesStream struct {
+	capture    *Capture
+	summarized bool
+}
+
+func (s *responsesStream) filter(line []byte) ([]byte, error) {
+	data := eventData(line)
+	if data == nil {
+		// Event names and blank lines mean nothing to a chat completion stream.
+		return nil, nil
+	}
+
+	var event struct {
+		Type     string          `json:"type"`
+		Delta    string          `json:"delta"`
+		Message  string          `json:"message"`
+		Response *responsesReply `json:"response"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, err
+	}
+
+	switch event.Type {
+	case "response.reasoning_summary_part.added":
+		if s.summarized {
+			s.capture.add("\n\n")
+		}
+		s.summarized = true
+	case "response.reasoning_summary_text.delta":
+		s.capture.add(event.Delta)
+	case "response.output_text.delta", "response.refusal.delta":
+		return chatChunk(map[string]any{
+			"choices": []any{map[string]any{"index": 0, "delta": map[string]any{"content": event.Delta}}},
+		})
+	case "response.completed", "response.incomplete":
+		chunk, err := chatChunk(map[string]any{
+			"id":      event.Response.ID,
+			"model":   event.Response.Model,
+			"choices": []any{map[string]any{"index": 0, "delta": map[string]any{}, "finish_reason": event.Response.finishReason()}},
+			"usage":   event.Response.Usage.chatUsage(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return append(chunk, "data: [DONE]\n"...), nil
+	case "response.failed":
+		if event.Response != nil && event.Response.Error != nil {
+			return nil, errors.New(event.Response.Error.Message)
+		}
+		return nil, errors.New("the response failed")
+	case "error":
+		return nil, errors.New(event.Message)
+	}
+	return nil, nil
+}
+
+// chatChunk encodes a chunk of a streamed chat completion as its line.
+func chatChunk(chunk map[string]any) ([]byte, error) {
+	chunk["object"] = "chat.completion.chunk"
+	b, err := json.Marshal(chunk)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte("data: "), b...), '\n', '\n'), nil
+}
+
+// responsesCompletion turns a Responses API reply that wasn't streamed into a
+// chat completion, collecting its reasoning summary.
+func responsesCompletion(body []byte, c *Capture) []byte {
+	var reply responsesReply
+	if err := json.Unmarshal(body, &reply); err != nil {
+		return body
+	}
+
+	var summaries []string
+	var content strings.Builder
+	for _, output := range reply.Output {
+		switch output.Type {
+		case "reasoning":
+			for _, summary := range output.Summary {
+				summaries = append(summaries, summary.Text)
+			}
+		case "message":
+			for _, part := range output.Content {
+				content.WriteString(part.Text)
+				content.WriteString(part.Refusal)
+			}
+		}
+	}
+	c.add(strings.Join(summaries, "\n\n"))
+
+	completion, err := json.Marshal(map[string]any{
+		"id":     reply.ID,
+		"object": "chat.completion",
+		"model":  reply.Model,
+		"choices": []any{map[string]any{
+			"index":         0,
+			"message":       map[string]any{"role": "assistant", "content": content.String()},
+			"finish_reason": reply.finishReason(),
+		}},
+		"usage": reply.Usage.chatUsage(),
+	})
+	if err != nil {
+		return body
+	}
+	return completion
+}
@@ -0,0 +1,161 @@
+// Package redact takes secrets and personal data out of text before it leaves
+// the server, replacing each distinct value with a placeholder such as
+// [EMAIL_1], and can put the values back into what comes back.
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// maxPlaceholderLen bounds how long a placeholder is, for a stream to know how
+// much of a chunk to hold back when it ends in what could be the start of one.
+const maxPlaceholderLen = 32
+
+type detector struct {
+	kind    string
+	label   string
+	pattern *regexp.Regexp
+	// group is the submatch that is redacted, 0 for the whole match, for
+	// patterns that need the text around a value to recognise it.
+	group int
+}
+
+// detectors are tried in order, so a value is taken by the most specific one
+// first.
+var detectors = []detector{
+	{kind: "private_key", label: "a private key", pattern: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)},
+	{kind: "aws_access_key", label: "an AWS access key", pattern: regexp.MustCompile(`\b(?:AKIA|ASIA|ABIA|ACCA)[A-Z0-9]{16}\b`)},
+	{kind: "aws_secret_key", label: "an AWS secret key", pattern: regexp.MustCompile(`(?i)\baws_?secret_?(?:access_?)?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+]{40})\b`), group: 1},
+	{kind: "jwt", label: "a JWT", pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)},
+	{kind: "api_key", label: "an API key", pattern: regexp.MustCompile(`\b(?:sk-(?:ant-|proj-)?[A-Za-z0-9_-]{20,}|gh[pousr]_[A-Za-z0-9]{36,}|xox[abprs]-[A-Za-z0-9-]{10,})`)},
+	{kind: "email", label: "an email address", pattern: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`)},
+}
+
+var placeholderPattern = regexp.MustCompile(`\[[A-Z_]+_[0-9]+\]`)
+
+// Redaction is a value that was replaced by a placeholder.
+type Redaction struct {
+	Kind        string
+	Label       string
+	Placeholder string
+}
+
+// Redactor redacts the texts of one generation. A value gets the same
+// placeholder wherever it's found, so the model can still tell values apart and
+// refer to them.
+type Redactor struct {
+	placeholders map[string]string
+	values       map[string]string
+	counts       map[string]int
+}
+
+func New() *Redactor {
+	return &Redactor{
+		placeholders: make(map[string]string),
+		values:       make(map[string]string),
+		counts:       make(map[string]int),
+	}
+}
+
+// Redact returns text with its secrets and personal data replaced by
+// placeholders, and the values that were, once each.
+func (r *Redactor) Redact(text string) (string, []Redaction) {
+	var redactions []Redaction
+	for _, d := range detectors {
+		matches := d.pattern.FindAllStringSubmatchIndex(text, -1)
+		if len(matches) == 0 {
+			continue
+		}
+
+		var b strings.Builder
+		last := 0
+		for _, match := range matches {
+			start, end := match[2*d.group], match[2*d.group+1]
+			if start < 0 {
+				continue
+			}
+			value := text[start:end]
+			placeholder, seen := r.placeholders[value]
+			if !seen {
+				r.counts[d.kind]++
+				placeholder = fmt.Sprintf("[%s_%d]", strings.ToUpper(d.kind), r.counts[d.kind])
+				r.placeholders[value], r.values[placeholder] = placeholder, value
+			}
+			if !slices.ContainsFunc(redactions, func(other Redaction) bool { return other.Placeholder == placeholder }) {
+				redactions = append(redactions, Redaction{Kind: d.kind, Label: d.label, Placeholder: placeholder})
+			}
+			b.WriteString(text[last:start])
+			b.WriteString(placeholder)
+			last = end
+		}
+		b.WriteString(text[last:])
+		text = b.String()
+	}
+	return text, redactions
+}
+
+// Restore puts the values back in place of their placeholders. Placeholders
+// this Redactor didn't make are left as they are.
+func (r *Redactor) Restore(text string) string {
+	if len(r.values) == 0 {
+		return text
+	}
+	return placeholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+		if value, ok := r.values[placeholder]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
+// RestoreJSON puts the values back in place of their placeholders in JSON,
+// escaped as the JSON strings they're in.
+func (r *Redactor) RestoreJSON(data json.RawMessage) json.RawMessage {
+	if len(r.values) == 0 {
+		return data
+	}
+	return placeholderPattern.ReplaceAllFunc(data, func(placeholder []byte) []byte {
+		value, ok := r.values[string(placeholder)]
+		if !ok {
+			return placeholder
+		}
+		escaped, err := json.Marshal(value)
+		if err != nil {
+			return placeholder
+		}
+		return escaped[1 : len(escaped)-1]
+	})
+}
+
+// Stream restores the chunks of a streamed reply. A placeholder can be split
+// across chunks, so the end of a chunk that could be the start of one is held
+// back until the next.
+type Stream struct {
+	redactor *Redactor
+	pending  string
+}
+
+func (r *Redactor) Stream() *Stream {
+	return &Stream{redactor: r}
+}
+
+// Write returns what of the chunk can be sent on, restored.
+func (s *Stream) Write(chunk string) string {
+	text := s.pending + chunk
+	s.pending = ""
+	if open := strings.LastIndexByte(text, '['); open >= 0 && !strings.Contains(text[open:], "]") && len(text)-open < maxPlaceholderLen {
+		text, s.pending = text[:open], text[open:]
+	}
+	return s.redactor.Restore(text)
+}
+
+// Flush returns what is still held back, once the reply is complete.
+func (s *Stream) Flush() string {
+	text := s.pending
+	s.pending = ""
+	return s.redactor.Restore(text)
+}
@@ -19,7 +19,7 @@ func NewErrorResponses(logger *slog.Logger, utils *utils.Utils) *ErrorResponses
 	}
 }
 
-func (er *ErrorResponses) logError(r *http.Request, err error) {
+func (er *ErrorResponses) LogError(r *http.Request, err error) {
 	er.logger.Error(err.Error(), "Method", r.Method, "URL", r.URL.RequestURI())
 }
 
@@ -29,13 +29,13 @@ func (er *ErrorResponses) errorResponse(w http.ResponseWriter, r *http.Request,
 	}
 
 	if err := er.utils.WriteJSON(w, status, env, nil); err != nil {
-		er.logError(r, err)
+		er.LogError(r, err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
 func (er *ErrorResponses) ServerErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
-	er.logError(r, err)
+	er.LogError(r, err)
 	message := "the server encountered a problem and could not process your request"
 	er.errorResponse(w, r, http.StatusInternalServerError, message)
 }
@@ -83,3 +83,19 @@ func (er *ErrorResponses) RateLimitExceededResponse(w http.ResponseWriter, r *ht
 	message := "rate limit exceeded"
 	er.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
+
+// PolicyBlockedResponse tells the user a policy hook blocked their prompt or its
+// reply, and why.
+func (er *ErrorResponses) PolicyBlockedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	er.errorResponse(w, r, http.StatusForbidden, err.Error())
+}
+
+func (er *ErrorResponses) ProviderUnavailableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the model provider could not be reached, please try again later"
+	er.errorResponse(w, r, http.StatusBadGateway, message)
+}
+
+func (er *ErrorResponses) EncryptionUnavailableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "encryption is not available on this server"
+	er.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
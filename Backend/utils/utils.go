@@ -86,6 +86,19 @@ func (utils *Utils) ReadInt(qs url.Values, key string, defaultValue int, v *vali
 	return i
 }
 
+func (utils *Utils) ReadBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+	return b
+}
+
 func (utils *Utils) Background(fn func()) {
 	utils.wg.Add(1)
 
@@ -100,6 +113,11 @@ func (utils *Utils) Background(fn func()) {
 	}()
 }
 
+// Wait blocks until every goroutine started with Background has returned.
+func (utils *Utils) Wait() {
+	utils.wg.Wait()
+}
+
 func (utils *Utils) ReadJSON(w http.ResponseWriter, r *http.Request, dst any) error {
 	const maxBytes = 1_048_576
 	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
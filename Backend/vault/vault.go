@@ -0,0 +1,251 @@
+// Package vault encrypts secrets at rest with envelope encryption. Every secret
+// gets its own random data key, and that data key is wrapped by a key
+// encryption key (KEK) from config. Rotating the KEK only means rewrapping the
+// data keys, the secrets themselves are never re-encrypted.
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrNoKey      = errors.New("no encryption key configured")
+	ErrUnknownKey = errors.New("secret was sealed with an unknown key")
+	ErrMalformed  = errors.New("malformed sealed secret")
+)
+
+// Keyring holds the key encryption keys by ID. The active key seals new secrets,
+// the rest are only kept so secrets sealed before a rotation can still be opened.
+type Keyring struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// NewKeyring parses a comma separated list of "id:base64key" pairs, e.g.
+// "2:...,1:...". The first key is the active one. Keys must be 32 bytes.
+func NewKeyring(spec string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(pair, ":")
+		if !ok || id == "" || strings.ContainsAny(id, ".") {
+			return nil, fmt.Errorf("invalid key entry %q", id)
+		}
+		if _, exists := k.keys[id]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %q must be 32 bytes, got %d", id, len(key))
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = aead
+		if k.active == "" {
+			k.active = id
+		}
+	}
+
+	return k, nil
+}
+
+// ActiveKeyID is the ID of the key new secrets are sealed with.
+func (k *Keyring) ActiveKeyID() string {
+	return k.active
+}
+
+// Seal encrypts plaintext and returns it as "keyID.wrappedDataKey.ciphertext".
+func (k *Keyring) Seal(plaintext []byte) (string, error) {
+	return k.SealWith(plaintext, "")
+}
+
+// SealWith is Seal binding the secret to associatedData, such as the row it's
+// stored in. It only opens with the same associated data, so a secret copied
+// into another row won't.
+func (k *Keyring) SealWith(plaintext []byte, associatedData string) (string, error) {
+	if k.active == "" {
+		return "", ErrNoKey
+	}
+
+	dataKey, err := NewDataKey()
+	if err != nil {
+		return "", err
+	}
+
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataAEAD, plaintext, []byte(associatedData))
+	if err != nil {
+		return "", err
+	}
+
+	wrapped, err := seal(k.keys[k.active], dataKey, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return k.active + "." + base64.RawURLEncoding.EncodeToString(wrapped) + "." + base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+// Open decrypts a secret sealed by Seal with any key still in the keyring.
+func (k *Keyring) Open(sealed string) ([]byte, error) {
+	return k.OpenWith(sealed, "")
+}
+
+// OpenWith decrypts a secret sealed by SealWith with the same associated data.
+func (k *Keyring) OpenWith(sealed string, associatedData string) ([]byte, error) {
+	dataKey, ciphertext, err := k.unwrap(sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return open(dataAEAD, ciphertext, []byte(associatedData))
+}
+
+// Rewrap wraps the data key of a sealed secret with the active key. It reports
+// false when the secret already uses the active key and nothing changed. The
+// ciphertext is left as it is, bound to the same associated data.
+func (k *Keyring) Rewrap(sealed string) (string, bool, error) {
+	if k.active == "" {
+		return "", false, ErrNoKey
+	}
+	if strings.HasPrefix(sealed, k.active+".") {
+		return sealed, false, nil
+	}
+
+	dataKey, ciphertext, err := k.unwrap(sealed)
+	if err != nil {
+		return "", false, err
+	}
+
+	wrapped, err := seal(k.keys[k.active], dataKey, nil)
+	if err != nil {
+		return "", false, err
+	}
+
+	return k.active + "." + base64.RawURLEncoding.EncodeToString(wrapped) + "." + base64.RawURLEncoding.EncodeToString(ciphertext), true, nil
+}
+
+func (k *Keyring) unwrap(sealed string) ([]byte, []byte, error) {
+	parts := strings.Split(sealed, ".")
+	if len(parts) != 3 {
+		return nil, nil, ErrMalformed
+	}
+
+	kek, ok := k.keys[parts[0]]
+	if !ok {
+		return nil, nil, ErrUnknownKey
+	}
+
+	wrapped, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, ErrMalformed
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, ErrMalformed
+	}
+
+	dataKey, err := open(kek, wrapped, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dataKey, ciphertext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal prepends a random nonce to the ciphertext.
+func seal(aead cipher.AEAD, plaintext []byte, ad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, ad), nil
+}
+
+func open(aead cipher.AEAD, ciphertext []byte, ad []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, ad)
+}
+
+// Cipher encrypts many small values, such as chat messages, with one data key.
+// The associated data binds every ciphertext to its owner, so a value copied
+// into another user's rows won't open.
+type Cipher struct {
+	aead cipher.AEAD
+	ad   []byte
+}
+
+// NewDataKey returns a random key for NewCipher.
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func NewCipher(key []byte, associatedData string) (*Cipher, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead, ad: []byte(associatedData)}, nil
+}
+
+// Seal returns the nonce and ciphertext as base64.
+func (c *Cipher) Seal(plaintext string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(c.aead.Seal(nonce, nonce, []byte(plaintext), c.ad)), nil
+}
+
+func (c *Cipher) Open(sealed string) (string, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(ciphertext) < c.aead.NonceSize() {
+		return "", ErrMalformed
+	}
+
+	nonce, ciphertext := ciphertext[:c.aead.NonceSize()], ciphertext[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, c.ad)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
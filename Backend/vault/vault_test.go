@@ -0,0 +1,201 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func mustKeyring(t *testing.T, spec string) *Keyring {
+	t.Helper()
+	k, err := NewKeyring(spec)
+	if err != nil {
+		t.Fatalf("NewKeyring(%q) error = %v", spec, err)
+	}
+	return k
+}
+
+func TestNewKeyring(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   string
+		active string
+		valid  bool
+	}{
+		{"empty", "", "", true},
+		{"single key", "1:" + testKey(1), "1", true},
+		{"first is active", "2:" + testKey(2) + ", 1:" + testKey(1), "2", true},
+		{"empty entries skipped", ",1:" + testKey(1) + ",,", "1", true},
+		{"duplicate id", "1:" + testKey(1) + ",1:" + testKey(2), "", false},
+		{"short key", "1:" + base64.StdEncoding.EncodeToString(make([]byte, 16)), "", false},
+		{"long key", "1:" + base64.StdEncoding.EncodeToString(make([]byte, 33)), "", false},
+		{"not base64", "1:not base64!", "", false},
+		{"id with dot", "a.b:" + testKey(1), "", false},
+		{"empty id", ":" + testKey(1), "", false},
+		{"no separator", testKey(1), "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := NewKeyring(tt.spec)
+			if (err == nil) != tt.valid {
+				t.Fatalf("NewKeyring(%q) error = %v, want valid %t", tt.spec, err, tt.valid)
+			}
+			if err == nil && k.ActiveKeyID() != tt.active {
+				t.Errorf("ActiveKeyID() = %q, want %q", k.ActiveKeyID(), tt.active)
+			}
+		})
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	k := mustKeyring(t, "1:"+testKey(1))
+
+	for _, plaintext := range []string{"", "sk-test-1234", strings.Repeat("x", 4096)} {
+		sealed, err := k.Seal([]byte(plaintext))
+		if err != nil {
+			t.Fatalf("Seal() error = %v", err)
+		}
+		if !strings.HasPrefix(sealed, "1.") {
+			t.Errorf("Seal() = %q, want it sealed with key 1", sealed)
+		}
+
+		got, err := k.Open(sealed)
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		if string(got) != plaintext {
+			t.Errorf("Open() = %q, want %q", got, plaintext)
+		}
+	}
+}
+
+func TestSealNoKey(t *testing.T) {
+	k := mustKeyring(t, "")
+	if _, err := k.Seal([]byte("secret")); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Seal() error = %v, want %v", err, ErrNoKey)
+	}
+	if _, _, err := k.Rewrap("1.a.b"); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Rewrap() error = %v, want %v", err, ErrNoKey)
+	}
+}
+
+func TestSealWithOpenWith(t *testing.T) {
+	k := mustKeyring(t, "1:"+testKey(1))
+
+	tests := []struct {
+		name   string
+		sealAD string
+		openAD string
+		valid  bool
+	}{
+		{"matching", "api_key:u1:OpenAI", "api_key:u1:OpenAI", true},
+		{"other user", "api_key:u1:OpenAI", "api_key:u2:OpenAI", false},
+		{"other model type", "api_key:u1:OpenAI", "api_key:u1:Google", false},
+		{"bound, opened unbound", "api_key:u1:OpenAI", "", false},
+		{"unbound, opened bound", "", "api_key:u1:OpenAI", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed, err := k.SealWith([]byte("secret"), tt.sealAD)
+			if err != nil {
+				t.Fatalf("SealWith() error = %v", err)
+			}
+
+			got, err := k.OpenWith(sealed, tt.openAD)
+			if (err == nil) != tt.valid {
+				t.Fatalf("OpenWith(%q) error = %v, want valid %t", tt.openAD, err, tt.valid)
+			}
+			if err == nil && string(got) != "secret" {
+				t.Errorf("OpenWith() = %q, want %q", got, "secret")
+			}
+		})
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	old := mustKeyring(t, "1:"+testKey(1))
+	sealed, err := old.SealWith([]byte("secret"), "ad")
+	if err != nil {
+		t.Fatalf("SealWith() error = %v", err)
+	}
+
+	rotated := mustKeyring(t, "2:"+testKey(2)+",1:"+testKey(1))
+	rewrapped, changed, err := rotated.Rewrap(sealed)
+	if err != nil {
+		t.Fatalf("Rewrap() error = %v", err)
+	}
+	if !changed || !strings.HasPrefix(rewrapped, "2.") {
+		t.Fatalf("Rewrap() = %q, %t, want it moved to key 2", rewrapped, changed)
+	}
+
+	// Once the old key is dropped, only the rewrapped secret still opens.
+	current := mustKeyring(t, "2:"+testKey(2))
+	got, err := current.OpenWith(rewrapped, "ad")
+	if err != nil || string(got) != "secret" {
+		t.Errorf("OpenWith(rewrapped) = %q, %v, want %q", got, err, "secret")
+	}
+	if _, err := current.OpenWith(sealed, "ad"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("OpenWith(sealed) error = %v, want %v", err, ErrUnknownKey)
+	}
+
+	again, changed, err := rotated.Rewrap(rewrapped)
+	if err != nil || changed || again != rewrapped {
+		t.Errorf("Rewrap(rewrapped) = %q, %t, %v, want it unchanged", again, changed, err)
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	k := mustKeyring(t, "1:"+testKey(1))
+	sealed, err := k.Seal([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	parts := strings.Split(sealed, ".")
+
+	ciphertext, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	ciphertext[len(ciphertext)-1] ^= 1
+	tampered := parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(ciphertext)
+
+	other := mustKeyring(t, "1:"+testKey(9))
+	otherSealed, err := other.Seal([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		sealed string
+		err    error
+	}{
+		{"empty", "", ErrMalformed},
+		{"too few parts", parts[0] + "." + parts[1], ErrMalformed},
+		{"too many parts", sealed + ".x", ErrMalformed},
+		{"unknown key id", "7." + parts[1] + "." + parts[2], ErrUnknownKey},
+		{"bad wrapped key", parts[0] + ".!!." + parts[2], ErrMalformed},
+		{"bad ciphertext", parts[0] + "." + parts[1] + ".!!", ErrMalformed},
+		{"short wrapped key", parts[0] + ".AA." + parts[2], ErrMalformed},
+		{"short ciphertext", parts[0] + "." + parts[1] + ".AA", ErrMalformed},
+		{"same id, other key", otherSealed, nil},
+		{"tampered ciphertext", tampered, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := k.Open(tt.sealed)
+			if err == nil {
+				t.Fatalf("Open(%q) succeeded, want an error", tt.sealed)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("Open(%q) error = %v, want %v", tt.sealed, err, tt.err)
+			}
+		})
+	}
+}